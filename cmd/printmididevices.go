@@ -10,8 +10,8 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	for name, _ := range devices {
-		fmt.Println(name)
+	for name, device := range devices {
+		fmt.Printf("%v (%v)\n", name, device.Interface)
 	}
 	
 }
\ No newline at end of file
@@ -0,0 +1,139 @@
+package midi
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A PortBroker lets many parts of a program share a system device, whose
+// ports can only be opened once. Each Device acquired for a device receives
+// every message read from the device's output port, and the messages sent to
+// each of them are merged into its input port. The system device is opened
+// when the first Device is acquired and closed when the last is released.
+type PortBroker struct {
+	mu     sync.Mutex
+	shared map[string]*sharedDevice
+	open   func(name string) (*Wires, func() error, error) // Replaces openSystemDevice in tests.
+}
+
+// A system device opened by a PortBroker and the Devices sharing it.
+type sharedDevice struct {
+	name    string
+	wires   *Wires
+	close   func() error
+	clients map[*Device]bool
+	done    chan bool // Closed to stop sending the device's messages to the clients.
+}
+
+func NewPortBroker() *PortBroker {
+	return &PortBroker{
+		shared: make(map[string]*sharedDevice),
+		open:   openSystemDevice,
+	}
+}
+
+// Opens and connects the system device named name.
+func openSystemDevice(name string) (*Wires, func() error, error) {
+	d, ok := getSystemDevices()[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("System device %q: %w", name, ErrNoDevice)
+	}
+	if err := d.Open(); err != nil {
+		return nil, nil, err
+	}
+	d.Connect()
+	return &d.Wires, d.Close, nil
+}
+
+// Returns a new, open Device sharing the system device named name, opening
+// the system device if no other Device shares it. Release the Device rather
+// than closing it.
+func (b *PortBroker) Acquire(name string) (*Device, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.shared[name]
+	if !ok {
+		wires, closeDevice, err := b.open(name)
+		if err != nil {
+			return nil, err
+		}
+		s = &sharedDevice{
+			name:    name,
+			wires:   wires,
+			close:   closeDevice,
+			clients: make(map[*Device]bool),
+			done:    make(chan bool),
+		}
+		b.shared[name] = s
+		go b.fanOut(s)
+	}
+	// Its wires are its ports' buffered channels.
+	d := NewDeviceFromPorts(NewPort(true), NewPort(true))
+	s.clients[d] = true
+	if s.wires.In != nil {
+		go b.fanIn(s, d)
+	}
+	return d, nil
+}
+
+// Sends each message sent to d to the shared device, until d is released or
+// the shared device is closed.
+func (b *PortBroker) fanIn(s *sharedDevice, d *Device) {
+	for {
+		select {
+		case m := <-d.In:
+			select {
+			case s.wires.In <- m:
+			case <-s.done:
+				return
+			}
+		case <-d.Done():
+			return
+		}
+	}
+}
+
+// Sends each message read from the shared device to every Device sharing it.
+// A Device that isn't keeping up misses messages rather than holding up the others.
+func (b *PortBroker) fanOut(s *sharedDevice) {
+	for {
+		select {
+		case m, ok := <-s.wires.Out:
+			if !ok {
+				return
+			}
+			b.mu.Lock()
+			for d := range s.clients {
+				select {
+				case d.Out <- m:
+				default:
+					Debug.Printf("Port broker: dropped %v from %q for a full device", m, s.name)
+				}
+			}
+			b.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Stops d sharing its system device and closes it, closing the system device
+// once no Device shares it.
+func (b *PortBroker) Release(d *Device) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, s := range b.shared {
+		if !s.clients[d] {
+			continue
+		}
+		delete(s.clients, d)
+		d.Close()
+		if len(s.clients) > 0 {
+			return nil
+		}
+		delete(b.shared, name)
+		close(s.done)
+		return s.close()
+	}
+	return fmt.Errorf("Port broker: %w", ErrPortNotOpen)
+}
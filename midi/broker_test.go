@@ -0,0 +1,74 @@
+package midi
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPortBroker(t *testing.T) {
+	shared := NewWires()
+	opened, closed := 0, 0
+	b := NewPortBroker()
+	b.open = func(name string) (*Wires, func() error, error) {
+		if name != "Synth" {
+			return nil, nil, ErrNoDevice
+		}
+		opened++
+		return shared, func() error { closed++; return nil }, nil
+	}
+	if _, err := b.Acquire("Drums"); !errors.Is(err, ErrNoDevice) {
+		t.Errorf("Received %v from acquiring a missing device instead of %v", err, ErrNoDevice)
+	}
+	d1, err := b.Acquire("Synth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, err := b.Acquire("Synth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != 1 {
+		t.Errorf("Opened the shared device %d times instead of once", opened)
+	}
+
+	shared.Out <- NoteOn{0, 60, 100}
+	for i, d := range []*Device{d1, d2} {
+		select {
+		case m := <-d.Out:
+			if m != (NoteOn{0, 60, 100}) {
+				t.Errorf("Device %d received %v instead of the message read", i, m)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Device %d received nothing read from the shared device", i)
+		}
+	}
+
+	d1.In <- NoteOn{0, 62, 100}
+	d2.In <- NoteOn{1, 64, 100}
+	written := make(map[Message]bool)
+	for len(written) < 2 {
+		select {
+		case m := <-shared.In:
+			written[m] = true
+		case <-time.After(time.Second):
+			t.Fatalf("Wrote %v to the shared device instead of the messages of both devices", written)
+		}
+	}
+
+	if err := b.Release(d1); err != nil {
+		t.Error(err)
+	}
+	if closed != 0 {
+		t.Error("Closed the shared device while a device still shares it")
+	}
+	if err := b.Release(d1); !errors.Is(err, ErrPortNotOpen) {
+		t.Errorf("Received %v from releasing a device twice instead of %v", err, ErrPortNotOpen)
+	}
+	if err := b.Release(d2); err != nil {
+		t.Error(err)
+	}
+	if closed != 1 {
+		t.Errorf("Closed the shared device %d times after releasing every device instead of once", closed)
+	}
+}
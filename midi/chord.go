@@ -0,0 +1,164 @@
+package midi
+
+import (
+	"sort"
+	"sync"
+)
+
+// A ChordMemory transmits MIDI data from one device to another, playing a
+// stored chord for each note: the chord's lowest note is transposed to the
+// note played. A chord is stored by calling Learn and then playing it; it
+// is captured once all of its notes are released. Until a chord is stored
+// notes are sent unchanged.
+// Implements Connector, one to one.
+type ChordMemory struct {
+	Name       string // Identifies the connector in logs.
+	From       *Device
+	To         *Device
+	mu         sync.Mutex
+	chord      []int // Semitones above the note played, starting with 0.
+	learning   bool
+	held       map[[2]int]bool // Notes held while learning, by channel and key.
+	learned    map[int]bool    // Keys held at once while learning.
+	disconnect chan bool
+	sounding   map[[2]int][]int // The keys sounded for each note held, by channel and key.
+}
+
+// Creates a new ChordMemory between the devices sent as parameters.
+func NewChordMemory(from, to *Device) *ChordMemory {
+	return &ChordMemory{
+		From:       from,
+		To:         to,
+		sounding:   make(map[[2]int][]int),
+		disconnect: make(chan bool, 1),
+	}
+}
+
+// Captures the next notes held together as the chord, sending them unchanged meanwhile.
+func (c *ChordMemory) Learn() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.learning = true
+	c.held = make(map[[2]int]bool)
+	c.learned = make(map[int]bool)
+}
+
+// Returns the stored chord, as semitones above the note played.
+func (c *ChordMemory) Chord() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.chord...)
+}
+
+// Stores a chord, as semitones above the note played, e.g. 0, 4, 7 for a major triad.
+func (c *ChordMemory) SetChord(intervals ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.chord = append([]int(nil), intervals...)
+}
+
+func (c *ChordMemory) Open() error {
+	if err := c.From.Open(); err != nil {
+		return err
+	}
+	return c.To.Open()
+}
+
+// Ends transmission of MIDI data and closes the connected MIDI devices.
+func (c *ChordMemory) Close() error {
+	Debug.Printf("%v closed", c)
+	c.disconnect <- true
+	if err := c.From.Close(); err != nil {
+		return err
+	}
+	return c.To.Close()
+}
+
+// Begins transmission of MIDI data between the connected MIDI devices.
+func (c *ChordMemory) Connect() {
+	Debug.Printf("%v connected", c)
+	go c.From.Connect()
+	go c.To.Connect()
+	for {
+		select {
+		case m := <-c.From.Out:
+			for _, m := range c.play(m) {
+				if !c.To.sendFrom(m, c.disconnect) {
+					return
+				}
+			}
+		case <-c.disconnect:
+			return
+		}
+	}
+}
+
+// Returns the messages sent for a message received.
+func (c *ChordMemory) play(m Message) []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var channel, key int
+	on := false
+	switch n := m.(type) {
+	case NoteOn:
+		channel, key, on = n.Channel, n.Key, n.Velocity > 0
+	case NoteOff:
+		channel, key = n.Channel, n.Key
+	default:
+		return []Message{m}
+	}
+	// The keys sounded for a note are released, even if the chord has changed since.
+	if keys, ok := c.sounding[[2]int{channel, key}]; ok && !on {
+		delete(c.sounding, [2]int{channel, key})
+		var messages []Message
+		for _, k := range keys {
+			messages = append(messages, NoteOff{channel, k, 0})
+		}
+		return messages
+	}
+	if c.learning {
+		c.learn(channel, key, on)
+		return []Message{m}
+	}
+	if len(c.chord) == 0 || !on {
+		return []Message{m}
+	}
+	var messages []Message
+	for _, interval := range c.chord {
+		k := key + interval
+		if k < 0 || k > 127 {
+			continue
+		}
+		messages = append(messages, NoteOn{channel, k, m.(NoteOn).Velocity})
+		c.sounding[[2]int{channel, key}] = append(c.sounding[[2]int{channel, key}], k)
+	}
+	return messages
+}
+
+// Tracks the notes held while learning, storing the chord once all are released.
+func (c *ChordMemory) learn(channel, key int, on bool) {
+	if on {
+		c.held[[2]int{channel, key}] = true
+		c.learned[key] = true
+		return
+	}
+	delete(c.held, [2]int{channel, key})
+	if len(c.held) > 0 || len(c.learned) == 0 {
+		return
+	}
+	var keys []int
+	for k := range c.learned {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	c.chord = nil
+	for _, k := range keys {
+		c.chord = append(c.chord, k-keys[0])
+	}
+	c.learning = false
+	Debug.Printf("%v learned chord %v", c, c.chord)
+}
+
+func (c *ChordMemory) String() string {
+	return connectorName("ChordMemory", c.Name)
+}
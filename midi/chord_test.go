@@ -0,0 +1,48 @@
+package midi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChordMemory(t *testing.T) {
+	from, to := NewDevice(), NewDevice()
+	c := NewChordMemory(from, to)
+	go c.Connect()
+	defer c.Close()
+
+	send := func(m Message, expected ...Message) {
+		from.Out <- m
+		for _, e := range expected {
+			if actual := <-to.In; actual != e {
+				t.Errorf("Received %v from %v instead of %v", actual, m, e)
+			}
+		}
+	}
+	send(NoteOn{0, 60, 100}, NoteOn{0, 60, 100}) // No chord is stored.
+	send(NoteOff{0, 60, 0}, NoteOff{0, 60, 0})
+
+	c.Learn()
+	send(NoteOn{0, 57, 100}, NoteOn{0, 57, 100}) // A minor.
+	send(NoteOn{0, 60, 100}, NoteOn{0, 60, 100})
+	send(NoteOn{0, 64, 100}, NoteOn{0, 64, 100})
+	send(NoteOff{0, 60, 0}, NoteOff{0, 60, 0})
+	send(NoteOff{0, 57, 0}, NoteOff{0, 57, 0})
+	send(NoteOn{0, 57, 0}, NoteOn{0, 57, 0}) // A repeated note off.
+	send(NoteOff{0, 64, 0}, NoteOff{0, 64, 0})
+	if chord := c.Chord(); !reflect.DeepEqual(chord, []int{0, 3, 7}) {
+		t.Fatalf("Learned %v instead of a minor triad", chord)
+	}
+
+	send(NoteOn{1, 62, 90}, NoteOn{1, 62, 90}, NoteOn{1, 65, 90}, NoteOn{1, 69, 90})
+	send(NoteOff{1, 62, 0}, NoteOff{1, 62, 0}, NoteOff{1, 65, 0}, NoteOff{1, 69, 0})
+	send(ControlChange{1, 64, 127, ""}, ControlChange{1, 64, 127, ""})
+
+	// Notes held when the chord changes release the keys they sounded.
+	send(NoteOn{2, 60, 80}, NoteOn{2, 60, 80}, NoteOn{2, 63, 80}, NoteOn{2, 67, 80})
+	c.SetChord(0, 4)
+	send(NoteOff{2, 60, 0}, NoteOff{2, 60, 0}, NoteOff{2, 63, 0}, NoteOff{2, 67, 0})
+	send(NoteOn{2, 60, 80}, NoteOn{2, 60, 80}, NoteOn{2, 64, 80})
+	c.Learn()
+	send(NoteOn{2, 60, 0}, NoteOff{2, 60, 0}, NoteOff{2, 64, 0})
+}
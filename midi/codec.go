@@ -0,0 +1,94 @@
+package midi
+
+/*
+A compact binary encoding of Messages, for sending MIDI data over networks
+or serial lines. Each message is encoded as a one byte type tag followed by
+its payload. The tag is the message's status byte, so the encoding of most
+messages is the same as on the MIDI wire. The exceptions are:
+    SysEx: 0xF0, the length of the data (as a uvarint), then the data.
+    Any other message, or a RawMessage: 0x00, then the 32-bit message
+    (little-endian.)
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const rawTag = 0x00
+
+// Writes a message in the compact binary encoding.
+func EncodeMessage(w io.Writer, m Message) error {
+	_, err := w.Write(appendMessage(nil, m))
+	return err
+}
+
+func appendMessage(b []byte, m Message) []byte {
+	switch n := m.(type) {
+	case SysEx:
+		var length [binary.MaxVarintLen64]byte
+		b = append(b, byte(SYSEX))
+		b = append(b, length[:binary.PutUvarint(length[:], uint64(len(n.Data)))]...)
+		return append(b, n.Data...)
+	case message, RawMessage:
+		return binary.LittleEndian.AppendUint32(append(b, rawTag), n.Uint32())
+	}
+	return append(b, MessageBytes(m)...)
+}
+
+// Reads a message in the compact binary encoding.
+func DecodeMessage(r io.Reader) (Message, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = byteReader{r}
+	}
+	tag, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch int(tag) {
+	case rawTag:
+		var u [4]byte
+		if _, err := io.ReadFull(r, u[:]); err != nil {
+			return nil, err
+		}
+		return newMessage(binary.LittleEndian.Uint32(u[:])).typed(nil), nil
+	case SYSEX:
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxSysExLength {
+			return nil, fmt.Errorf("SysEx message of %d bytes is too long", n)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return SysEx{data}, nil
+	}
+	length := MessageLength(int(tag))
+	if length == 0 {
+		return nil, fmt.Errorf("Invalid message type: 0x%02X", tag)
+	}
+	b := make([]byte, length)
+	b[0] = tag
+	if _, err := io.ReadFull(r, b[1:]); err != nil {
+		return nil, err
+	}
+	return ParseMessage(b)
+}
+
+// The longest SysEx message that will be decoded.
+const maxSysExLength = 1 << 16
+
+type byteReader struct {
+	io.Reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	var c [1]byte
+	_, err := io.ReadFull(b.Reader, c[:])
+	return c[0], err
+}
@@ -0,0 +1,56 @@
+package midi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		m      Message
+		length int
+	}{
+		{NoteOn{1, 60, 100}, 3},
+		{NoteOff{1, 60, 64}, 3},
+		{PolyAftertouch{1, 60, 80}, 3},
+		{ControlChange{2, 7, 100, ControlChangeNames[7]}, 3},
+		{ProgramChange{3, 42}, 2},
+		{ChannelPressure{4, 90}, 2},
+		{PitchBend{5, 12000}, 3},
+		{SysEx{[]byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}}, 8},
+		{Clock{}, 1},
+		{Start{}, 1},
+		{Continue{}, 1},
+		{Stop{}, 1},
+		{SongPosition{1000}, 3},
+		{SongSelect{5}, 2},
+		{QuarterFrame{7, 3}, 2},
+		{*newMessage(0x01020304), 5},
+		{RawMessage{NoteOn{1, 0x40, 0x3C}, 0x7F3C4091}, 5},
+	}
+	var stream bytes.Buffer
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err := EncodeMessage(&b, test.m); err != nil {
+			t.Errorf("Could not encode %v: %v", test.m, err)
+		}
+		if b.Len() != test.length {
+			t.Errorf("Encoded %v as % X instead of in %d bytes", test.m, b.Bytes(), test.length)
+		}
+		stream.Write(b.Bytes())
+	}
+	for _, test := range tests {
+		actual, err := DecodeMessage(&stream)
+		if err != nil {
+			t.Errorf("Could not decode %v: %v", test.m, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.m) {
+			t.Errorf("Decoded %v instead of %v", actual, test.m)
+		}
+	}
+	if _, err := DecodeMessage(bytes.NewReader([]byte{0x90, 60})); err == nil {
+		t.Errorf("Decoded a truncated message without error")
+	}
+}
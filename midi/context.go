@@ -0,0 +1,38 @@
+package midi
+
+import "context"
+
+// Opens a device, port or connector, returning ctx's error if ctx is done
+// before it opens, as when opening a system device blocks. If it opens after
+// all, it is closed again, if it's a Closer.
+func OpenContext(ctx context.Context, o Opener) error {
+	opened := make(chan error, 1)
+	go func() {
+		opened <- o.Open()
+	}()
+	select {
+	case err := <-opened:
+		return err
+	case <-ctx.Done():
+		go func() {
+			if err := <-opened; err == nil {
+				if c, ok := o.(Closer); ok {
+					c.Close()
+				}
+			}
+		}()
+		return ctx.Err()
+	}
+}
+
+// Connects a device, port or connector and keeps it connected until ctx is
+// done, then closes it, if it's a Closer, returning the error from closing it.
+// It replaces calling Connect in a goroutine and later calling Close.
+func Run(ctx context.Context, c Connecter) error {
+	go c.Connect()
+	<-ctx.Done()
+	if closer, ok := c.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package midi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// An Opener whose Open blocks until it is released.
+type slowOpener struct {
+	release chan bool
+	closed  chan bool
+}
+
+func (s slowOpener) Open() error {
+	<-s.release
+	return nil
+}
+
+func (s slowOpener) Close() error {
+	s.closed <- true
+	return nil
+}
+
+func TestOpenContext(t *testing.T) {
+	s := slowOpener{make(chan bool), make(chan bool, 1)}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := OpenContext(ctx, s); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Received %v from a blocked open instead of %v", err, context.DeadlineExceeded)
+	}
+	s.release <- true
+	select {
+	case <-s.closed:
+	case <-time.After(time.Second):
+		t.Error("Didn't close what opened after the context was done")
+	}
+	if err := OpenContext(context.Background(), NewDevice()); err != nil {
+		t.Errorf("Received %v from opening a device", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	src, dst := NewMemDevice(), NewMemDevice()
+	defer dst.Close()
+	pipe := NewPipe(src.Device, dst.Device)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- Run(ctx, pipe) }()
+	src.Send(NoteOn{0, 60, 100})
+	if actual := waitForReceived(dst, 1); len(actual) != 1 {
+		t.Errorf("Received %v while running instead of a note on", actual)
+	}
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Received %v from closing the pipe", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Didn't stop running once the context was cancelled")
+	}
+}
@@ -0,0 +1,129 @@
+package midi
+
+// A Crossfade transmits MIDI data from one MIDI device to two others, sending
+// each note to both with its velocity weighted by the position of a fader,
+// set by a control change: at 0 notes are sent only to A, at 127 only to B.
+// A note's note off is sent wherever its note on was. Other MIDI data is
+// sent to both devices.
+// Implements Connector, one to many.
+type Crossfade struct {
+	Name       string // Identifies the connector in logs.
+	From       *Device
+	A, B       *Device
+	Control    int // The ID of the control change that sets the fader, which isn't sent on.
+	position   int
+	notes      map[[2]int][]*Device // Destinations of sounding notes, by channel and key.
+	disconnect chan bool
+}
+
+// Creates a new Crossfade between the devices sent as parameters, faded by
+// the control change with the ID and starting in the middle.
+func NewCrossfade(from, a, b *Device, control int) *Crossfade {
+	return &Crossfade{
+		From:       from,
+		A:          a,
+		B:          b,
+		Control:    control,
+		position:   64,
+		notes:      make(map[[2]int][]*Device),
+		disconnect: make(chan bool, 1),
+	}
+}
+
+func (c *Crossfade) Open() error {
+	for _, d := range []*Device{c.A, c.B, c.From} {
+		if err := d.Open(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ends transmission of MIDI data and closes the connected MIDI devices.
+func (c *Crossfade) Close() error {
+	Debug.Printf("%v closed", c)
+	c.disconnect <- true
+	for _, d := range []*Device{c.From, c.A, c.B} {
+		if err := d.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Begins transmission of MIDI data between the connected MIDI devices.
+func (c *Crossfade) Connect() {
+	Debug.Printf("%v connected", c)
+	go c.From.Connect()
+	go c.A.Connect()
+	go c.B.Connect()
+	for {
+		select {
+		case m := <-c.From.Out:
+			switch n := m.(type) {
+			case ControlChange:
+				if n.ID == c.Control {
+					c.position = n.Value
+					continue
+				}
+			case NoteOn:
+				if n.Velocity > 0 {
+					if !c.play(n) {
+						return
+					}
+					continue
+				}
+				if !c.release(n.Channel, n.Key, m) {
+					return
+				}
+				continue
+			case NoteOff:
+				if !c.release(n.Channel, n.Key, m) {
+					return
+				}
+				continue
+			}
+			if !c.A.sendFrom(m, c.disconnect) || !c.B.sendFrom(m, c.disconnect) {
+				return
+			}
+		case <-c.disconnect:
+			return
+		}
+	}
+}
+
+// Sends a note on to each device with its weighted velocity, if that isn't 0.
+// Returns false if a device or the crossfade is closed meanwhile.
+func (c *Crossfade) play(n NoteOn) bool {
+	note := [2]int{n.Channel, n.Key}
+	for _, d := range []*Device{c.A, c.B} {
+		weight := c.position
+		if d == c.A {
+			weight = 127 - c.position
+		}
+		if velocity := (n.Velocity*weight + 63) / 127; velocity > 0 {
+			c.notes[note] = append(c.notes[note], d)
+			if !d.sendFrom(NoteOn{n.Channel, n.Key, velocity}, c.disconnect) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Sends a note off to wherever its note on was sent. Returns false if a
+// device or the crossfade is closed meanwhile.
+func (c *Crossfade) release(channel, key int, m Message) bool {
+	note := [2]int{channel, key}
+	for _, d := range c.notes[note] {
+		if !d.sendFrom(m, c.disconnect) {
+			return false
+		}
+	}
+	delete(c.notes, note)
+	return true
+}
+
+func (c *Crossfade) String() string {
+	return connectorName("Crossfade", c.Name)
+}
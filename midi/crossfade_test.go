@@ -0,0 +1,39 @@
+package midi
+
+import "testing"
+
+func TestCrossfade(t *testing.T) {
+	from, a, b := NewDevice(), NewDevice(), NewDevice()
+	c := NewCrossfade(from, a, b, 1)
+	go c.Connect()
+	defer c.Close()
+
+	tests := []struct {
+		position int
+		a, b     int // Velocities sent to each device, or 0 for none.
+	}{
+		{0, 100, 0},
+		{32, 75, 25},
+		{64, 50, 50},
+		{96, 24, 76},
+		{127, 0, 100},
+	}
+	for _, test := range tests {
+		// Messages sent to a device, if the note's velocity there isn't 0.
+		expect := func(d *Device, velocity int, expected Message) {
+			if velocity == 0 {
+				return
+			}
+			if m := <-d.In; m != expected {
+				t.Errorf("Received %v at fader position %d instead of %v", m, test.position, expected)
+			}
+		}
+		from.Out <- ControlChange{0, 1, test.position, ""}
+		from.Out <- NoteOn{0, 60, 100}
+		expect(a, test.a, NoteOn{0, 60, test.a})
+		expect(b, test.b, NoteOn{0, 60, test.b})
+		from.Out <- NoteOff{0, 60, 0}
+		expect(a, test.a, NoteOff{0, 60, 0})
+		expect(b, test.b, NoteOff{0, 60, 0})
+	}
+}
@@ -10,9 +10,20 @@ On Device implementations:
     TransposerDevice: A "fake" device that can be piped or chained
         to other devices in order to manipulate or transpose
         the MIDI data coming through it.
+    MemDevice: An in-memory device for sending MIDI data into connectors
+        and inspecting the MIDI data they deliver, e.g. in tests.
+    TCPServer and TCPClient: Devices that bridge MIDI data over a network.
+    FileDevice: A device that plays back or records a Standard MIDI File.
+    LFO: A device that sends control changes following a waveform.
 */
 
-import "github.com/aoeu/audio/midi/portmidi"
+import (
+	"fmt"
+	"github.com/aoeu/audio/midi/portmidi"
+	"regexp"
+	"sync"
+	"time"
+)
 
 type Wires struct {
 	In  chan Message // MIDI Messages inbound to the device are received from the In channel.
@@ -26,9 +37,43 @@ func NewWires() *Wires {
 	}
 }
 
+// Sends a song select to the device, choosing the song it plays when started.
+func (w Wires) SelectSong(song int) {
+	w.In <- SongSelect{song}
+}
+
+// Sends All Sound Off and All Notes Off to the device on every channel, to
+// silence it (e.g. when notes are stuck.)
+func (w Wires) Panic() {
+	for channel := 0; channel < 16; channel++ {
+		w.In <- AllSoundOff{channel}
+		w.In <- AllNotesOff{channel}
+	}
+}
+
+// Sends Local Control to the device, connecting its keyboard to its own sound
+// or, if not on, leaving the keyboard to only send MIDI data.
+func (w Wires) SetLocalControl(channel int, on bool) {
+	w.In <- LocalControl{channel, on}
+}
+
+// A Porter is a port that a Device can be made from: a *Port, the
+// *SystemInPort or *SystemOutPort of a SystemDevice, or a port of another
+// package's, e.g. for a serial connection.
+type Porter interface {
+	Open() error
+	Close() error
+	Connect()
+	IsOpen() bool
+	Messages() chan Message // The channel MIDI data is sent to or received from the port on.
+	Errors() <-chan error   // Errors that occur while the port is connected, or nil if none are reported.
+	Done() <-chan bool      // Closed once the port is closed.
+}
+
 type Device struct {
-	in  *Port
-	out *Port
+	in    Porter
+	out   Porter
+	notes *noteTimers // Stopped when the device is closed.
 	*Wires
 }
 
@@ -36,10 +81,111 @@ func NewDevice() *Device {
 	return &Device{
 		in:    NewPort(false),
 		out:   NewPort(false),
+		notes: newNoteTimers(),
 		Wires: NewWires(),
 	}
 }
 
+// Creates a new Device from any input and output ports, e.g. to pair the
+// input port of one system device with the output port of another. MIDI data
+// sent to the device's In wire is sent to the input port, and MIDI data from
+// the output port is received from its Out wire. A nil port is replaced by
+// one that is never opened.
+func NewDeviceFromPorts(in, out Porter) *Device {
+	if in == nil {
+		in = NewPort(false)
+	}
+	if out == nil {
+		out = NewPort(false)
+	}
+	return &Device{
+		in:    in,
+		out:   out,
+		notes: newNoteTimers(),
+		Wires: &Wires{
+			In:  in.Messages(),
+			Out: out.Messages(),
+		},
+	}
+}
+
+// Returns a channel receiving the errors of the device's ports, in place of
+// their own Errors. Each call passes them to a new channel, so call it once.
+func (d *Device) Errors() <-chan error {
+	return mergeErrors(d.in, d.out)
+}
+
+// Passes the errors of the ports to a single channel.
+func mergeErrors(ports ...Porter) <-chan error {
+	errs := make(chan error, errorBufferSize)
+	for _, p := range ports {
+		if p == nil || p.Errors() == nil {
+			continue
+		}
+		go func(from <-chan error) {
+			for err := range from {
+				select {
+				case errs <- err:
+				default:
+				}
+			}
+		}(p.Errors())
+	}
+	return errs
+}
+
+// Returns the ports of the devices, for mergeErrors.
+func portsOf(devices ...*Device) []Porter {
+	var ports []Porter
+	for _, d := range devices {
+		ports = append(ports, d.in, d.out)
+	}
+	return ports
+}
+
+// Returns a channel that is closed once the device is closed, for goroutines
+// sending to its In wire to stop on.
+func (d *Device) Done() <-chan bool {
+	return d.in.Done()
+}
+
+// Sends a message to the device, returning false if the device is closed first.
+func (d *Device) send(m Message) bool {
+	select {
+	case d.In <- m:
+		return true
+	case <-d.Done():
+		return false
+	}
+}
+
+// Sends a message to the device, returning false if the device is closed or
+// the timeout passes first, as when nothing reads its In wire.
+func (d *Device) sendWithin(m Message, timeout <-chan time.Time) bool {
+	select {
+	case d.In <- m:
+		return true
+	case <-d.Done():
+		return false
+	case <-timeout:
+		return false
+	}
+}
+
+// Sends a message to the device from a connector's Connect, returning false if
+// the device is closed or the connector is disconnected first, in which case
+// Connect must return, as the disconnect has been received.
+func (d *Device) sendFrom(m Message, disconnect <-chan bool) bool {
+	select {
+	case d.In <- m:
+		return true
+	case <-d.Done():
+		return false
+	case <-disconnect:
+		return false
+	}
+}
+
 func (d *Device) Open() error {
 	err := d.in.Open()
 	if err != nil {
@@ -49,16 +195,88 @@ func (d *Device) Open() error {
 }
 
 func (d *Device) Close() (err error) {
+	d.notes.stop()
 	err = d.in.Close()
 	err = d.out.Close()
 	return err
 }
 
+// Sends a note on to the device and the matching note off once the duration
+// has passed, unless the device is closed first.
+func (d *Device) PlayNote(channel, key, velocity int, duration time.Duration) {
+	if !d.send(NoteOn{channel, key, velocity}) {
+		return
+	}
+	d.notes.add(duration, func() {
+		d.send(NoteOff{channel, key, 0})
+	})
+}
+
+// Timers sending delayed messages, e.g. the note offs of notes played with
+// PlayNote, which are stopped rather than sending to a closed device.
+type noteTimers struct {
+	mu      sync.Mutex
+	timers  map[*time.Timer]bool
+	stopped bool
+}
+
+func newNoteTimers() *noteTimers {
+	return &noteTimers{timers: make(map[*time.Timer]bool)}
+}
+
+// Calls f once the duration has passed, unless the timers are stopped first.
+func (n *noteTimers) add(duration time.Duration, f func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(duration, func() {
+		n.mu.Lock()
+		delete(n.timers, t)
+		n.mu.Unlock()
+		f()
+	})
+	n.timers[t] = true
+}
+
+// Stops the timers, and any added afterwards.
+func (n *noteTimers) stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stopped = true
+	for t := range n.timers {
+		t.Stop()
+		delete(n.timers, t)
+	}
+}
+
+// Discards the running status and any incomplete message of the MIDI data
+// the device's ports parse, then sends All Notes Off on every channel from the
+// device, so that connectors holding note state forget it and the devices they
+// send to release their notes, as after an error. Sending stops if the device
+// is closed first.
+func (d *Device) Reset() {
+	for _, p := range []Porter{d.in, d.out} {
+		if r, ok := p.(interface{ resetParser() }); ok {
+			r.resetParser()
+		}
+	}
+	for _, m := range allNotesOff() {
+		select {
+		case d.Out <- m:
+		case <-d.Done():
+			return
+		}
+	}
+}
+
 func (s Device) Connect() {
-	if s.in.isOpen {
+	if s.in.IsOpen() {
 		go s.in.Connect()
 	}
-	if s.out.isOpen {
+	if s.out.IsOpen() {
 		go s.out.Connect()
 	}
 }
@@ -74,8 +292,8 @@ type ThruDevice struct {
 // Creates a new thru device.
 func NewThruDevice() *ThruDevice {
 	return &ThruDevice{
-		in:         &Port{},
-		out:        &Port{},
+		in:         NewPort(false),
+		out:        NewPort(false),
 		disconnect: make(chan bool, 1),
 		Wires:      NewWires(),
 	}
@@ -92,60 +310,255 @@ func (t ThruDevice) Connect() {
 	}
 }
 
+// An in-memory Device. Messages sent from it appear on its Out wire
+// and messages sent to it on its In wire are kept to be inspected.
+type MemDevice struct {
+	*Device
+	mu         sync.Mutex
+	received   []Message
+	disconnect chan bool
+}
+
+// Creates a new MemDevice, which starts receiving messages immediately.
+func NewMemDevice() *MemDevice {
+	m := &MemDevice{
+		Device:     NewDevice(),
+		disconnect: make(chan bool, 1),
+	}
+	go m.receive()
+	return m
+}
+
+func (m *MemDevice) receive() {
+	for {
+		select {
+		case msg := <-m.In:
+			m.mu.Lock()
+			m.received = append(m.received, msg)
+			m.mu.Unlock()
+		case <-m.disconnect:
+			return
+		}
+	}
+}
+
+// Sends a message from the device, as if it were played on it.
+func (m *MemDevice) Send(msg Message) {
+	m.Out <- msg
+}
+
+// Returns the messages that the device has received so far.
+func (m *MemDevice) Received() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.received...)
+}
+
+// Stops receiving messages and closes the device.
+func (m *MemDevice) Close() error {
+	select {
+	case m.disconnect <- true:
+	default:
+	}
+	return m.Device.Close()
+}
+
 // Represents a software or hardware MIDI device on the system.
 type SystemDevice struct { // Implements Device
 	in  *SystemInPort
 	out *SystemOutPort
 	Wires
-	Name string
+	Name      string
+	Interface string // The system MIDI API the device is accessed through.
+}
+
+// Returns the stats of reading from and writing to the device, zero for a direction it lacks.
+func (s SystemDevice) Stats() (read, write Stats) {
+	if s.out != nil {
+		read = s.out.Stats()
+	}
+	if s.in != nil {
+		write = s.in.Stats()
+	}
+	return read, write
 }
 
 func (s SystemDevice) Open() error {
 	// TODO(aoeu): Ramify with Device.Open()
-	err := s.in.Open()
-	if err != nil {
+	if s.in == nil && s.out == nil {
+		return fmt.Errorf("System device %q: %w", s.Name, ErrNoStream)
+	}
+	if s.in != nil {
+		if err := s.in.Open(); err != nil {
+			return err
+		}
+	}
+	if s.out != nil {
+		return s.out.Open()
+	}
+	return nil
+}
+
+// Opens the device with its ports' buffers configured by c, wiring the device
+// to their new channels. Open it before connecting it to anything.
+func (s *SystemDevice) OpenWith(c PortConfig) error {
+	if s.in == nil && s.out == nil {
+		return fmt.Errorf("System device %q: %w", s.Name, ErrNoStream)
+	}
+	if s.in != nil {
+		err := s.in.OpenWith(c)
+		s.Wires.In = s.in.messages
+		if err != nil {
+			return err
+		}
+	}
+	if s.out != nil {
+		err := s.out.OpenWith(c)
+		s.Wires.Out = s.out.messages
 		return err
 	}
-	return s.out.Open()
+	return nil
 }
 
 func (s SystemDevice) Close() error {
-	err := s.in.SystemPort.Close()
-	if err != nil {
-		return err
+	if s.in != nil {
+		// The In wire is left open, as connectors may still be sending to it.
+		s.in.stop()
 	}
-	err = s.out.SystemPort.Close()
-	return err
+	if s.out != nil {
+		return s.out.SystemPort.Close()
+	}
+	return nil
 }
 
 func (s SystemDevice) Connect() {
-	if s.in.isOpen {
+	if s.in != nil && s.in.IsOpen() {
 		go s.in.Connect()
 	}
-	if s.out.isOpen {
+	if s.out != nil && s.out.IsOpen() {
 		go s.out.Connect()
 	}
 }
 
+// Returns a channel receiving the errors of the device's ports, e.g. a stream
+// failing, in place of their own Errors. Each call passes them to a new
+// channel, so call it once.
+func (s SystemDevice) Errors() <-chan error {
+	var ports []Porter
+	if s.in != nil {
+		ports = append(ports, s.in)
+	}
+	if s.out != nil {
+		ports = append(ports, s.out)
+	}
+	return mergeErrors(ports...)
+}
+
+// Returns the device's input port, for NewDeviceFromPorts, or nil if it has none.
+func (s SystemDevice) InPort() Porter {
+	if s.in == nil {
+		return nil
+	}
+	return s.in
+}
+
+// Returns the device's output port, for NewDeviceFromPorts, or nil if it has none.
+func (s SystemDevice) OutPort() Porter {
+	if s.out == nil {
+		return nil
+	}
+	return s.out
+}
+
+// Names the control changes received from the device, overriding ControlChangeNames.
+func (s SystemDevice) SetControlChangeNames(names map[int]string) {
+	if s.out != nil {
+		s.out.ControlChangeNames = names
+	}
+}
+
+// Sets how messages received from the device are timed. Set before the device is opened.
+func (s SystemDevice) SetTimestamps(t Timestamps) {
+	if s.out != nil {
+		s.out.Timestamps = t
+	}
+}
+
+// Sets the interval the device is looked for at to reconnect its ports once
+// it is plugged in again, if their streams fail, or 0 to stop instead (see
+// SystemPort.ReconnectInterval). Set before the device is connected.
+func (s SystemDevice) SetReconnectInterval(interval time.Duration) {
+	if s.in != nil {
+		s.in.ReconnectInterval = interval
+	}
+	if s.out != nil {
+		s.out.ReconnectInterval = interval
+	}
+}
+
+// Sets how note offs sent to and received from the device are sent, so that
+// connectors need only handle one form. Set before the device is connected.
+func (s SystemDevice) SetNoteOffs(n NoteOffs) {
+	if s.in != nil {
+		s.in.NoteOffs = n
+	}
+	if s.out != nil {
+		s.out.NoteOffs = n
+	}
+}
+
+// Drops the message types in filters, an OR of the FILTER_ constants, before
+// they are received from the device. The device must be open.
+func (s SystemDevice) SetFilter(filters int) error {
+	if s.out == nil {
+		return fmt.Errorf("System device %q: %w", s.Name, ErrNoStream)
+	}
+	return s.out.SetFilter(filters)
+}
+
+// Drops channel messages received from the device on channels other than
+// those given. The device must be open.
+func (s SystemDevice) SetChannelMask(channels ...int) error {
+	if s.out == nil {
+		return fmt.Errorf("System device %q: %w", s.Name, ErrNoStream)
+	}
+	return s.out.SetChannelMask(channels...)
+}
+
+// Drops the messages received from the device that the config describes.
+// The device must be open.
+func (s SystemDevice) SetFilterConfig(c FilterConfig) error {
+	if s.out == nil {
+		return fmt.Errorf("System device %q: %w", s.Name, ErrNoStream)
+	}
+	return s.out.SetFilterConfig(c)
+}
+
+// Sets up a system port for the stream with the ID.
+func (s *SystemPort) setStream(info *portmidi.StreamInfo, id int) {
+	s.Port = *NewPort(info.IsOpen)
+	s.id, s.name = id, info.Name
+}
+
 func getSystemDevices() SystemDevices {
 	devices := make(map[string]SystemDevice)
 	for i := 0; i < portmidi.NumStreams(); i++ {
 		streamInfo := portmidi.NewStreamInfo(i)
 		if _, ok := devices[streamInfo.Name]; !ok {
 			devices[streamInfo.Name] = SystemDevice{
-				Name: streamInfo.Name,
+				Name:      streamInfo.Name,
+				Interface: streamInfo.Interface,
 			}
 		}
-		sp := SystemPort{
-			Port: *NewPort(streamInfo.IsOpen),
-		}
 		d := devices[streamInfo.Name]
 		switch {
 		case streamInfo.IsOutput: // An output stream is for an input port.
-			d.in = &SystemInPort{SystemPort: sp, Output: portmidi.NewOutput(i)}
+			d.in = &SystemInPort{Output: portmidi.NewOutput(i)}
+			d.in.setStream(streamInfo, i)
 			d.Wires.In = d.in.messages
 		case streamInfo.IsInput: // An input stream is for an output port.
-			d.out = &SystemOutPort{SystemPort: sp, Input: portmidi.NewInput(i)}
+			d.out = &SystemOutPort{Input: portmidi.NewInput(i)}
+			d.out.setStream(streamInfo, i)
 			d.Wires.Out = d.out.messages
 		}
 		devices[streamInfo.Name] = d
@@ -153,6 +566,113 @@ func getSystemDevices() SystemDevices {
 	return devices
 }
 
+// Creates a SystemDevice with virtual ports, which other programs (e.g. a DAW)
+// see as a MIDI device named name: MIDI data they send to it is received from
+// its Out wire, and MIDI data sent to its In wire is sent to the programs
+// connected to it. Its ports are created when it is opened and deleted when
+// it is closed. Only the alsa, coremidi, jack and rtmidi (but not on Windows)
+// backends can create them; with others, opening the device returns
+// ErrNoVirtualPorts.
+func NewVirtualDevice(name string) SystemDevice {
+	d := SystemDevice{Name: name, Interface: "Virtual"}
+	d.in = &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(false), id: -1},
+		Output:     portmidi.NewVirtualOutput(name),
+	}
+	d.out = &SystemOutPort{
+		SystemPort: SystemPort{Port: *NewPort(false), id: -1},
+		Input:      portmidi.NewVirtualInput(name),
+	}
+	d.Wires.In, d.Wires.Out = d.in.messages, d.out.messages
+	return d
+}
+
+// Describes a system device, as listed by ListDevices.
+type DeviceInfo struct {
+	Name      string
+	Interface string // The system MIDI API the device is accessed through.
+	In        bool   // Whether messages can be sent to the device, i.e. it has an input port.
+	Out       bool   // Whether messages can be received from the device, i.e. it has an output port.
+	IsOpen    bool   // Whether any of the device's streams are open in this program.
+}
+
+// Lists every system device, in the order the system lists them, e.g. for
+// choosing one to open with GetDevices.
+func ListDevices() ([]DeviceInfo, error) {
+	if err := portmidi.Initialize(); err != nil {
+		return nil, err
+	}
+	streams := make([]portmidi.StreamInfo, portmidi.NumStreams())
+	for i := range streams {
+		streams[i] = *portmidi.NewStreamInfo(i)
+	}
+	return listDevices(streams), nil
+}
+
+// Combines the streams of each device, named alike, into one DeviceInfo.
+func listDevices(streams []portmidi.StreamInfo) []DeviceInfo {
+	var infos []DeviceInfo
+	index := make(map[string]int)
+	for _, s := range streams {
+		i, ok := index[s.Name]
+		if !ok {
+			i = len(infos)
+			index[s.Name] = i
+			infos = append(infos, DeviceInfo{Name: s.Name, Interface: s.Interface})
+		}
+		d := &infos[i]
+		d.In = d.In || s.IsOutput // An output stream is for an input port.
+		d.Out = d.Out || s.IsInput
+		d.IsOpen = d.IsOpen || s.IsOpen
+	}
+	return infos
+}
+
+// Which of a device's ports to use.
+type Direction int
+
+const (
+	Input  Direction = iota // The device's input port, which messages are sent to.
+	Output                  // The device's output port, which messages are received from.
+)
+
+// Opens the port in the direction given of the first device, in the order
+// ListDevices lists them, whose name matches the regular expression pattern
+// anywhere and that has a port in that direction, e.g. "Launchpad.*" or
+// "(?i)^nanokontrol". The device returned has only that port.
+// Unlike stream IDs, names don't change between reboots and machines.
+func OpenByName(pattern string, direction Direction) (SystemDevice, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return SystemDevice{}, err
+	}
+	infos, err := ListDevices()
+	if err != nil {
+		return SystemDevice{}, err
+	}
+	name, ok := matchDevice(infos, re, direction)
+	if !ok {
+		return SystemDevice{}, fmt.Errorf("System device %q: %w", pattern, ErrNoDevice)
+	}
+	d := getSystemDevices()[name]
+	if direction == Input {
+		d.out, d.Wires.Out = nil, nil
+	} else {
+		d.in, d.Wires.In = nil, nil
+	}
+	return d, d.Open()
+}
+
+// Returns the name of the first device matching re with a port in the direction.
+func matchDevice(infos []DeviceInfo, re *regexp.Regexp, direction Direction) (string, bool) {
+	for _, d := range infos {
+		if (direction == Input && d.In || direction == Output && d.Out) && re.MatchString(d.Name) {
+			return d.Name, true
+		}
+	}
+	return "", false
+}
+
 type SystemDevices map[string]SystemDevice
 
 // This function will cause terrible errors if called. Do not use it.
@@ -184,8 +704,8 @@ type Transposition func(Transposer)
 
 func NewTransposer(noteMap map[int]int, transposeFunc Transposition) (t *Transposer) {
 	t = &Transposer{NoteMap: noteMap, Wires: NewWires()}
-	t.in = &Port{}
-	t.out = &Port{}
+	t.in = NewPort(false)
+	t.out = NewPort(false)
 	if transposeFunc == nil {
 		transposeFunc = func(t1 Transposer) {
 			for {
@@ -202,6 +722,12 @@ func NewTransposer(noteMap map[int]int, transposeFunc Transposition) (t *Transpo
 						n.Key = key
 					}
 					t.Out <- n
+				case PolyAftertouch:
+					n := e.(PolyAftertouch)
+					if key, ok := t.NoteMap[n.Key]; ok {
+						n.Key = key
+					}
+					t.Out <- n
 				default:
 					t.Out <- e
 				}
@@ -0,0 +1,245 @@
+package midi
+
+import (
+	"errors"
+	"github.com/aoeu/audio/midi/portmidi"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Waits for a MemDevice to have received n messages, returning what it received.
+func waitForReceived(m *MemDevice, n int) []Message {
+	deadline := time.Now().Add(time.Second)
+	for {
+		received := m.Received()
+		if len(received) >= n || time.Now().After(deadline) {
+			return received
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMemDeviceRouter(t *testing.T) {
+	src, dst1, dst2 := NewMemDevice(), NewMemDevice(), NewMemDevice()
+	defer dst1.Close()
+	defer dst2.Close()
+	router := NewRouter(*src.Device, *dst1.Device, *dst2.Device)
+	if err := router.Open(); err != nil {
+		t.Errorf("Could not open router: %v", err)
+	}
+	go router.Connect()
+	defer router.Close()
+	expected := []Message{NoteOn{0, 60, 100}, NoteOff{0, 60, 0}}
+	for _, m := range expected {
+		src.Send(m)
+	}
+	for i, dst := range []*MemDevice{dst1, dst2} {
+		actual := waitForReceived(dst, len(expected))
+		if len(actual) != len(expected) {
+			t.Errorf("Destination %d received %v instead of %v", i, actual, expected)
+			continue
+		}
+		// A Router doesn't guarantee the order messages are delivered in.
+		for _, e := range expected {
+			if actual[0] != e && actual[1] != e {
+				t.Errorf("Destination %d received %v instead of %v", i, actual, expected)
+			}
+		}
+	}
+}
+
+func TestDeviceResetParsers(t *testing.T) {
+	in := NewPort(true)
+	in.messages = make(chan Message, 1)
+	out := &SystemOutPort{SystemPort: SystemPort{Port: *NewPort(true)}}
+	out.messages = make(chan Message, 16)
+	d := NewDeviceFromPorts(in, out)
+	w := NewWriter(in)
+	w.Write([]byte{0x90, 60}) // An incomplete note on.
+	out.message(0x643C90)     // A note on, setting the running status.
+	d.Reset()
+	if len(d.Out) != 16 {
+		t.Errorf("Sent %d messages when resetting instead of All Notes Off on every channel", len(d.Out))
+	}
+	if out.status != 0 {
+		t.Errorf("Kept the running status 0x%02X of the output port after resetting", out.status)
+	}
+	// Without its status, the rest of the note on is dropped.
+	w.Write([]byte{100, 0x80, 60, 0})
+	if m := <-in.messages; m != (NoteOff{0, 60, 0}) {
+		t.Errorf("Parsed %v after resetting instead of %v", m, NoteOff{0, 60, 0})
+	}
+}
+
+func TestDeviceResetClosed(t *testing.T) {
+	d := NewDevice()
+	d.Open()
+	d.Close()
+	reset := make(chan bool)
+	go func() {
+		d.Reset()
+		close(reset)
+	}()
+	select {
+	case <-reset:
+	case <-time.After(time.Second):
+		t.Error("Resetting a closed device blocked")
+	}
+}
+
+func TestPlayNoteClosed(t *testing.T) {
+	d := NewDevice()
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	d.In = make(chan Message, 2)
+	d.PlayNote(2, 60, 100, 20*time.Millisecond)
+	d.Close()
+	time.Sleep(50 * time.Millisecond)
+	if n := len(d.In); n != 1 {
+		t.Errorf("Sent %d messages instead of only the note on, as the device was closed first", n)
+	}
+}
+
+func TestPlayNote(t *testing.T) {
+	d := NewMemDevice()
+	defer d.Close()
+	start := time.Now()
+	d.PlayNote(2, 60, 100, 50*time.Millisecond)
+	if actual := waitForReceived(d, 1); len(actual) != 1 || actual[0] != (NoteOn{2, 60, 100}) {
+		t.Errorf("Received %v instead of a note on", actual)
+	}
+	actual := waitForReceived(d, 2)
+	elapsed := time.Since(start)
+	if len(actual) != 2 || actual[1] != (NoteOff{2, 60, 0}) {
+		t.Fatalf("Received %v instead of a note on and off", actual)
+	}
+	if elapsed < 50*time.Millisecond {
+		t.Errorf("Received a note off after %v instead of after 50ms", elapsed)
+	}
+}
+
+func TestNewDeviceFromPorts(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(true)}, // As when opened.
+		Output:     portmidi.NewOutput(0),
+	}
+	written := make(chan Message, 1)
+	in.write = func(m Message) error {
+		written <- m
+		return nil
+	}
+	out := NewPort(false)
+	hybrid := NewDeviceFromPorts(in, out)
+	if hybrid.In != in.messages || hybrid.Out != out.messages {
+		t.Fatal("The device isn't wired to its ports")
+	}
+
+	source := NewDevice()
+	pipe := NewPipe(source, hybrid)
+	go pipe.Connect()
+	defer pipe.Close()
+	expected := NoteOn{0, 60, 100}
+	source.Out <- expected
+	select {
+	case m := <-written:
+		if m != expected {
+			t.Errorf("Wrote %v instead of %v", m, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Nothing was written to the input port")
+	}
+}
+
+// A port like one another package could make, e.g. for a serial connection.
+type customPort struct {
+	messages chan Message
+	done     chan bool
+	mu       sync.Mutex
+	open     bool
+}
+
+func (c *customPort) Open() error            { c.setOpen(true); return nil }
+func (c *customPort) Close() error           { c.setOpen(false); close(c.done); return nil }
+func (c *customPort) Connect()               {}
+func (c *customPort) IsOpen() bool           { c.mu.Lock(); defer c.mu.Unlock(); return c.open }
+func (c *customPort) setOpen(open bool)      { c.mu.Lock(); c.open = open; c.mu.Unlock() }
+func (c *customPort) Messages() chan Message { return c.messages }
+func (c *customPort) Errors() <-chan error   { return nil }
+func (c *customPort) Done() <-chan bool      { return c.done }
+
+func TestNewDeviceFromCustomPorts(t *testing.T) {
+	in := &customPort{messages: make(chan Message, 2), done: make(chan bool)}
+	d := NewDeviceFromPorts(in, nil)
+	pipe := NewPipe(NewDevice(), d)
+	if err := pipe.Open(); err != nil {
+		t.Fatal(err)
+	}
+	go pipe.Connect()
+	pipe.From.Out <- NoteOn{0, 60, 100}
+	if m := <-in.messages; m != (NoteOn{0, 60, 100}) {
+		t.Errorf("Sent %v to the custom port instead of %v", m, NoteOn{0, 60, 100})
+	}
+	NewWriter(in).Write([]byte{0x80, 60, 0})
+	if m := <-in.messages; m != (NoteOff{0, 60, 0}) {
+		t.Errorf("Wrote %v to the custom port instead of %v", m, NoteOff{0, 60, 0})
+	}
+	if err := pipe.Close(); err != nil {
+		t.Error(err)
+	}
+	if in.IsOpen() {
+		t.Error("The custom port is open after closing the pipe")
+	}
+}
+
+func TestListDevices(t *testing.T) {
+	streams := []portmidi.StreamInfo{
+		{Interface: "ALSA", Name: "UM-ONE", IsInput: true},
+		{Interface: "ALSA", Name: "Keys", IsInput: true, IsOpen: true},
+		{Interface: "ALSA", Name: "UM-ONE", IsOutput: true},
+		{Interface: "ALSA", Name: "Synth", IsOutput: true},
+	}
+	expected := []DeviceInfo{
+		{Name: "UM-ONE", Interface: "ALSA", In: true, Out: true},
+		{Name: "Keys", Interface: "ALSA", Out: true, IsOpen: true},
+		{Name: "Synth", Interface: "ALSA", In: true},
+	}
+	if actual := listDevices(streams); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Listed %+v instead of %+v", actual, expected)
+	}
+	if _, err := ListDevices(); err != nil {
+		t.Errorf("Could not list devices: %v", err)
+	}
+}
+
+func TestOpenByName(t *testing.T) {
+	infos := []DeviceInfo{
+		{Name: "Launchpad Mini", Out: true},
+		{Name: "Launchpad X", In: true, Out: true},
+	}
+	tests := []struct {
+		pattern   string
+		direction Direction
+		expected  string
+	}{
+		{"Launchpad.*", Output, "Launchpad Mini"},
+		{"Launchpad.*", Input, "Launchpad X"},
+		{"(?i)^launchpad x$", Output, "Launchpad X"},
+		{"Keystation", Output, ""},
+	}
+	for _, test := range tests {
+		name, _ := matchDevice(infos, regexp.MustCompile(test.pattern), test.direction)
+		if name != test.expected {
+			t.Errorf("Matched %q instead of %q with %q", name, test.expected, test.pattern)
+		}
+	}
+	if _, err := OpenByName("^$", Output); !errors.Is(err, ErrNoDevice) {
+		t.Errorf("Received %v from opening no device instead of %v", err, ErrNoDevice)
+	}
+	if _, err := OpenByName("(", Output); err == nil {
+		t.Error("Opened a device by an invalid pattern")
+	}
+}
@@ -0,0 +1,111 @@
+package midi
+
+import "time"
+
+// An EnvelopeFollower transmits MIDI data from one device to another, adding
+// control changes that follow an envelope started by each note on: rising to
+// the note's velocity over the Attack time and then falling back to 0 over
+// the Release time, so that louder notes e.g. open a filter further.
+// Implements Connector, one to one.
+type EnvelopeFollower struct {
+	Name       string // Identifies the connector in logs.
+	From       *Device
+	To         *Device
+	Channel    int
+	Controller int // The ID of the control changes sent.
+	Attack     time.Duration
+	Release    time.Duration
+	Interval   time.Duration // The time between control changes.
+	disconnect chan bool
+}
+
+// Creates a new EnvelopeFollower between the devices sent as parameters,
+// sending control changes with the ID on the channel every 10 milliseconds.
+func NewEnvelopeFollower(from, to *Device, channel, controller int, attack, release time.Duration) *EnvelopeFollower {
+	return &EnvelopeFollower{
+		From:       from,
+		To:         to,
+		Channel:    channel,
+		Controller: controller,
+		Attack:     attack,
+		Release:    release,
+		Interval:   10 * time.Millisecond,
+		disconnect: make(chan bool, 1),
+	}
+}
+
+func (e *EnvelopeFollower) Open() error {
+	if err := e.From.Open(); err != nil {
+		return err
+	}
+	return e.To.Open()
+}
+
+// Ends transmission of MIDI data and closes the connected MIDI devices.
+func (e *EnvelopeFollower) Close() error {
+	Debug.Printf("%v closed", e)
+	e.disconnect <- true
+	if err := e.From.Close(); err != nil {
+		return err
+	}
+	return e.To.Close()
+}
+
+// Begins transmission of MIDI data between the connected MIDI devices.
+func (e *EnvelopeFollower) Connect() {
+	Debug.Printf("%v connected", e)
+	go e.From.Connect()
+	go e.To.Connect()
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+	var level, peak float64
+	attacking := false
+	sent := 0
+	for {
+		select {
+		case m := <-e.From.Out:
+			if n, ok := m.(NoteOn); ok && n.Velocity > 0 {
+				peak = float64(n.Velocity)
+				attacking = true
+			}
+			if !e.To.sendFrom(m, e.disconnect) {
+				return
+			}
+			continue
+		case <-ticker.C:
+		case <-e.disconnect:
+			return
+		}
+		switch {
+		case attacking:
+			level += e.step(peak, e.Attack)
+			if level >= peak {
+				level, attacking = peak, false
+			}
+		case level > 0:
+			level -= e.step(peak, e.Release)
+			if level < 0 {
+				level = 0
+			}
+		}
+		if value := int(level + 0.5); value != sent {
+			sent = value
+			cc := ControlChange{e.Channel, e.Controller, value, controlChangeName(e.Controller, nil)}
+			if !e.To.sendFrom(cc, e.disconnect) {
+				return
+			}
+		}
+	}
+}
+
+// Returns the change in level per interval to ramp to or from the peak in the time.
+func (e *EnvelopeFollower) step(peak float64, d time.Duration) float64 {
+	if d <= e.Interval {
+		return peak
+	}
+	return peak * float64(e.Interval) / float64(d)
+}
+
+func (e *EnvelopeFollower) String() string {
+	return connectorName("EnvelopeFollower", e.Name)
+}
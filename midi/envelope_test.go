@@ -0,0 +1,43 @@
+package midi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnvelopeFollower(t *testing.T) {
+	from, to := NewDevice(), NewDevice()
+	e := NewEnvelopeFollower(from, to, 0, 74, 20*time.Millisecond, 40*time.Millisecond)
+	e.Interval = 2 * time.Millisecond
+	go e.Connect()
+	defer e.Close()
+
+	from.Out <- NoteOn{0, 60, 100}
+	if m := <-to.In; m != (NoteOn{0, 60, 100}) {
+		t.Fatalf("Received %v instead of the note on", m)
+	}
+	var values []int
+	for len(values) == 0 || values[len(values)-1] > 0 {
+		select {
+		case m := <-to.In:
+			values = append(values, m.(ControlChange).Value)
+		case <-time.After(time.Second):
+			t.Fatalf("The envelope stopped at %v", values)
+		}
+	}
+	peak := 0
+	for i, v := range values {
+		if v > values[peak] {
+			peak = i
+		}
+		if i > 0 && (i <= peak) != (v > values[i-1]) {
+			t.Fatalf("The envelope didn't rise and then fall: %v", values)
+		}
+	}
+	if values[peak] != 100 {
+		t.Errorf("The envelope peaked at %d instead of the velocity 100", values[peak])
+	}
+	if peak < 5 || len(values)-peak < 10 {
+		t.Errorf("The envelope didn't ramp up and then decay: %v", values)
+	}
+}
@@ -0,0 +1,18 @@
+package midi
+
+import (
+	"errors"
+	"github.com/aoeu/audio/midi/portmidi"
+)
+
+// Errors returned (possibly wrapped) by ports and devices.
+// Use errors.Is to test for them.
+var (
+	ErrPortNotOpen    = errors.New("Port is not open.")
+	ErrNoStream       = errors.New("No stream set.")
+	ErrAlreadyOpen    = errors.New("Port is already open.") // Returned by OpenWith, as an open port's buffers can't be configured.
+	ErrPortClosed     = errors.New("Port is closed.")       // Returned by opening a system port that was closed, which can't be opened again.
+	ErrInvalidMessage = errors.New("Invalid MIDI message.")
+	ErrNoDevice       = errors.New("No device matches.")
+	ErrNoVirtualPorts = portmidi.ErrNoVirtualPorts // Returned by a virtual device the backend can't create.
+)
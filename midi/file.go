@@ -0,0 +1,286 @@
+package midi
+
+/*
+A FileDevice records MIDI data sent to it to a Standard MIDI File, or plays
+back the MIDI data of one. Only format 0 files (a single track) are read
+and written. A file is laid out as:
+    "MThd" <length: 6> <format> <number of tracks> <ticks per quarter note>
+    "MTrk" <length> (<delta time as a variable-length quantity> <event>)...
+where each event is a MIDI message (whose status byte may be left out when
+it repeats, i.e. running status), a SysEx or a meta event.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// The ticks per quarter note of files recorded by a FileDevice, by default.
+const DefaultDivision = 480
+
+// A FileDevice is a Device that plays back a Standard MIDI File, sending its
+// MIDI data from the Out wire, or records the MIDI data sent to its In wire,
+// writing it to a file when closed.
+type FileDevice struct {
+	*Device
+	Division   int       // Ticks per quarter note of a recorded file.
+	Tempo      SetTempo  // The tempo of a recorded file.
+	Played     chan bool // Closed once playback has finished.
+	path       string
+	recording  bool
+	mu         sync.Mutex
+	start      time.Time
+	recorded   Track
+	closed     sync.Once
+	disconnect chan bool
+}
+
+// Creates a new FileDevice that records to the file at the path or,
+// if not for recording, begins playing it back. Playback timing starts
+// when the first message is received from the device, and Played is
+// closed once the last has been.
+func NewFileDevice(path string, forRecording bool) (*FileDevice, error) {
+	f := &FileDevice{
+		Device:     NewDevice(),
+		Division:   DefaultDivision,
+		Tempo:      NewSetTempo(120),
+		Played:     make(chan bool),
+		path:       path,
+		recording:  forRecording,
+		disconnect: make(chan bool),
+	}
+	if forRecording {
+		go f.record()
+		return f, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := decodeFile(b)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	go f.play(t)
+	return f, nil
+}
+
+func (f *FileDevice) record() {
+	for {
+		select {
+		case m := <-f.In:
+			f.mu.Lock()
+			if f.start.IsZero() {
+				f.start = time.Now()
+			}
+			f.recorded = append(f.recorded, TimedMessage{time.Since(f.start), m})
+			f.mu.Unlock()
+		case <-f.disconnect:
+			return
+		}
+	}
+}
+
+func (f *FileDevice) play(t Track) {
+	defer close(f.Played)
+	var start time.Time
+	for _, m := range t {
+		if start.IsZero() {
+			select {
+			case f.Out <- m.Message:
+			case <-f.disconnect:
+				return
+			}
+			start = time.Now().Add(-m.Time)
+			continue
+		}
+		select {
+		case <-time.After(time.Until(start.Add(m.Time))):
+		case <-f.disconnect:
+			return
+		}
+		select {
+		case f.Out <- m.Message:
+		case <-f.disconnect:
+			return
+		}
+	}
+}
+
+// Stops recording or playing back and closes the device. A recording is written
+// to the file. Closing a closed device does nothing.
+func (f *FileDevice) Close() error {
+	first := false
+	f.closed.Do(func() {
+		first = true
+		close(f.disconnect)
+	})
+	if !first {
+		return nil
+	}
+	if f.recording {
+		f.mu.Lock()
+		b := encodeFile(f.recorded, f.Division, f.Tempo)
+		f.mu.Unlock()
+		if err := ioutil.WriteFile(f.path, b, 0644); err != nil {
+			return err
+		}
+	}
+	return f.Device.Close()
+}
+
+// Returns a format 0 Standard MIDI File of the track. System real-time
+// messages, which aren't stored in files, are left out: a System Reset's
+// status, 0xFF, would begin a meta event.
+func encodeFile(t Track, division int, tempo SetTempo) []byte {
+	track := append([]byte{0}, EncodeMetaEvent(tempo)...)
+	var ticks int
+	for _, m := range t {
+		at := int(int64(m.Time/time.Microsecond) * int64(division) / int64(tempo.MicrosecondsPerQuarterNote))
+		// Each of a compound message's messages is an event of its own, the
+		// ones after the first with a delta time of 0.
+		events := []Message{m.Message}
+		if c, ok := m.Message.(compound); ok {
+			events = c.Messages()
+		}
+		for _, e := range events {
+			if _, ok := e.(SysEx); !ok && int(e.Uint32()&0xFF) >= CLOCK {
+				continue
+			}
+			track = appendVarLen(track, at-ticks)
+			ticks = at
+			if s, ok := e.(SysEx); ok && len(s.Data) > 0 {
+				track = append(track, byte(SYSEX))
+				track = appendVarLen(track, len(s.Data)-1)
+				track = append(track, s.Data[1:]...)
+			} else {
+				track = append(track, MessageBytes(e)...)
+			}
+		}
+	}
+	track = append(track, 0)
+	track = append(track, EncodeMetaEvent(EndOfTrack{})...)
+
+	var b bytes.Buffer
+	b.WriteString("MThd")
+	binary.Write(&b, binary.BigEndian, []uint32{6})
+	binary.Write(&b, binary.BigEndian, []uint16{0, 1, uint16(division)})
+	b.WriteString("MTrk")
+	binary.Write(&b, binary.BigEndian, uint32(len(track)))
+	b.Write(track)
+	return b.Bytes()
+}
+
+// Returns the MIDI data of a format 0 Standard MIDI File, timed by its tempo.
+// Note ons with a velocity of 0 are returned as note offs.
+func decodeFile(b []byte) (Track, error) {
+	if len(b) < 14 || string(b[:4]) != "MThd" {
+		return nil, fmt.Errorf("Not a Standard MIDI File")
+	}
+	headerLength := int(binary.BigEndian.Uint32(b[4:]))
+	if headerLength < 6 || len(b) < 8+headerLength {
+		return nil, fmt.Errorf("Invalid header length %d", headerLength)
+	}
+	format := binary.BigEndian.Uint16(b[8:])
+	division := int(binary.BigEndian.Uint16(b[12:]))
+	if format != 0 {
+		return nil, fmt.Errorf("Format %d files are not supported", format)
+	}
+	if division&0x8000 != 0 || division == 0 {
+		return nil, fmt.Errorf("SMPTE time divisions are not supported")
+	}
+	b = b[8+headerLength:]
+	for len(b) >= 8 && string(b[:4]) != "MTrk" {
+		skip := 8 + int(binary.BigEndian.Uint32(b[4:])) // An unknown chunk.
+		if skip > len(b) {
+			skip = len(b)
+		}
+		b = b[skip:]
+	}
+	if len(b) < 8 {
+		return nil, fmt.Errorf("No track")
+	}
+	length := int(binary.BigEndian.Uint32(b[4:]))
+	if len(b) < 8+length {
+		return nil, fmt.Errorf("Track of length %d truncated to %d bytes", length, len(b)-8)
+	}
+	b = b[8 : 8+length]
+
+	var t Track
+	var at time.Duration
+	tempo := NewSetTempo(120).MicrosecondsPerQuarterNote
+	var status byte
+	for len(b) > 0 {
+		ticks, n, err := readVarLen(b)
+		if err != nil {
+			return t, err
+		}
+		b = b[n:]
+		at += time.Duration(ticks) * time.Duration(tempo) * time.Microsecond / time.Duration(division)
+		if len(b) == 0 {
+			return t, fmt.Errorf("Track ends after a delta time")
+		}
+		switch c := b[0]; {
+		case int(c) == META_EVENT:
+			status = 0
+			m, n, err := DecodeMetaEvent(b)
+			if err != nil {
+				return t, err
+			}
+			b = b[n:]
+			switch m := m.(type) {
+			case SetTempo:
+				tempo = m.MicrosecondsPerQuarterNote
+			case EndOfTrack:
+				return t, nil
+			}
+		case int(c) == SYSEX || c == 0xF7:
+			status = 0
+			length, n, err := readVarLen(b[1:])
+			if err != nil {
+				return t, err
+			}
+			if len(b) < 1+n+length {
+				return t, fmt.Errorf("SysEx of length %d truncated", length)
+			}
+			if int(c) == SYSEX {
+				data := append([]byte{c}, b[1+n:1+n+length]...)
+				t = append(t, TimedMessage{at, SysEx{data}})
+			}
+			b = b[1+n+length:]
+		default:
+			running := c&0x80 == 0
+			if !running {
+				status = c
+			} else if status == 0 {
+				return t, fmt.Errorf("Data byte 0x%02X without a status", c)
+			}
+			length := MessageLength(int(status))
+			read := length // The bytes of the message in the track.
+			if running {
+				read--
+			}
+			if length == 0 || len(b) < read {
+				return t, fmt.Errorf("Invalid MIDI message with status 0x%02X", status)
+			}
+			data := b[:read]
+			if running {
+				data = append([]byte{status}, data...)
+			}
+			m, err := ParseMessage(data)
+			if err != nil {
+				return t, err
+			}
+			if n, ok := m.(NoteOn); ok && n.Velocity == 0 {
+				m = NoteOff{n.Channel, n.Key, 0}
+			}
+			t = append(t, TimedMessage{at, m})
+			b = b[read:]
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,145 @@
+package midi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileDeviceRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "midi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.mid")
+
+	recording, err := NewFileDevice(path, true)
+	if err != nil {
+		t.Fatalf("Could not create file device: %v", err)
+	}
+	expected := []Message{
+		NoteOn{0, 60, 100},
+		ControlChange{0, 64, 127, ControlChangeNames[64]},
+		SysEx{[]byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+		NoteOff{0, 60, 0},
+	}
+	for _, m := range expected {
+		recording.In <- m
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := recording.Close(); err != nil {
+		t.Fatalf("Could not write file: %v", err)
+	}
+
+	playback, err := NewFileDevice(path, false)
+	if err != nil {
+		t.Fatalf("Could not read file: %v", err)
+	}
+	defer playback.Close()
+	var times []time.Time
+	for _, m := range expected {
+		actual := <-playback.Out
+		times = append(times, time.Now())
+		if !reflect.DeepEqual(actual, m) {
+			t.Errorf("Played %v instead of %v", actual, m)
+		}
+	}
+	<-playback.Played
+	if elapsed := times[len(times)-1].Sub(times[0]); elapsed < 50*time.Millisecond {
+		t.Errorf("Played back in %v instead of about %v", elapsed, 60*time.Millisecond)
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	b := []byte{
+		'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0, 96,
+		'M', 'T', 'r', 'k', 0, 0, 0, 18,
+		0, 0xFF, 0x51, 3, 0x07, 0xA1, 0x20, // 500000µs per quarter note.
+		0, 0x90, 60, 100,
+		96, 60, 0, // Running status, with a velocity of 0.
+		0, 0xFF, 0x2F, 0,
+	}
+	expected := Track{
+		{0, NoteOn{0, 60, 100}},
+		{500 * time.Millisecond, NoteOff{0, 60, 0}},
+	}
+	actual, err := decodeFile(b)
+	if err != nil {
+		t.Fatalf("Could not decode file: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decoded %v instead of %v", actual, expected)
+	}
+}
+
+func TestDecodeFileTruncated(t *testing.T) {
+	b := []byte{
+		'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0, 96,
+		'M', 'T', 'r', 'k', 0, 0, 0, 6,
+		0, 0x90, 60, 100,
+		0, 60, // Running status, missing the velocity.
+	}
+	if _, err := decodeFile(b); err == nil {
+		t.Error("Decoded a truncated message")
+	}
+}
+
+func TestEncodeFileCompound(t *testing.T) {
+	m := ParameterChange{Channel: 2, Parameter: 0x1234, Value: 0x0567}
+	tempo := NewSetTempo(120)
+	track := Track{{0, NoteOn{2, 60, 100}}, {500 * time.Millisecond, m}}
+	actual, err := decodeFile(encodeFile(track, 96, tempo))
+	if err != nil {
+		t.Fatalf("Could not decode file: %v", err)
+	}
+	expected := Track{{0, NoteOn{2, 60, 100}}}
+	for _, c := range m.Messages() {
+		expected = append(expected, TimedMessage{500 * time.Millisecond, c})
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decoded %v instead of %v", actual, expected)
+	}
+}
+
+func TestEncodeFileRealTime(t *testing.T) {
+	reset, err := ParseMessage([]byte{0xFF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := Track{
+		{0, NoteOn{0, 60, 100}},
+		{0, Clock{}},
+		{10 * time.Millisecond, reset},
+		{500 * time.Millisecond, NoteOff{0, 60, 0}},
+	}
+	actual, err := decodeFile(encodeFile(track, 96, NewSetTempo(120)))
+	if err != nil {
+		t.Fatalf("Could not decode file: %v", err)
+	}
+	expected := Track{{0, NoteOn{0, 60, 100}}, {500 * time.Millisecond, NoteOff{0, 60, 0}}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decoded %v instead of %v, without the real-time messages", actual, expected)
+	}
+}
+
+func TestFileDeviceCloseTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "midi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := NewFileDevice(filepath.Join(dir, "test.mid"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("Received %v from closing a closed device instead of nothing", err)
+	}
+}
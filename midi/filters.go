@@ -0,0 +1,378 @@
+package midi
+
+import "sync"
+
+// A Filter transmits MIDI data from one device to another, passing each
+// message through a function that returns the messages to send in its place
+// (none to drop it, or several to add to it.)
+// Implements Connector, one to one.
+type Filter struct {
+	Name       string // Identifies the connector in logs.
+	From       *Device
+	To         *Device
+	filter     FilterFunc
+	mu         sync.RWMutex // Guards filter, which may be swapped while connected.
+	disconnect chan bool
+}
+
+type FilterFunc func(Message) []Message
+
+// Creates a new Filter between the devices sent as parameters.
+func NewFilter(from, to *Device, f FilterFunc) *Filter {
+	return &Filter{
+		From:       from,
+		To:         to,
+		filter:     f,
+		disconnect: make(chan bool, 1),
+	}
+}
+
+func (f *Filter) Open() error {
+	if err := f.From.Open(); err != nil {
+		return err
+	}
+	return f.To.Open()
+}
+
+// Ends transmission of MIDI data and closes the connected MIDI devices.
+func (f *Filter) Close() error {
+	Debug.Printf("%v closed", f)
+	f.disconnect <- true
+	if err := f.From.Close(); err != nil {
+		return err
+	}
+	return f.To.Close()
+}
+
+// Begins transmission of MIDI data between the connected MIDI devices.
+func (f *Filter) Connect() {
+	Debug.Printf("%v connected", f)
+	go f.From.Connect()
+	go f.To.Connect()
+	for {
+		select {
+		case m := <-f.From.Out:
+			f.mu.RLock()
+			filter := f.filter
+			f.mu.RUnlock()
+			for _, m := range filter(m) {
+				if !f.To.sendFrom(m, f.disconnect) {
+					return
+				}
+			}
+		case <-f.disconnect:
+			return
+		}
+	}
+}
+
+// Replaces the filter function, taking effect from the next message received.
+// It is safe to call while the Filter is connected.
+func (f *Filter) SetFilter(filter FilterFunc) {
+	f.mu.Lock()
+	f.filter = filter
+	f.mu.Unlock()
+}
+
+func (f *Filter) String() string {
+	return connectorName("Filter", f.Name)
+}
+
+// Rewrites a NoteOn with a velocity of 0 into an explicit NoteOff, for gear
+// that doesn't understand the NoteOn convention for note offs.
+func ExplicitNoteOffs(m Message) []Message {
+	if n, ok := m.(NoteOn); ok && n.Velocity == 0 {
+		return []Message{NoteOff(n)}
+	}
+	return []Message{m}
+}
+
+// Transposes notes, and the aftertouch of their keys, by a number of semitones,
+// dropping any that fall out of range.
+func TransposeBy(semitones int) FilterFunc {
+	return func(m Message) []Message {
+		switch n := m.(type) {
+		case NoteOn:
+			n.Key += semitones
+			if n.Key < 0 || n.Key > 127 {
+				return nil
+			}
+			return []Message{n}
+		case NoteOff:
+			n.Key += semitones
+			if n.Key < 0 || n.Key > 127 {
+				return nil
+			}
+			return []Message{n}
+		case PolyAftertouch:
+			n.Key += semitones
+			if n.Key < 0 || n.Key > 127 {
+				return nil
+			}
+			return []Message{n}
+		}
+		return []Message{m}
+	}
+}
+
+// Drops program changes that repeat the last program change on their channel,
+// unless a bank select (control change 0 or 32) was sent in between. A
+// PatchSelect is always sent, as it selects a bank.
+func UniqueProgramChanges() FilterFunc {
+	programs := make(map[int]int) // Last program by channel.
+	return func(m Message) []Message {
+		switch n := m.(type) {
+		case ProgramChange:
+			if program, ok := programs[n.Channel]; ok && program == n.Program {
+				return nil
+			}
+			programs[n.Channel] = n.Program
+		case PatchSelect:
+			programs[n.Channel] = n.Program
+		case ControlChange:
+			if n.ID == 0 || n.ID == 32 {
+				delete(programs, n.Channel)
+			}
+		}
+		return []Message{m}
+	}
+}
+
+// Drops control changes that repeat the last value sent for their channel and
+// controller, to cut redundant traffic from e.g. jittery faders.
+func CCDedup() FilterFunc {
+	values := make(map[[2]int]int) // Last value by channel and controller.
+	return func(m Message) []Message {
+		if n, ok := m.(ControlChange); ok {
+			control := [2]int{n.Channel, n.ID}
+			if value, ok := values[control]; ok && value == n.Value {
+				Debug.Printf("Dropped repeated %v", n)
+				return nil
+			}
+			values[control] = n.Value
+		}
+		return []Message{m}
+	}
+}
+
+// Sends channel pressure on as a control change (e.g. 11, Expression) too, while
+// notes are held on the channel, for wind controller style expression. The curve
+// maps pressure to control change values; if it is nil they are the same.
+func PressureToControlChange(id int, curve func(pressure int) int) FilterFunc {
+	held := make(map[int]map[int]bool) // Held keys by channel.
+	return func(m Message) []Message {
+		switch n := m.(type) {
+		case NoteOn:
+			if n.Velocity > 0 {
+				if held[n.Channel] == nil {
+					held[n.Channel] = make(map[int]bool)
+				}
+				held[n.Channel][n.Key] = true
+			} else {
+				delete(held[n.Channel], n.Key)
+			}
+		case NoteOff:
+			delete(held[n.Channel], n.Key)
+		case ChannelPressure:
+			if len(held[n.Channel]) == 0 {
+				break
+			}
+			value := n.Pressure
+			if curve != nil {
+				value = curve(value)
+			}
+			return []Message{m, ControlChange{n.Channel, id, value, ControlChangeName(id)}}
+		}
+		return []Message{m}
+	}
+}
+
+// Sends notes of the key as control changes with the ID instead, e.g. for gear
+// that sends a pad as a note where a control change is wanted: a note on sets
+// the control to its velocity and a note off sets it to 0.
+func NoteToControlChange(key, id int) FilterFunc {
+	return func(m Message) []Message {
+		switch n := m.(type) {
+		case NoteOn:
+			if n.Key == key {
+				return []Message{ControlChange{n.Channel, id, n.Velocity, ControlChangeName(id)}}
+			}
+		case NoteOff:
+			if n.Key == key {
+				return []Message{ControlChange{n.Channel, id, 0, ControlChangeName(id)}}
+			}
+		}
+		return []Message{m}
+	}
+}
+
+// Sends control changes with the ID as notes of the key instead, e.g. to play a
+// note with an expression pedal: a note on (with the control's value as velocity)
+// when the control reaches the threshold, and a note off when it falls below it.
+func ControlChangeToNote(id, key, threshold int) FilterFunc {
+	on := make(map[int]bool) // Whether the note is on, by channel.
+	return func(m Message) []Message {
+		n, ok := m.(ControlChange)
+		if !ok || n.ID != id {
+			return []Message{m}
+		}
+		switch {
+		case n.Value >= threshold && !on[n.Channel]:
+			on[n.Channel] = true
+			velocity := n.Value
+			if velocity == 0 {
+				velocity = 1 // A threshold of 0 still needs a note on.
+			}
+			return []Message{NoteOn{n.Channel, key, velocity}}
+		case n.Value < threshold && on[n.Channel]:
+			delete(on, n.Channel)
+			return []Message{NoteOff{n.Channel, key, 0}}
+		}
+		return nil
+	}
+}
+
+// Converts both ways between notes of the key and control changes with the ID,
+// as NoteToControlChange and ControlChangeToNote do, for a setup where e.g. a
+// pedal should play a note and a pad should move a control.
+func NoteCCBridge(key, id, threshold int) FilterFunc {
+	toControlChange := NoteToControlChange(key, id)
+	toNote := ControlChangeToNote(id, key, threshold)
+	return func(m Message) []Message {
+		if _, ok := m.(ControlChange); ok {
+			return toNote(m)
+		}
+		return toControlChange(m)
+	}
+}
+
+// How an endless encoder encodes the increments of a relative control change.
+type EncoderMode int
+
+const (
+	TwosComplement EncoderMode = iota // 1 to 63 increase, 127 down to 64 decrease by 1 to 64.
+	SignMagnitude                     // 1 to 63 increase, 65 to 127 decrease by 1 to 63.
+	BinaryOffset                      // 64 is no change, above increases and below decreases.
+)
+
+// Returns the increment encoded by a control change value.
+func (e EncoderMode) increment(value int) int {
+	switch e {
+	case TwosComplement:
+		if value >= 64 {
+			return value - 128
+		}
+		return value
+	case SignMagnitude:
+		if value&64 != 0 {
+			return -(value & 63)
+		}
+		return value
+	case BinaryOffset:
+		return value - 64
+	}
+	return 0
+}
+
+// Sends the relative control changes of endless encoders with the IDs (every
+// control change if none are given) as absolute control changes, adding each
+// increment to a value kept by channel and controller that starts at 0 and
+// stays within 0 to 127.
+func RelativeControlChanges(mode EncoderMode, ids ...int) FilterFunc {
+	values := make(map[[2]int]int) // Absolute value by channel and controller.
+	return func(m Message) []Message {
+		n, ok := m.(ControlChange)
+		if !ok {
+			return []Message{m}
+		}
+		if len(ids) > 0 {
+			relative := false
+			for _, id := range ids {
+				relative = relative || id == n.ID
+			}
+			if !relative {
+				return []Message{m}
+			}
+		}
+		control := [2]int{n.Channel, n.ID}
+		value := values[control] + mode.increment(n.Value)
+		switch {
+		case value < 0:
+			value = 0
+		case value > 127:
+			value = 127
+		}
+		values[control] = value
+		n.Value = value
+		return []Message{n}
+	}
+}
+
+// How a note on is handled when its note is already sounding.
+type DuplicateNotePolicy int
+
+const (
+	IgnoreDuplicates    DuplicateNotePolicy = iota // Drop the note on.
+	RetriggerDuplicates                            // Send a note off, then the note on.
+	CountDuplicates                                // Keep the note on until each note on has had its note off.
+)
+
+// Handles note ons for notes that are already sounding according to the policy.
+// Note offs for notes that aren't sounding are dropped. All Notes Off ends every
+// note sounding on its channel.
+func DuplicateNoteOns(policy DuplicateNotePolicy) FilterFunc {
+	sounding := make(map[[2]int]int) // Note ons received for each sounding note, by channel and key.
+	return func(m Message) []Message {
+		var channel, key int
+		switch n := m.(type) {
+		case NoteOn:
+			channel, key = n.Channel, n.Key
+			if n.Velocity == 0 {
+				break
+			}
+			note := [2]int{channel, key}
+			if sounding[note] == 0 {
+				sounding[note] = 1
+				return []Message{m}
+			}
+			switch policy {
+			case RetriggerDuplicates:
+				return []Message{NoteOff{channel, key, 0}, m}
+			case CountDuplicates:
+				sounding[note]++
+			}
+			return nil
+		case NoteOff:
+			channel, key = n.Channel, n.Key
+		case ControlChange:
+			if n.ID == 123 {
+				endNotes(sounding, n.Channel)
+			}
+			return []Message{m}
+		case AllNotesOff:
+			endNotes(sounding, n.Channel)
+			return []Message{m}
+		default:
+			return []Message{m}
+		}
+		note := [2]int{channel, key}
+		switch {
+		case sounding[note] == 0:
+			return nil
+		case sounding[note] > 1:
+			sounding[note]--
+			return nil
+		}
+		delete(sounding, note)
+		return []Message{m}
+	}
+}
+
+// Forgets the notes sounding on a channel, keyed by channel and key.
+func endNotes(sounding map[[2]int]int, channel int) {
+	for note := range sounding {
+		if note[0] == channel {
+			delete(sounding, note)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package midi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExplicitNoteOffs(t *testing.T) {
+	filter := NewFilter(NewDevice(), NewDevice(), ExplicitNoteOffs)
+	if err := filter.Open(); err != nil {
+		t.Errorf("Could not open filter: %v", err)
+	}
+	go filter.Connect()
+	defer filter.Close()
+	tests := []struct {
+		in, expected Message
+		status       uint32
+	}{
+		{NoteOn{3, 60, 0}, NoteOff{3, 60, 0}, 0x83},
+		{NoteOn{3, 60, 90}, NoteOn{3, 60, 90}, 0x93},
+		{NoteOff{3, 60, 0}, NoteOff{3, 60, 0}, 0x83},
+	}
+	for _, test := range tests {
+		filter.From.Out <- test.in
+		actual := <-filter.To.In
+		if actual != test.expected {
+			t.Errorf("Received %v from filter instead of %v", actual, test.expected)
+		}
+		if status := actual.Uint32() & 0xFF; status != test.status {
+			t.Errorf("Received status 0x%X on the wire for %v instead of 0x%X",
+				status, test.in, test.status)
+		}
+	}
+}
+
+func TestSetFilter(t *testing.T) {
+	filter := NewFilter(NewDevice(), NewDevice(), TransposeBy(12))
+	if err := filter.Open(); err != nil {
+		t.Errorf("Could not open filter: %v", err)
+	}
+	go filter.Connect()
+	defer filter.Close()
+	filter.From.Out <- NoteOn{0, 60, 100}
+	if actual, expected := <-filter.To.In, (NoteOn{0, 72, 100}); actual != expected {
+		t.Errorf("Received %v from filter instead of %v", actual, expected)
+	}
+	filter.SetFilter(TransposeBy(-5))
+	filter.From.Out <- NoteOn{0, 60, 100}
+	if actual, expected := <-filter.To.In, (NoteOn{0, 55, 100}); actual != expected {
+		t.Errorf("Received %v from filter instead of %v", actual, expected)
+	}
+	filter.From.Out <- PolyAftertouch{0, 60, 90}
+	if actual, expected := <-filter.To.In, (PolyAftertouch{0, 55, 90}); actual != expected {
+		t.Errorf("Received %v from filter instead of %v", actual, expected)
+	}
+	filter.From.Out <- ControlChange{0, 7, 100, ""}
+	if actual, expected := <-filter.To.In, (ControlChange{0, 7, 100, ""}); actual != expected {
+		t.Errorf("Received %v from filter instead of %v", actual, expected)
+	}
+}
+
+func TestUniqueProgramChanges(t *testing.T) {
+	filter := NewFilter(NewDevice(), NewDevice(), UniqueProgramChanges())
+	filter.Open()
+	go filter.Connect()
+	defer filter.Close()
+	in := []Message{
+		ProgramChange{0, 5},
+		ProgramChange{0, 5},
+		ProgramChange{1, 5},
+		ProgramChange{0, 5},
+		ControlChange{0, 0, 1, ""},
+		ProgramChange{0, 5},
+		ProgramChange{0, 6},
+		ProgramChange{0, 6},
+		NoteOn{0, 60, 100},
+	}
+	expected := []Message{
+		ProgramChange{0, 5},
+		ProgramChange{1, 5},
+		ControlChange{0, 0, 1, ""},
+		ProgramChange{0, 5},
+		ProgramChange{0, 6},
+		NoteOn{0, 60, 100},
+	}
+	go func() {
+		for _, m := range in {
+			filter.From.Out <- m
+		}
+	}()
+	for _, e := range expected {
+		if actual := <-filter.To.In; actual != e {
+			t.Errorf("Received %v from filter instead of %v", actual, e)
+		}
+	}
+}
+
+func TestCCDedup(t *testing.T) {
+	f := CCDedup()
+	in := []Message{
+		ControlChange{0, 7, 100, ""},
+		ControlChange{0, 7, 100, ""},
+		ControlChange{0, 1, 100, ""},
+		NoteOn{0, 60, 100},
+		ControlChange{0, 7, 100, ""},
+		ControlChange{1, 7, 100, ""},
+		ControlChange{0, 7, 101, ""},
+		ControlChange{0, 7, 101, ""},
+		ControlChange{0, 7, 100, ""},
+	}
+	expected := []Message{
+		ControlChange{0, 7, 100, ""},
+		ControlChange{0, 1, 100, ""},
+		NoteOn{0, 60, 100},
+		ControlChange{1, 7, 100, ""},
+		ControlChange{0, 7, 101, ""},
+		ControlChange{0, 7, 100, ""},
+	}
+	var actual []Message
+	for _, m := range in {
+		actual = append(actual, f(m)...)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Received %v instead of %v", actual, expected)
+	}
+}
+
+func TestNoteToControlChange(t *testing.T) {
+	f := NoteToControlChange(36, 64)
+	tests := []struct {
+		in       Message
+		expected []Message
+	}{
+		{NoteOn{0, 36, 100}, []Message{ControlChange{0, 64, 100, ControlChangeNames[64]}}},
+		{NoteOff{0, 36, 0}, []Message{ControlChange{0, 64, 0, ControlChangeNames[64]}}},
+		{NoteOn{2, 36, 0}, []Message{ControlChange{2, 64, 0, ControlChangeNames[64]}}},
+		{NoteOn{0, 38, 100}, []Message{NoteOn{0, 38, 100}}},
+	}
+	for _, test := range tests {
+		if actual := f(test.in); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Received %v from %v instead of %v", actual, test.in, test.expected)
+		}
+	}
+}
+
+func TestControlChangeToNote(t *testing.T) {
+	f := ControlChangeToNote(4, 60, 64)
+	tests := []struct {
+		in       Message
+		expected []Message
+	}{
+		{ControlChange{0, 4, 30, ""}, nil},
+		{ControlChange{0, 4, 80, ""}, []Message{NoteOn{0, 60, 80}}},
+		{ControlChange{0, 4, 127, ""}, nil},
+		{ControlChange{1, 4, 100, ""}, []Message{NoteOn{1, 60, 100}}},
+		{ControlChange{0, 4, 63, ""}, []Message{NoteOff{0, 60, 0}}},
+		{ControlChange{0, 4, 10, ""}, nil},
+		{ControlChange{0, 7, 100, ""}, []Message{ControlChange{0, 7, 100, ""}}},
+	}
+	for _, test := range tests {
+		if actual := f(test.in); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Received %v from %v instead of %v", actual, test.in, test.expected)
+		}
+	}
+}
+
+func TestNoteCCBridge(t *testing.T) {
+	f := NoteCCBridge(36, 64, 64)
+	tests := []struct {
+		in       Message
+		expected []Message
+	}{
+		{NoteOn{0, 36, 100}, []Message{ControlChange{0, 64, 100, ControlChangeNames[64]}}},
+		{NoteOff{0, 36, 0}, []Message{ControlChange{0, 64, 0, ControlChangeNames[64]}}},
+		{ControlChange{0, 64, 127, ""}, []Message{NoteOn{0, 36, 127}}},
+		{ControlChange{0, 64, 0, ""}, []Message{NoteOff{0, 36, 0}}},
+		{ControlChange{0, 64, 10, ""}, nil},
+		{NoteOn{0, 38, 100}, []Message{NoteOn{0, 38, 100}}},
+		{ControlChange{0, 7, 100, ""}, []Message{ControlChange{0, 7, 100, ""}}},
+	}
+	for _, test := range tests {
+		if actual := f(test.in); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Received %v from %v instead of %v", actual, test.in, test.expected)
+		}
+	}
+}
+
+func TestRelativeControlChanges(t *testing.T) {
+	tests := []struct {
+		mode     EncoderMode
+		values   []int
+		expected []int
+	}{
+		{TwosComplement, []int{1, 5, 127, 126, 64, 63, 63, 10}, []int{1, 6, 5, 3, 0, 63, 126, 127}},
+		{SignMagnitude, []int{1, 5, 65, 66, 127, 63, 63, 10}, []int{1, 6, 5, 3, 0, 63, 126, 127}},
+		{BinaryOffset, []int{65, 69, 63, 62, 0, 127, 127, 74}, []int{1, 6, 5, 3, 0, 63, 126, 127}},
+	}
+	for _, test := range tests {
+		f := RelativeControlChanges(test.mode, 16)
+		for i, value := range test.values {
+			expected := []Message{ControlChange{0, 16, test.expected[i], ""}}
+			if actual := f(ControlChange{0, 16, value, ""}); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Received %v from increment %d in mode %d instead of %v", actual, value, test.mode, expected)
+			}
+		}
+		other := []Message{ControlChange{0, 7, 100, ""}}
+		if actual := f(other[0]); !reflect.DeepEqual(actual, other) {
+			t.Errorf("Received %v from another control change instead of %v", actual, other)
+		}
+	}
+}
+
+func TestPressureToControlChange(t *testing.T) {
+	f := PressureToControlChange(11, func(pressure int) int { return pressure / 2 })
+	tests := []struct {
+		in       Message
+		expected []Message
+	}{
+		{ChannelPressure{0, 100}, []Message{ChannelPressure{0, 100}}},
+		{NoteOn{0, 60, 100}, []Message{NoteOn{0, 60, 100}}},
+		{ChannelPressure{0, 100}, []Message{
+			ChannelPressure{0, 100}, ControlChange{0, 11, 50, ControlChangeNames[11]}}},
+		{ChannelPressure{1, 100}, []Message{ChannelPressure{1, 100}}},
+		{NoteOff{0, 60, 0}, []Message{NoteOff{0, 60, 0}}},
+		{ChannelPressure{0, 80}, []Message{ChannelPressure{0, 80}}},
+	}
+	for _, test := range tests {
+		actual := f(test.in)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Received %v for %v instead of %v", actual, test.in, test.expected)
+		}
+	}
+}
+
+func TestDuplicateNoteOns(t *testing.T) {
+	in := []Message{
+		NoteOn{0, 60, 100},
+		NoteOn{0, 60, 90},
+		NoteOff{0, 60, 0},
+		NoteOn{0, 60, 0},
+	}
+	tests := map[DuplicateNotePolicy][]Message{
+		IgnoreDuplicates: {
+			NoteOn{0, 60, 100},
+			NoteOff{0, 60, 0},
+		},
+		RetriggerDuplicates: {
+			NoteOn{0, 60, 100},
+			NoteOff{0, 60, 0}, NoteOn{0, 60, 90},
+			NoteOff{0, 60, 0},
+		},
+		CountDuplicates: {
+			NoteOn{0, 60, 100},
+			NoteOn{0, 60, 0},
+		},
+	}
+	for policy, expected := range tests {
+		f := DuplicateNoteOns(policy)
+		var actual []Message
+		for _, m := range in {
+			actual = append(actual, f(m)...)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Received %v with policy %d instead of %v", actual, policy, expected)
+		}
+	}
+}
+
+func TestDeviceReset(t *testing.T) {
+	from, to := NewDevice(), NewDevice()
+	f := NewFilter(from, to, DuplicateNoteOns(IgnoreDuplicates))
+	go f.Connect()
+	defer f.Close()
+
+	from.Out <- NoteOn{3, 60, 100}
+	<-to.In
+	go from.Reset()
+	for channel := 0; channel < 16; channel++ {
+		if m := <-to.In; m != (ControlChange{channel, 123, 0, ControlChangeNames[123]}) {
+			t.Errorf("Received %v when resetting instead of All Notes Off on channel %d", m, channel)
+		}
+	}
+	// The note is no longer held, so it isn't a duplicate.
+	from.Out <- NoteOn{3, 60, 90}
+	if m := <-to.In; m != (NoteOn{3, 60, 90}) {
+		t.Errorf("Received %v after resetting instead of %v", m, NoteOn{3, 60, 90})
+	}
+}
@@ -0,0 +1,125 @@
+package midi
+
+import "sync"
+
+// An Assignment is a control of a MIDI device, as captured by MidiLearn.
+type Assignment struct {
+	Channel    int
+	Controller int  // The ID of a ControlChange, or the key of a NoteOn.
+	Note       bool // The control is a key rather than a controller.
+}
+
+// A MidiLearn transmits MIDI data from one device to another and, when
+// armed, captures the control of the next ControlChange passing through
+// as an Assignment, for mapping controls to parameters.
+// Implements Connector, one to one.
+type MidiLearn struct {
+	Name         string // Identifies the connector in logs.
+	From         *Device
+	To           *Device
+	Learned      chan Assignment // Receives each captured assignment, the latest replacing any not read yet.
+	CaptureNotes bool            // Also capture the key of the next NoteOn.
+	mu           sync.Mutex
+	armed        bool
+	disconnect   chan bool
+}
+
+// Creates a new MidiLearn between the devices sent as parameters.
+func NewMidiLearn(from, to *Device) *MidiLearn {
+	return &MidiLearn{
+		From:       from,
+		To:         to,
+		Learned:    make(chan Assignment, 1),
+		disconnect: make(chan bool, 1),
+	}
+}
+
+// Captures the control of the next ControlChange (or NoteOn, if CaptureNotes is set).
+func (l *MidiLearn) Arm() {
+	l.mu.Lock()
+	l.armed = true
+	l.mu.Unlock()
+}
+
+// Cancels capturing a control, if one hasn't been captured since Arm was called.
+func (l *MidiLearn) Disarm() {
+	l.mu.Lock()
+	l.armed = false
+	l.mu.Unlock()
+}
+
+func (l *MidiLearn) Open() error {
+	if err := l.From.Open(); err != nil {
+		return err
+	}
+	return l.To.Open()
+}
+
+// Ends transmission of MIDI data and closes the connected MIDI devices.
+func (l *MidiLearn) Close() error {
+	Debug.Printf("%v closed", l)
+	l.disconnect <- true
+	if err := l.From.Close(); err != nil {
+		return err
+	}
+	return l.To.Close()
+}
+
+// Begins transmission of MIDI data between the connected MIDI devices.
+func (l *MidiLearn) Connect() {
+	Debug.Printf("%v connected", l)
+	go l.From.Connect()
+	go l.To.Connect()
+	for {
+		select {
+		case m := <-l.From.Out:
+			l.capture(m)
+			if !l.To.sendFrom(m, l.disconnect) {
+				return
+			}
+		case <-l.disconnect:
+			return
+		}
+	}
+}
+
+func (l *MidiLearn) capture(m Message) {
+	var a Assignment
+	switch m := m.(type) {
+	case ControlChange:
+		a = Assignment{Channel: m.Channel, Controller: m.ID}
+	case NoteOn:
+		if !l.CaptureNotes || m.Velocity == 0 {
+			return
+		}
+		a = Assignment{Channel: m.Channel, Controller: m.Key, Note: true}
+	default:
+		return
+	}
+	l.mu.Lock()
+	armed := l.armed
+	l.armed = false
+	l.mu.Unlock()
+	if !armed {
+		return
+	}
+	Debug.Printf("%v learned %+v", l, a)
+	// Never wait for Learned to be read, which would stop MIDI data passing through.
+	for {
+		select {
+		case l.Learned <- a:
+			return
+		default:
+		}
+		select {
+		case old := <-l.Learned:
+			Debug.Printf("%v replaced %+v, which wasn't read", l, old)
+		default:
+			return // Nothing reads an unbuffered Learned.
+		}
+	}
+}
+
+func (l *MidiLearn) String() string {
+	return connectorName("MidiLearn", l.Name)
+}
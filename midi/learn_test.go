@@ -0,0 +1,54 @@
+package midi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMidiLearn(t *testing.T) {
+	from, to := NewDevice(), NewDevice()
+	l := NewMidiLearn(from, to)
+	go l.Connect()
+	defer l.Close()
+
+	send := func(m Message) {
+		from.Out <- m
+		if actual := <-to.In; actual != m {
+			t.Errorf("Sent %v instead of %v", actual, m)
+		}
+	}
+	send(ControlChange{0, 1, 64, ""}) // Not armed.
+	l.Arm()
+	send(NoteOn{2, 60, 100}) // Notes are ignored.
+	send(ControlChange{3, 74, 10, ""})
+	select {
+	case a := <-l.Learned:
+		if expected := (Assignment{Channel: 3, Controller: 74}); a != expected {
+			t.Errorf("Learned %+v instead of %+v", a, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No assignment was learned")
+	}
+	send(ControlChange{4, 75, 10, ""}) // Disarmed after learning.
+	select {
+	case a := <-l.Learned:
+		t.Errorf("Learned %+v without being armed", a)
+	default:
+	}
+
+	l.CaptureNotes = true
+	l.Arm()
+	send(NoteOn{5, 48, 90})
+	if a, expected := <-l.Learned, (Assignment{Channel: 5, Controller: 48, Note: true}); a != expected {
+		t.Errorf("Learned %+v instead of %+v", a, expected)
+	}
+
+	// Assignments not read are replaced rather than holding up the connector.
+	l.Arm()
+	send(ControlChange{6, 1, 0, ""})
+	l.Arm()
+	send(ControlChange{6, 2, 0, ""})
+	if a, expected := <-l.Learned, (Assignment{Channel: 6, Controller: 2}); a != expected {
+		t.Errorf("Learned %+v instead of the latest assignment, %+v", a, expected)
+	}
+}
@@ -0,0 +1,135 @@
+package midi
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// The shape of an LFO's cycle.
+type Waveform int
+
+const (
+	Sine Waveform = iota
+	Triangle
+	Square
+	Saw // Rising.
+)
+
+// Returns the waveform's level, from -1 to 1, at a phase from 0 to 1 through its cycle.
+func (w Waveform) level(phase float64) float64 {
+	switch w {
+	case Triangle:
+		switch {
+		case phase < 0.25:
+			return 4 * phase
+		case phase < 0.75:
+			return 2 - 4*phase
+		}
+		return 4*phase - 4
+	case Square:
+		if phase < 0.5 {
+			return 1
+		}
+		return -1
+	case Saw:
+		return 2*phase - 1
+	}
+	return math.Sin(2 * math.Pi * phase)
+}
+
+// An LFO (low frequency oscillator) is a Device that sends control changes
+// from its Out wire following a waveform, to modulate a parameter of the
+// devices it is connected to. It runs at its own rate, or if ClocksPerCycle
+// is set, advances with each Clock sent to its In wire.
+type LFO struct {
+	*Device
+	Channel        int
+	Controller     int // The ID of the control changes sent.
+	Waveform       Waveform
+	Period         time.Duration // The length of a cycle when not clocked.
+	Steps          int           // Control changes sent per cycle when not clocked.
+	Center         int           // The value at the middle of the waveform.
+	Depth          int           // The difference between the highest and lowest values.
+	ClocksPerCycle int           // Clocks per cycle (sending a control change on each), e.g. 24 for a quarter note.
+	disconnect     chan bool
+}
+
+// Creates a new LFO sweeping the full range of a controller in the period,
+// in 32 steps, or with a period of 0 for an LFO with ClocksPerCycle set.
+// Configure it and then Start it.
+func NewLFO(channel, controller int, waveform Waveform, period time.Duration) (*LFO, error) {
+	if period < 0 {
+		return nil, fmt.Errorf("LFO period %v is negative", period)
+	}
+	return &LFO{
+		Device:     NewDevice(),
+		Channel:    channel,
+		Controller: controller,
+		Waveform:   waveform,
+		Period:     period,
+		Steps:      32,
+		Center:     64,
+		Depth:      127,
+		disconnect: make(chan bool),
+	}, nil
+}
+
+// Begins sending control changes, or returns an error if the LFO isn't
+// clocked and has no Period or Steps to run at.
+func (l *LFO) Start() error {
+	if l.ClocksPerCycle < 0 {
+		return fmt.Errorf("LFO has %d clocks per cycle", l.ClocksPerCycle)
+	}
+	if l.ClocksPerCycle == 0 && (l.Period <= 0 || l.Steps <= 0) {
+		return fmt.Errorf("LFO is not clocked and has a period of %v in %d steps", l.Period, l.Steps)
+	}
+	go l.run()
+	return nil
+}
+
+func (l *LFO) run() {
+	steps := l.ClocksPerCycle
+	var ticker <-chan time.Time
+	if steps == 0 {
+		steps = l.Steps
+		t := time.NewTicker(l.Period / time.Duration(steps))
+		defer t.Stop()
+		ticker = t.C
+	}
+	for step := 0; ; {
+		select {
+		case <-ticker:
+		case m := <-l.In:
+			if _, ok := m.(Clock); !ok || ticker != nil {
+				continue
+			}
+		case <-l.disconnect:
+			return
+		}
+		select {
+		case l.Out <- l.value(float64(step) / float64(steps)):
+		case <-l.disconnect:
+			return
+		}
+		step = (step + 1) % steps
+	}
+}
+
+// Returns the control change sent at a phase from 0 to 1 through the cycle.
+func (l *LFO) value(phase float64) ControlChange {
+	level := l.Waveform.level(phase)
+	value := l.Center + int(math.Round(level*float64(l.Depth)/2))
+	if value < 0 {
+		value = 0
+	} else if value > 127 {
+		value = 127
+	}
+	return ControlChange{l.Channel, l.Controller, value, controlChangeName(l.Controller, nil)}
+}
+
+// Stops sending control changes and closes the device.
+func (l *LFO) Close() error {
+	close(l.disconnect)
+	return l.Device.Close()
+}
@@ -0,0 +1,77 @@
+package midi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLFO(t *testing.T) {
+	l, err := NewLFO(1, 74, Sine, 40*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Steps = 8
+	if err := l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	expected := []int{64, 109, 128, 109, 64, 19, 1, 19} // Rounded, before clamping.
+	for i, e := range expected {
+		select {
+		case m := <-l.Out:
+			cc := m.(ControlChange)
+			if cc.Channel != 1 || cc.ID != 74 || cc.Value < e-2 || cc.Value > e+1 {
+				t.Errorf("Received %v at step %d instead of a value of about %d", cc, i, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Received nothing at step %d", i)
+		}
+	}
+}
+
+func TestClockedLFO(t *testing.T) {
+	tests := map[Waveform][]int{
+		Triangle: {64, 128, 64, 0},
+		Square:   {128, 128, 0, 0},
+		Saw:      {0, 32, 64, 96},
+	}
+	for waveform, expected := range tests {
+		l, err := NewLFO(0, 1, waveform, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.ClocksPerCycle = 4
+		if err := l.Start(); err != nil {
+			t.Fatal(err)
+		}
+		for i, e := range expected {
+			if e > 127 {
+				e = 127
+			}
+			l.In <- NoteOn{0, 60, 100} // Ignored.
+			l.In <- Clock{}
+			if cc := (<-l.Out).(ControlChange); cc.Value < e-1 || cc.Value > e+1 {
+				t.Errorf("Received %v at clock %d of waveform %d instead of a value of about %d",
+					cc, i, waveform, e)
+			}
+		}
+		l.Close()
+	}
+}
+
+func TestLFOInvalid(t *testing.T) {
+	if _, err := NewLFO(0, 1, Sine, -time.Second); err == nil {
+		t.Error("Created an LFO with a negative period")
+	}
+	l, err := NewLFO(0, 1, Sine, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Start(); err == nil {
+		t.Error("Started an LFO with no period or clock")
+	}
+	l.Period, l.Steps = time.Second, 0
+	if err := l.Start(); err == nil {
+		t.Error("Started an LFO with no steps or clock")
+	}
+}
@@ -0,0 +1,184 @@
+package midi
+
+import (
+	"sync"
+	"time"
+)
+
+// Playback channel for a Looper that plays messages on the channel they were recorded on.
+const SameChannel = -1
+
+// A Looper transmits MIDI data from one device to another and can record it,
+// playing the recording back to the destination device repeatedly.
+// Recording while the loop plays overdubs the loop.
+// Implements Connector, one to one.
+type Looper struct {
+	Name            string // Identifies the connector in logs.
+	From            *Device
+	To              *Device
+	PlaybackChannel int           // The channel recorded messages are played back on, or SameChannel.
+	MaxAge          time.Duration // Messages played back more than MaxAge late are dropped, or none if 0.
+	mu              sync.Mutex
+	events          Track
+	recording       bool
+	playing         bool
+	start           time.Time     // When recording (or the current pass of playback) began.
+	length          time.Duration // Set once the first recording is played.
+	stop            chan bool     // Closed to stop playback.
+	done            chan bool     // Closed once playback has stopped.
+	disconnect      chan bool
+}
+
+// Creates a new Looper between the devices sent as parameters.
+func NewLooper(from, to *Device) *Looper {
+	return &Looper{
+		From:            from,
+		To:              to,
+		PlaybackChannel: SameChannel,
+		disconnect:      make(chan bool, 1),
+	}
+}
+
+func (l *Looper) Open() error {
+	if err := l.From.Open(); err != nil {
+		return err
+	}
+	return l.To.Open()
+}
+
+// Ends transmission of MIDI data and closes the connected MIDI devices.
+func (l *Looper) Close() error {
+	Debug.Printf("%v closed", l)
+	l.Stop()
+	l.disconnect <- true
+	if err := l.From.Close(); err != nil {
+		return err
+	}
+	return l.To.Close()
+}
+
+// Begins transmission of MIDI data between the connected MIDI devices.
+func (l *Looper) Connect() {
+	Debug.Printf("%v connected", l)
+	go l.From.Connect()
+	go l.To.Connect()
+	for {
+		select {
+		case m := <-l.From.Out:
+			l.record(m)
+			if !l.To.sendFrom(m, l.disconnect) {
+				return
+			}
+		case <-l.disconnect:
+			return
+		}
+	}
+}
+
+// Begins recording, or overdubbing if the loop is playing.
+func (l *Looper) Record() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.playing {
+		l.start = time.Now()
+	}
+	l.recording = true
+}
+
+// Ends recording and plays back the loop. The length of the loop is
+// set by the time between the first calls to Record and Play.
+func (l *Looper) Play() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.length == 0 {
+		if !l.recording {
+			return
+		}
+		l.length = time.Since(l.start)
+	}
+	l.recording = false
+	if !l.playing {
+		l.playing = true
+		previous := l.done
+		l.stop, l.done = make(chan bool), make(chan bool)
+		go l.play(previous, l.stop, l.done)
+	}
+}
+
+// Stops playback and recording, once nothing more will be played. The loop
+// is kept.
+func (l *Looper) Stop() {
+	l.mu.Lock()
+	l.recording = false
+	if l.playing {
+		l.playing = false
+		close(l.stop)
+	}
+	done := l.done
+	l.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func (l *Looper) record(m Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.recording {
+		return
+	}
+	offset := time.Since(l.start)
+	if l.length > 0 {
+		offset %= l.length
+	}
+	l.events = append(l.events, TimedMessage{offset, m})
+}
+
+// Plays the loop until stop is closed, once the playback before it, if any,
+// has ended, so that only one plays at a time.
+func (l *Looper) play(previous, stop, done chan bool) {
+	defer close(done)
+	if previous != nil {
+		<-previous
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		l.mu.Lock()
+		l.start = time.Now()
+		start := l.start
+		events := make(Track, len(l.events))
+		copy(events, l.events)
+		l.mu.Unlock()
+		sortTimed(events)
+		for _, e := range events {
+			m := e.Message
+			if l.PlaybackChannel != SameChannel {
+				m = withChannel(m, l.PlaybackChannel)
+			}
+			select {
+			case <-time.After(time.Until(start.Add(e.Time))):
+			case <-stop:
+				return
+			}
+			if stale(m, start.Add(e.Time), l.MaxAge) {
+				continue
+			}
+			if !l.To.sendFrom(m, stop) {
+				return
+			}
+		}
+		select {
+		case <-time.After(time.Until(start.Add(l.length))):
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (l *Looper) String() string {
+	return connectorName("Looper", l.Name)
+}
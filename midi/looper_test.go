@@ -0,0 +1,64 @@
+package midi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLooperPlaybackChannel(t *testing.T) {
+	looper := NewLooper(NewDevice(), NewDevice())
+	looper.PlaybackChannel = 5
+	if err := looper.Open(); err != nil {
+		t.Errorf("Could not open looper: %v", err)
+	}
+	go looper.Connect()
+	defer looper.Close()
+
+	recorded := []Message{NoteOn{0, 60, 100}, NoteOff{0, 60, 0}}
+	looper.Record()
+	for _, m := range recorded {
+		looper.From.Out <- m
+		if actual := <-looper.To.In; actual != m {
+			t.Errorf("Received %v from looper while recording instead of %v", actual, m)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	looper.Play()
+	for pass := 0; pass < 2; pass++ {
+		for _, m := range recorded {
+			expected := withChannel(m, 5)
+			select {
+			case actual := <-looper.To.In:
+				if actual != expected {
+					t.Errorf("Received %v from looper on pass %d instead of %v",
+						actual, pass, expected)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Looper did not play back %v on pass %d", expected, pass)
+			}
+		}
+	}
+}
+
+func TestLooperStopPlay(t *testing.T) {
+	looper := NewLooper(NewDevice(), NewDevice())
+	if err := looper.Open(); err != nil {
+		t.Errorf("Could not open looper: %v", err)
+	}
+	go looper.Connect()
+	defer looper.Close()
+
+	looper.Record()
+	looper.From.Out <- NoteOn{0, 60, 100}
+	<-looper.To.In
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		looper.Play()
+		looper.Stop()
+	}
+	select {
+	case m := <-looper.To.In:
+		t.Errorf("Received %v from looper after it stopped", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
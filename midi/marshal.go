@@ -0,0 +1,122 @@
+package midi
+
+import (
+	"fmt"
+	"reflect"
+)
+
+func (m message) MarshalMIDI() []byte              { return MessageBytes(m) }
+func (n NoteOn) MarshalMIDI() []byte               { return MessageBytes(n) }
+func (n NoteOff) MarshalMIDI() []byte              { return MessageBytes(n) }
+func (p PolyAftertouch) MarshalMIDI() []byte       { return MessageBytes(p) }
+func (c ControlChange) MarshalMIDI() []byte        { return MessageBytes(c) }
+func (p ProgramChange) MarshalMIDI() []byte        { return MessageBytes(p) }
+func (c ChannelPressure) MarshalMIDI() []byte      { return MessageBytes(c) }
+func (p PitchBend) MarshalMIDI() []byte            { return MessageBytes(p) }
+func (s SysEx) MarshalMIDI() []byte                { return MessageBytes(s) }
+func (c Clock) MarshalMIDI() []byte                { return MessageBytes(c) }
+func (s Start) MarshalMIDI() []byte                { return MessageBytes(s) }
+func (c Continue) MarshalMIDI() []byte             { return MessageBytes(c) }
+func (s Stop) MarshalMIDI() []byte                 { return MessageBytes(s) }
+func (s SongPosition) MarshalMIDI() []byte         { return MessageBytes(s) }
+func (s SongSelect) MarshalMIDI() []byte           { return MessageBytes(s) }
+func (q QuarterFrame) MarshalMIDI() []byte         { return MessageBytes(q) }
+func (h HighResControlChange) MarshalMIDI() []byte { return MessageBytes(h) }
+func (p ParameterChange) MarshalMIDI() []byte      { return MessageBytes(p) }
+func (a AllSoundOff) MarshalMIDI() []byte          { return MessageBytes(a) }
+func (r ResetAllControllers) MarshalMIDI() []byte  { return MessageBytes(r) }
+func (l LocalControl) MarshalMIDI() []byte         { return MessageBytes(l) }
+func (a AllNotesOff) MarshalMIDI() []byte          { return MessageBytes(a) }
+func (o OmniMode) MarshalMIDI() []byte             { return MessageBytes(o) }
+func (m MonoMode) MarshalMIDI() []byte             { return MessageBytes(m) }
+func (p PolyMode) MarshalMIDI() []byte             { return MessageBytes(p) }
+
+func (n *NoteOn) UnmarshalMIDI(b []byte) error          { return unmarshal(b, n) }
+func (n *NoteOff) UnmarshalMIDI(b []byte) error         { return unmarshal(b, n) }
+func (p *PolyAftertouch) UnmarshalMIDI(b []byte) error  { return unmarshal(b, p) }
+func (c *ControlChange) UnmarshalMIDI(b []byte) error   { return unmarshal(b, c) }
+func (p *ProgramChange) UnmarshalMIDI(b []byte) error   { return unmarshal(b, p) }
+func (c *ChannelPressure) UnmarshalMIDI(b []byte) error { return unmarshal(b, c) }
+func (p *PitchBend) UnmarshalMIDI(b []byte) error       { return unmarshal(b, p) }
+func (s *SysEx) UnmarshalMIDI(b []byte) error           { return unmarshal(b, s) }
+func (c *Clock) UnmarshalMIDI(b []byte) error           { return unmarshal(b, c) }
+func (s *Start) UnmarshalMIDI(b []byte) error           { return unmarshal(b, s) }
+func (c *Continue) UnmarshalMIDI(b []byte) error        { return unmarshal(b, c) }
+func (s *Stop) UnmarshalMIDI(b []byte) error            { return unmarshal(b, s) }
+func (s *SongPosition) UnmarshalMIDI(b []byte) error    { return unmarshal(b, s) }
+func (s *SongSelect) UnmarshalMIDI(b []byte) error      { return unmarshal(b, s) }
+func (q *QuarterFrame) UnmarshalMIDI(b []byte) error    { return unmarshal(b, q) }
+
+func (h *HighResControlChange) UnmarshalMIDI(b []byte) error {
+	if len(b) != 6 {
+		return fmt.Errorf("Invalid high resolution control change: % X", b)
+	}
+	return unmarshalControlChanges(b, HighResControlChanges(int(b[1])), h)
+}
+
+func (p *ParameterChange) UnmarshalMIDI(b []byte) error {
+	return unmarshalControlChanges(b, ParameterChanges(), p)
+}
+
+func (a *AllSoundOff) UnmarshalMIDI(b []byte) error {
+	return unmarshalControlChanges(b, ChannelModeMessages(), a)
+}
+
+func (r *ResetAllControllers) UnmarshalMIDI(b []byte) error {
+	return unmarshalControlChanges(b, ChannelModeMessages(), r)
+}
+
+func (l *LocalControl) UnmarshalMIDI(b []byte) error {
+	return unmarshalControlChanges(b, ChannelModeMessages(), l)
+}
+
+func (a *AllNotesOff) UnmarshalMIDI(b []byte) error {
+	return unmarshalControlChanges(b, ChannelModeMessages(), a)
+}
+
+func (o *OmniMode) UnmarshalMIDI(b []byte) error {
+	return unmarshalControlChanges(b, ChannelModeMessages(), o)
+}
+
+func (m *MonoMode) UnmarshalMIDI(b []byte) error {
+	return unmarshalControlChanges(b, ChannelModeMessages(), m)
+}
+
+func (p *PolyMode) UnmarshalMIDI(b []byte) error {
+	return unmarshalControlChanges(b, ChannelModeMessages(), p)
+}
+
+// Sets the message that m points to from the bytes of a message of its type.
+func unmarshal(b []byte, m Unmarshaler) error {
+	parsed, err := ParseMessage(b)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(m).Elem()
+	if reflect.TypeOf(parsed) != v.Type() {
+		return fmt.Errorf("% X is a %T, not a %v", b, parsed, v.Type())
+	}
+	v.Set(reflect.ValueOf(parsed))
+	return nil
+}
+
+// Sets the message that m points to from the last message the filter sends
+// for the control changes in the bytes, which must be of its type.
+func unmarshalControlChanges(b []byte, f FilterFunc, m Unmarshaler) error {
+	var p StreamParser
+	var last Message
+	for _, cc := range p.Parse(b) {
+		if _, ok := cc.(ControlChange); !ok {
+			return fmt.Errorf("% X is not only control changes", b)
+		}
+		for _, filtered := range f(cc) {
+			last = filtered
+		}
+	}
+	v := reflect.ValueOf(m).Elem()
+	if last == nil || reflect.TypeOf(last) != v.Type() {
+		return fmt.Errorf("% X is not a %v", b, v.Type())
+	}
+	v.Set(reflect.ValueOf(last))
+	return nil
+}
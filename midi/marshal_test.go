@@ -0,0 +1,48 @@
+package midi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, m := range []Message{
+		NoteOn{1, 60, 100},
+		NoteOff{1, 60, 64},
+		PolyAftertouch{1, 60, 80},
+		ControlChange{2, 7, 100, ControlChangeNames[7]},
+		ProgramChange{3, 42},
+		ChannelPressure{4, 90},
+		PitchBend{5, 12000},
+		SysEx{[]byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+		Clock{},
+		Start{},
+		Continue{},
+		Stop{},
+		SongPosition{1000},
+		SongSelect{5},
+		QuarterFrame{7, 3},
+		HighResControlChange{6, 1, 9000},
+		ParameterChange{7, true, 2, 300},
+		LocalControl{8, true},
+		MonoMode{9, 2},
+		AllNotesOff{10},
+	} {
+		u := reflect.New(reflect.TypeOf(m)).Interface().(Unmarshaler)
+		if err := u.UnmarshalMIDI(m.MarshalMIDI()); err != nil {
+			t.Errorf("Could not unmarshal % X: %v", m.MarshalMIDI(), err)
+			continue
+		}
+		if actual := reflect.ValueOf(u).Elem().Interface(); !reflect.DeepEqual(actual, m) {
+			t.Errorf("Unmarshaled %v instead of %v", actual, m)
+		}
+	}
+	var n NoteOn
+	if err := n.UnmarshalMIDI(ProgramChange{0, 1}.MarshalMIDI()); err == nil {
+		t.Errorf("Unmarshaled a program change as %v without error", n)
+	}
+	var p ParameterChange
+	if err := p.UnmarshalMIDI(NoteOn{0, 60, 100}.MarshalMIDI()); err == nil {
+		t.Errorf("Unmarshaled a note on as %v without error", p)
+	}
+}
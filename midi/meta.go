@@ -0,0 +1,175 @@
+package midi
+
+/*
+Meta events are the non-MIDI events found in Standard MIDI Files (tempo,
+time signature, markers, text and so on.) On the wire (or on disk) a meta
+event is encoded as:
+    0xFF <type> <length as a variable-length quantity> <data>
+The types here can be encoded and decoded independently of any file.
+*/
+
+import (
+	"fmt"
+)
+
+const (
+	META_EVENT          int = 0xFF
+	META_TEXT           int = 0x01
+	META_COPYRIGHT      int = 0x02
+	META_TRACK_NAME     int = 0x03
+	META_MARKER         int = 0x06
+	META_END_OF_TRACK   int = 0x2F
+	META_SET_TEMPO      int = 0x51
+	META_TIME_SIGNATURE int = 0x58
+	META_KEY_SIGNATURE  int = 0x59
+)
+
+type MetaEvent interface {
+	MetaType() int
+	MetaData() []byte
+}
+
+// Text is any of the text meta events (text, copyright, track name, marker.)
+type Text struct {
+	Type int // One of META_TEXT, META_COPYRIGHT, META_TRACK_NAME or META_MARKER.
+	Text string
+}
+
+func (t Text) MetaType() int    { return t.Type }
+func (t Text) MetaData() []byte { return []byte(t.Text) }
+
+type SetTempo struct {
+	MicrosecondsPerQuarterNote int
+}
+
+// Creates a SetTempo from beats (quarter notes) per minute.
+func NewSetTempo(bpm float64) SetTempo {
+	return SetTempo{int(60000000/bpm + 0.5)}
+}
+
+func (s SetTempo) BPM() float64 {
+	return 60000000 / float64(s.MicrosecondsPerQuarterNote)
+}
+
+func (s SetTempo) MetaType() int { return META_SET_TEMPO }
+func (s SetTempo) MetaData() []byte {
+	u := s.MicrosecondsPerQuarterNote
+	return []byte{byte(u >> 16), byte(u >> 8), byte(u)}
+}
+
+type TimeSignature struct {
+	Numerator      int
+	Denominator    int // The actual denominator (e.g. 8 for 6/8), not the power of 2 stored on the wire.
+	ClocksPerClick int // MIDI clocks per metronome click.
+	ThirtySeconds  int // Notated 32nd notes per quarter note, normally 8.
+}
+
+func (t TimeSignature) MetaType() int { return META_TIME_SIGNATURE }
+func (t TimeSignature) MetaData() []byte {
+	power := 0
+	for d := t.Denominator; d > 1; d >>= 1 {
+		power++
+	}
+	return []byte{byte(t.Numerator), byte(power), byte(t.ClocksPerClick), byte(t.ThirtySeconds)}
+}
+
+type KeySignature struct {
+	Sharps int // Negative for flats.
+	Minor  bool
+}
+
+func (k KeySignature) MetaType() int { return META_KEY_SIGNATURE }
+func (k KeySignature) MetaData() []byte {
+	var minor byte
+	if k.Minor {
+		minor = 1
+	}
+	return []byte{byte(int8(k.Sharps)), minor}
+}
+
+type EndOfTrack struct{}
+
+func (e EndOfTrack) MetaType() int    { return META_END_OF_TRACK }
+func (e EndOfTrack) MetaData() []byte { return nil }
+
+// A meta event of a type that isn't otherwise understood.
+type UnknownMetaEvent struct {
+	Type int
+	Data []byte
+}
+
+func (u UnknownMetaEvent) MetaType() int    { return u.Type }
+func (u UnknownMetaEvent) MetaData() []byte { return u.Data }
+
+// Encodes a meta event into the bytes used by Standard MIDI Files.
+func EncodeMetaEvent(m MetaEvent) []byte {
+	data := m.MetaData()
+	b := []byte{byte(META_EVENT), byte(m.MetaType())}
+	b = appendVarLen(b, len(data))
+	return append(b, data...)
+}
+
+// Decodes a meta event from the start of b, returning it with the number of bytes consumed.
+func DecodeMetaEvent(b []byte) (m MetaEvent, n int, err error) {
+	if len(b) < 3 || int(b[0]) != META_EVENT {
+		return nil, 0, fmt.Errorf("Invalid meta event: % X", b)
+	}
+	length, l, err := readVarLen(b[2:])
+	if err != nil {
+		return nil, 0, err
+	}
+	n = 2 + l + length
+	if len(b) < n {
+		return nil, 0, fmt.Errorf("Meta event of length %d truncated to %d bytes", length, len(b)-2-l)
+	}
+	metaType, data := int(b[1]), b[2+l:n]
+	switch metaType {
+	case META_TEXT, META_COPYRIGHT, META_TRACK_NAME, META_MARKER:
+		return Text{metaType, string(data)}, n, nil
+	case META_SET_TEMPO:
+		if len(data) == 3 {
+			u := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+			return SetTempo{u}, n, nil
+		}
+	case META_TIME_SIGNATURE:
+		if len(data) == 4 {
+			return TimeSignature{int(data[0]), 1 << data[1], int(data[2]), int(data[3])}, n, nil
+		}
+	case META_KEY_SIGNATURE:
+		if len(data) == 2 {
+			return KeySignature{int(int8(data[0])), data[1] == 1}, n, nil
+		}
+	case META_END_OF_TRACK:
+		if len(data) == 0 {
+			return EndOfTrack{}, n, nil
+		}
+	default:
+		return UnknownMetaEvent{metaType, append([]byte(nil), data...)}, n, nil
+	}
+	return nil, 0, fmt.Errorf("Invalid data for meta event type 0x%02X: % X", metaType, data)
+}
+
+// Appends a variable-length quantity (7 bits per byte, most significant first.)
+func appendVarLen(b []byte, u int) []byte {
+	var buf [4]byte
+	i := len(buf) - 1
+	buf[i] = byte(u & 0x7F)
+	for u >>= 7; u > 0 && i > 0; u >>= 7 {
+		i--
+		buf[i] = byte(u&0x7F) | 0x80
+	}
+	return append(b, buf[i:]...)
+}
+
+// Reads a variable-length quantity, returning it with the number of bytes consumed.
+func readVarLen(b []byte) (u, n int, err error) {
+	for n < len(b) && n < 4 {
+		c := b[n]
+		n++
+		u = (u << 7) | int(c&0x7F)
+		if c&0x80 == 0 {
+			return u, n, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("Invalid variable-length quantity: % X", b[:n])
+}
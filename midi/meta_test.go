@@ -0,0 +1,51 @@
+package midi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSetTempo(t *testing.T) {
+	tempo := NewSetTempo(120)
+	expected := []byte{0xFF, 0x51, 0x03, 0x07, 0xA1, 0x20}
+	actual := EncodeMetaEvent(tempo)
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("Encoded %v as % X instead of % X", tempo, actual, expected)
+	}
+	decoded, n, err := DecodeMetaEvent(actual)
+	if err != nil {
+		t.Fatalf("Could not decode % X: %v", actual, err)
+	}
+	if decoded != tempo || n != len(actual) {
+		t.Errorf("Decoded %v (%d bytes) instead of %v (%d bytes)", decoded, n, tempo, len(actual))
+	}
+	if bpm := decoded.(SetTempo).BPM(); bpm != 120 {
+		t.Errorf("Received %v BPM instead of 120", bpm)
+	}
+}
+
+func TestMetaEventRoundTrip(t *testing.T) {
+	events := []MetaEvent{
+		Text{META_MARKER, "Chorus"},
+		Text{META_TRACK_NAME, string(make([]byte, 200))},
+		TimeSignature{6, 8, 36, 8},
+		KeySignature{-3, true},
+		EndOfTrack{},
+		UnknownMetaEvent{0x7F, []byte{1, 2, 3}},
+	}
+	for _, expected := range events {
+		b := EncodeMetaEvent(expected)
+		actual, n, err := DecodeMetaEvent(b)
+		if err != nil {
+			t.Errorf("Could not decode % X: %v", b, err)
+			continue
+		}
+		if !reflect.DeepEqual(expected, actual) || n != len(b) {
+			t.Errorf("Decoded %v (%d bytes) instead of %v (%d bytes)", actual, n, expected, len(b))
+		}
+	}
+	if _, _, err := DecodeMetaEvent([]byte{0xFF, 0x51, 0x03, 0x07}); err == nil {
+		t.Errorf("Decoded a truncated meta event without error")
+	}
+}
@@ -1,13 +1,19 @@
 package midi
 
+import "fmt"
+
 const (
 	BufferSize int = 1
 )
 
 const (
-	NOTE_ON        int = 144
-	NOTE_OFF       int = 128
-	CONTROL_CHANGE int = 176
+	NOTE_ON          int = 144
+	NOTE_OFF         int = 128
+	POLY_AFTERTOUCH  int = 160
+	CONTROL_CHANGE   int = 176
+	PROGRAM_CHANGE   int = 192
+	CHANNEL_PRESSURE int = 208
+	PITCH_BEND       int = 224
 )
 
 type Opener interface {
@@ -26,8 +32,19 @@ type Uint32er interface {
 	Uint32() uint32
 }
 
+// A Message is MIDI data. Each message can be marshaled to the bytes sent over
+// the wire for it, and (through a pointer) unmarshaled from them, as MIDI files
+// and networks carry it. Validate returns an error wrapping ErrInvalidMessage
+// if any of a message's values are out of range.
 type Message interface {
 	Uint32er
+	MarshalMIDI() []byte
+	Validate() error
+}
+
+// An Unmarshaler is a pointer to a Message, which can be set from the bytes sent over the wire for it.
+type Unmarshaler interface {
+	UnmarshalMIDI([]byte) error
 }
 
 type message struct {
@@ -35,6 +52,7 @@ type message struct {
 	Command int
 	Data1   int
 	Data2   int
+	Raw     uint32 // The full message as read, including any data beyond Data2.
 }
 
 func newMessage(u uint32) *message {
@@ -47,16 +65,79 @@ func newMessage(u uint32) *message {
 		Command: int(status & 0xF0),
 		Data1:   int((u >> 8) & 0xFF),
 		Data2:   int((u >> 16) & 0xFF),
+		Raw:     u,
 	}
 }
 
 func (m message) Uint32() uint32 {
+	if m.Raw != 0 {
+		return m.Raw
+	}
 	status := m.Command + m.Channel
 	return ((uint32(m.Data2) << 16) & 0xFF0000) |
 		((uint32(m.Data1) << 8) & 0x00FF00) |
 		(uint32(status) & 0x0000FF)
 }
 
+// A RawMessage is a decoded Message read with data beyond its standard fields
+// (in the high byte), as some proprietary gear sends, kept whole in Raw.
+type RawMessage struct {
+	Message
+	Raw uint32
+}
+
+func (r RawMessage) Uint32() uint32 {
+	return r.Raw
+}
+
+// Returns the message as the type for its command, or as is if there is none,
+// wrapped in a RawMessage if it has data beyond its standard fields.
+// Control changes are named from names, if given, or by ControlChangeName.
+func (m *message) typed(names map[int]string) Message {
+	t := m.decode(names)
+	if _, ok := t.(message); !ok && m.Raw>>24 != 0 {
+		return RawMessage{t, m.Raw}
+	}
+	return t
+}
+
+func (m *message) decode(names map[int]string) Message {
+	switch m.Command {
+	case NOTE_ON:
+		return NoteOn{m.Channel, m.Data1, m.Data2}
+	case NOTE_OFF:
+		// The release velocity (Data2) is kept for instruments that use it.
+		return NoteOff{m.Channel, m.Data1, m.Data2}
+	case POLY_AFTERTOUCH:
+		return PolyAftertouch{m.Channel, m.Data1, m.Data2}
+	case CONTROL_CHANGE:
+		return ControlChange{m.Channel, m.Data1, m.Data2, controlChangeName(m.Data1, names)}
+	case PROGRAM_CHANGE:
+		return ProgramChange{m.Channel, m.Data1}
+	case CHANNEL_PRESSURE:
+		return ChannelPressure{m.Channel, m.Data1}
+	case PITCH_BEND:
+		return PitchBend{m.Channel, m.Data1 | m.Data2<<7}
+	}
+	switch m.Command + m.Channel {
+	case QUARTER_FRAME:
+		return QuarterFrame{m.Data1 >> 4 & 0x07, m.Data1 & 0x0F}
+	case SONG_POSITION:
+		return SongPosition{m.Data1 | m.Data2<<7}
+	case SONG_SELECT:
+		return SongSelect{m.Data1}
+	case CLOCK:
+		return Clock{}
+	case START:
+		return Start{}
+	case CONTINUE:
+		return Continue{}
+	case STOP:
+		return Stop{}
+	}
+	return *m
+}
+
 type NoteOn struct {
 	Channel  int
 	Key      int
@@ -64,13 +145,48 @@ type NoteOn struct {
 }
 
 func (n NoteOn) Uint32() uint32 {
-	return message{n.Channel, NOTE_ON, n.Key, n.Velocity}.Uint32()
+	return message{n.Channel, NOTE_ON, n.Key, n.Velocity, 0}.Uint32()
+}
+
+func (n NoteOn) String() string {
+	return fmt.Sprintf("NoteOn{Channel:%d Key:%d Velocity:%d%v}",
+		n.Channel, n.Key, n.Velocity, keyName(n.Channel, n.Key))
 }
 
 type NoteOff NoteOn
 
 func (n NoteOff) Uint32() uint32 {
-	return message{n.Channel, NOTE_OFF, n.Key, n.Velocity}.Uint32()
+	return message{n.Channel, NOTE_OFF, n.Key, n.Velocity, 0}.Uint32()
+}
+
+func (n NoteOff) String() string {
+	return fmt.Sprintf("NoteOff{Channel:%d Key:%d Velocity:%d%v}",
+		n.Channel, n.Key, n.Velocity, keyName(n.Channel, n.Key))
+}
+
+// Names a key for a message's String: as the percussion it plays on the
+// PercussionChannel, or otherwise as its note name.
+func keyName(channel, key int) string {
+	if name, ok := PercussionNames[key]; ok && channel == PercussionChannel {
+		return " Name:" + name
+	}
+	name := NoteName(key)
+	if channel == PercussionChannel || name == "" {
+		return ""
+	}
+	return " Name:" + name
+}
+
+// PolyAftertouch (a.k.a. polyphonic key pressure) is how hard a single key is
+// held down, for instruments that sense the pressure of each key.
+type PolyAftertouch struct {
+	Channel  int
+	Key      int
+	Pressure int
+}
+
+func (p PolyAftertouch) Uint32() uint32 {
+	return message{p.Channel, POLY_AFTERTOUCH, p.Key, p.Pressure, 0}.Uint32()
 }
 
 type ControlChange struct {
@@ -81,10 +197,319 @@ type ControlChange struct {
 }
 
 func (c ControlChange) Uint32() uint32 {
-	return message{c.Channel, CONTROL_CHANGE, c.ID, c.Value}.Uint32()
+	return message{c.Channel, CONTROL_CHANGE, c.ID, c.Value, 0}.Uint32()
+}
+
+// ChannelPressure (a.k.a. channel aftertouch) is how hard keys are held down
+// on a channel, as a single value for all keys.
+type ChannelPressure struct {
+	Channel  int
+	Pressure int
+}
+
+func (c ChannelPressure) Uint32() uint32 {
+	return message{c.Channel, CHANNEL_PRESSURE, c.Pressure, 0, 0}.Uint32()
+}
+
+// The PitchBend value of a pitch wheel at rest.
+const PitchBendCenter = 8192
+
+// PitchBend is the position of a pitch wheel on a channel.
+type PitchBend struct {
+	Channel int
+	Value   int // 14 bits, from 0 (bent down fully) to 16383 (bent up fully).
+}
+
+func (p PitchBend) Uint32() uint32 {
+	return message{p.Channel, PITCH_BEND, p.Value & 0x7F, (p.Value >> 7) & 0x7F, 0}.Uint32()
+}
+
+const (
+	SYSEX         int = 0xF0
+	SONG_POSITION int = 0xF2
+	SONG_SELECT   int = 0xF3
+	CLOCK         int = 0xF8
+	START         int = 0xFA
+	CONTINUE      int = 0xFB
+	STOP          int = 0xFC
+)
+
+// Clock is sent 24 times per quarter note by a device that sets the tempo.
+type Clock struct{}
+
+func (c Clock) Uint32() uint32 { return uint32(CLOCK) }
+
+// Start tells devices to play from the beginning of the song.
+type Start struct{}
+
+func (s Start) Uint32() uint32 { return uint32(START) }
+
+// Continue tells devices to play from the current song position.
+type Continue struct{}
+
+func (c Continue) Uint32() uint32 { return uint32(CONTINUE) }
+
+// Stop tells devices to stop playing, keeping the current song position.
+type Stop struct{}
+
+func (s Stop) Uint32() uint32 { return uint32(STOP) }
+
+// SongPosition sets the song position, counted in MIDI beats (16th notes, or 6 clocks)
+// from the start of the song.
+type SongPosition struct {
+	Beats int // 14 bits.
+}
+
+func (s SongPosition) Uint32() uint32 {
+	return message{0, SONG_POSITION, s.Beats & 0x7F, (s.Beats >> 7) & 0x7F, 0}.Uint32()
+}
+
+// SongSelect chooses the song or sequence to be played.
+type SongSelect struct {
+	Song int // 7 bits.
+}
+
+func (s SongSelect) Uint32() uint32 {
+	return message{0, SONG_SELECT, s.Song & 0x7F, 0, 0}.Uint32()
+}
+
+// Returns the number of bytes in a message with the given status byte,
+// or 0 if it varies (for SysEx) or the status is undefined.
+func MessageLength(status int) int {
+	switch {
+	case status < 0x80:
+		return 0
+	case status < 0xC0, status >= 0xE0 && status < 0xF0:
+		return 3
+	case status < 0xE0:
+		return 2
+	}
+	switch status {
+	case QUARTER_FRAME, SONG_SELECT:
+		return 2
+	case SONG_POSITION:
+		return 3
+	case 0xF6, CLOCK, START, CONTINUE, STOP, 0xFE, 0xFF:
+		return 1
+	}
+	return 0
+}
+
+// A message that is sent as several messages, e.g. a ParameterChange.
+type compound interface {
+	Message
+	Messages() []Message
+}
+
+// Returns the bytes of a message as sent over the wire. Those of a message
+// sent as several, e.g. a ParameterChange, are the bytes of each in turn, so
+// formats that frame each message write its Messages one at a time instead.
+func MessageBytes(m Message) []byte {
+	switch n := m.(type) {
+	case SysEx:
+		return n.Data
+	case compound:
+		var b []byte
+		for _, m := range n.Messages() {
+			b = append(b, MessageBytes(m)...)
+		}
+		return b
+	}
+	u := m.Uint32()
+	b := []byte{byte(u), byte(u >> 8), byte(u >> 16)}
+	return b[:MessageLength(int(b[0]))]
+}
+
+// Returns the bytes of a message as sent over the wire in hexadecimal, e.g. "90 3C 64".
+func HexDump(m Message) string {
+	return fmt.Sprintf("% X", MessageBytes(m))
+}
+
+// Returns the message for bytes sent over the wire.
+func ParseMessage(b []byte) (Message, error) {
+	if len(b) > 0 && int(b[0]) == SYSEX {
+		return SysEx{append([]byte(nil), b...)}, nil
+	}
+	if len(b) == 0 || MessageLength(int(b[0])) != len(b) {
+		return nil, fmt.Errorf("Invalid MIDI message: % X", b)
+	}
+	var u uint32
+	for i, c := range b {
+		u |= uint32(c) << (8 * uint(i))
+	}
+	return newMessage(u).typed(nil), nil
+}
+
+// Returns the order in which a message is sent relative to others scheduled
+// for the same time, lowest first. By MIDI convention note offs come first (so
+// a note ending as it is struck again isn't cut short), then controller data
+// (so it applies to the notes that follow), then note ons.
+func Priority(m Message) int {
+	switch n := m.(type) {
+	case NoteOff:
+		return 0
+	case NoteOn:
+		if n.Velocity == 0 {
+			return 0
+		}
+		return 3
+	case ControlChange, ParameterChange, HighResControlChange,
+		AllSoundOff, ResetAllControllers, LocalControl, AllNotesOff, OmniMode, MonoMode, PolyMode:
+		return 1
+	}
+	return 2
+}
+
+// Returns "All Notes Off" control changes for every channel.
+func allNotesOff() []Message {
+	messages := make([]Message, 16)
+	for channel := range messages {
+		messages[channel] = ControlChange{channel, 123, 0, ControlChangeName(123)}
+	}
+	return messages
 }
 
-// General MIDI names for various ControlChange IDs.
+// Returns a copy of a message sent on a different channel.
+// Messages without a channel are returned unchanged.
+func withChannel(m Message, channel int) Message {
+	switch n := m.(type) {
+	case NoteOn:
+		n.Channel = channel
+		return n
+	case NoteOff:
+		n.Channel = channel
+		return n
+	case PolyAftertouch:
+		n.Channel = channel
+		return n
+	case ControlChange:
+		n.Channel = channel
+		return n
+	case ProgramChange:
+		n.Channel = channel
+		return n
+	case ChannelPressure:
+		n.Channel = channel
+		return n
+	case PitchBend:
+		n.Channel = channel
+		return n
+	case ParameterChange:
+		n.Channel = channel
+		return n
+	case PatchSelect:
+		n.Channel = channel
+		return n
+	case HighResControlChange:
+		n.Channel = channel
+		return n
+	case AllSoundOff:
+		n.Channel = channel
+		return n
+	case ResetAllControllers:
+		n.Channel = channel
+		return n
+	case LocalControl:
+		n.Channel = channel
+		return n
+	case AllNotesOff:
+		n.Channel = channel
+		return n
+	case OmniMode:
+		n.Channel = channel
+		return n
+	case MonoMode:
+		n.Channel = channel
+		return n
+	case PolyMode:
+		n.Channel = channel
+		return n
+	}
+	return m
+}
+
+func (c ControlChange) String() string {
+	name := c.Name
+	if name == "" {
+		name = controlChangeName(c.ID, nil)
+	}
+	return fmt.Sprintf("ControlChange{Channel:%d ID:%d Value:%d Name:%v}",
+		c.Channel, c.ID, c.Value, name)
+}
+
+type ProgramChange struct {
+	Channel int
+	Program int
+}
+
+func (p ProgramChange) Uint32() uint32 {
+	return message{p.Channel, PROGRAM_CHANGE, p.Program, 0, 0}.Uint32()
+}
+
+func (p ProgramChange) String() string {
+	name, ok := ProgramNames[p.Program]
+	if !ok {
+		name = "Unknown"
+	}
+	return fmt.Sprintf("ProgramChange{Channel:%d Program:%d Name:%v}", p.Channel, p.Program, name)
+}
+
+// Returns the channel a message is sent on, if it has one.
+func channelOf(m Message) (channel int, ok bool) {
+	switch n := m.(type) {
+	case NoteOn:
+		return n.Channel, true
+	case NoteOff:
+		return n.Channel, true
+	case PolyAftertouch:
+		return n.Channel, true
+	case ControlChange:
+		return n.Channel, true
+	case ProgramChange:
+		return n.Channel, true
+	case ChannelPressure:
+		return n.Channel, true
+	case PitchBend:
+		return n.Channel, true
+	case ParameterChange:
+		return n.Channel, true
+	case PatchSelect:
+		return n.Channel, true
+	case HighResControlChange:
+		return n.Channel, true
+	case AllSoundOff:
+		return n.Channel, true
+	case ResetAllControllers:
+		return n.Channel, true
+	case LocalControl:
+		return n.Channel, true
+	case AllNotesOff:
+		return n.Channel, true
+	case OmniMode:
+		return n.Channel, true
+	case MonoMode:
+		return n.Channel, true
+	case PolyMode:
+		return n.Channel, true
+	}
+	return 0, false
+}
+
+// A SysEx is a system exclusive message, of any length.
+type SysEx struct {
+	Data []byte // Includes the leading 0xF0 and trailing 0xF7.
+}
+
+// Returns the first four bytes of the message, packed as PortMidi does.
+func (s SysEx) Uint32() (u uint32) {
+	for i := 0; i < len(s.Data) && i < 4; i++ {
+		u |= uint32(s.Data[i]) << (8 * uint(i))
+	}
+	return u
+}
+
+// General MIDI names for various ControlChange IDs, which the package never
+// changes. Override them with SetControlChangeNames.
 var ControlChangeNames = map[int]string{
 	0:   "Bank Select",
 	1:   "Modulation Wheel or Lever",
@@ -215,3 +640,190 @@ var ControlChangeNames = map[int]string{
 	126: "[Channel Mode Message] Mono Mode On (+ poly off, + all notes off)",
 	127: "[Channel Mode Message] Poly Mode On (+ mono off, +all notes off)",
 }
+
+// General MIDI names for each ProgramChange program (numbered from 0, one
+// less than the program numbers the General MIDI spec lists.)
+var ProgramNames = map[int]string{
+	0:   "Acoustic Grand Piano",
+	1:   "Bright Acoustic Piano",
+	2:   "Electric Grand Piano",
+	3:   "Honky-tonk Piano",
+	4:   "Electric Piano 1",
+	5:   "Electric Piano 2",
+	6:   "Harpsichord",
+	7:   "Clavi",
+	8:   "Celesta",
+	9:   "Glockenspiel",
+	10:  "Music Box",
+	11:  "Vibraphone",
+	12:  "Marimba",
+	13:  "Xylophone",
+	14:  "Tubular Bells",
+	15:  "Dulcimer",
+	16:  "Drawbar Organ",
+	17:  "Percussive Organ",
+	18:  "Rock Organ",
+	19:  "Church Organ",
+	20:  "Reed Organ",
+	21:  "Accordion",
+	22:  "Harmonica",
+	23:  "Tango Accordion",
+	24:  "Acoustic Guitar (nylon)",
+	25:  "Acoustic Guitar (steel)",
+	26:  "Electric Guitar (jazz)",
+	27:  "Electric Guitar (clean)",
+	28:  "Electric Guitar (muted)",
+	29:  "Overdriven Guitar",
+	30:  "Distortion Guitar",
+	31:  "Guitar harmonics",
+	32:  "Acoustic Bass",
+	33:  "Electric Bass (finger)",
+	34:  "Electric Bass (pick)",
+	35:  "Fretless Bass",
+	36:  "Slap Bass 1",
+	37:  "Slap Bass 2",
+	38:  "Synth Bass 1",
+	39:  "Synth Bass 2",
+	40:  "Violin",
+	41:  "Viola",
+	42:  "Cello",
+	43:  "Contrabass",
+	44:  "Tremolo Strings",
+	45:  "Pizzicato Strings",
+	46:  "Orchestral Harp",
+	47:  "Timpani",
+	48:  "String Ensemble 1",
+	49:  "String Ensemble 2",
+	50:  "SynthStrings 1",
+	51:  "SynthStrings 2",
+	52:  "Choir Aahs",
+	53:  "Voice Oohs",
+	54:  "Synth Voice",
+	55:  "Orchestra Hit",
+	56:  "Trumpet",
+	57:  "Trombone",
+	58:  "Tuba",
+	59:  "Muted Trumpet",
+	60:  "French Horn",
+	61:  "Brass Section",
+	62:  "SynthBrass 1",
+	63:  "SynthBrass 2",
+	64:  "Soprano Sax",
+	65:  "Alto Sax",
+	66:  "Tenor Sax",
+	67:  "Baritone Sax",
+	68:  "Oboe",
+	69:  "English Horn",
+	70:  "Bassoon",
+	71:  "Clarinet",
+	72:  "Piccolo",
+	73:  "Flute",
+	74:  "Recorder",
+	75:  "Pan Flute",
+	76:  "Blown Bottle",
+	77:  "Shakuhachi",
+	78:  "Whistle",
+	79:  "Ocarina",
+	80:  "Lead 1 (square)",
+	81:  "Lead 2 (sawtooth)",
+	82:  "Lead 3 (calliope)",
+	83:  "Lead 4 (chiff)",
+	84:  "Lead 5 (charang)",
+	85:  "Lead 6 (voice)",
+	86:  "Lead 7 (fifths)",
+	87:  "Lead 8 (bass + lead)",
+	88:  "Pad 1 (new age)",
+	89:  "Pad 2 (warm)",
+	90:  "Pad 3 (polysynth)",
+	91:  "Pad 4 (choir)",
+	92:  "Pad 5 (bowed)",
+	93:  "Pad 6 (metallic)",
+	94:  "Pad 7 (halo)",
+	95:  "Pad 8 (sweep)",
+	96:  "FX 1 (rain)",
+	97:  "FX 2 (soundtrack)",
+	98:  "FX 3 (crystal)",
+	99:  "FX 4 (atmosphere)",
+	100: "FX 5 (brightness)",
+	101: "FX 6 (goblins)",
+	102: "FX 7 (echoes)",
+	103: "FX 8 (sci-fi)",
+	104: "Sitar",
+	105: "Banjo",
+	106: "Shamisen",
+	107: "Koto",
+	108: "Kalimba",
+	109: "Bag pipe",
+	110: "Fiddle",
+	111: "Shanai",
+	112: "Tinkle Bell",
+	113: "Agogo",
+	114: "Steel Drums",
+	115: "Woodblock",
+	116: "Taiko Drum",
+	117: "Melodic Tom",
+	118: "Synth Drum",
+	119: "Reverse Cymbal",
+	120: "Guitar Fret Noise",
+	121: "Breath Noise",
+	122: "Seashore",
+	123: "Bird Tweet",
+	124: "Telephone Ring",
+	125: "Helicopter",
+	126: "Applause",
+	127: "Gunshot",
+}
+
+// The channel that General MIDI plays percussion on (channel 10, numbered from 0.)
+const PercussionChannel = 9
+
+// General MIDI names for the percussion played by each key on the PercussionChannel.
+var PercussionNames = map[int]string{
+	35: "Acoustic Bass Drum",
+	36: "Bass Drum 1",
+	37: "Side Stick",
+	38: "Acoustic Snare",
+	39: "Hand Clap",
+	40: "Electric Snare",
+	41: "Low Floor Tom",
+	42: "Closed Hi Hat",
+	43: "High Floor Tom",
+	44: "Pedal Hi-Hat",
+	45: "Low Tom",
+	46: "Open Hi-Hat",
+	47: "Low-Mid Tom",
+	48: "Hi-Mid Tom",
+	49: "Crash Cymbal 1",
+	50: "High Tom",
+	51: "Ride Cymbal 1",
+	52: "Chinese Cymbal",
+	53: "Ride Bell",
+	54: "Tambourine",
+	55: "Splash Cymbal",
+	56: "Cowbell",
+	57: "Crash Cymbal 2",
+	58: "Vibraslap",
+	59: "Ride Cymbal 2",
+	60: "Hi Bongo",
+	61: "Low Bongo",
+	62: "Mute Hi Conga",
+	63: "Open Hi Conga",
+	64: "Low Conga",
+	65: "High Timbale",
+	66: "Low Timbale",
+	67: "High Agogo",
+	68: "Low Agogo",
+	69: "Cabasa",
+	70: "Maracas",
+	71: "Short Whistle",
+	72: "Long Whistle",
+	73: "Short Guiro",
+	74: "Long Guiro",
+	75: "Claves",
+	76: "Hi Wood Block",
+	77: "Low Wood Block",
+	78: "Mute Cuica",
+	79: "Open Cuica",
+	80: "Mute Triangle",
+	81: "Open Triangle",
+}
@@ -8,7 +8,13 @@ These tests require IAC buses to be created on an OS X system, named:
 */
 
 import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func testSystemDevice(t *testing.T) {
@@ -20,6 +26,122 @@ func testSystemDevice(t *testing.T) {
 	devices.Shutdown()
 }
 
+func TestRawMessage(t *testing.T) {
+	raw := uint32(0x7F3C4091)
+	m := newMessage(raw)
+	if m.Command != NOTE_ON || m.Channel != 1 || m.Data1 != 0x40 || m.Data2 != 0x3C {
+		t.Errorf("Decoded 0x%08X as %+v", raw, m)
+	}
+	if m.Raw != raw || m.Uint32() != raw {
+		t.Errorf("Received 0x%08X (raw 0x%08X) from %+v instead of 0x%08X",
+			m.Uint32(), m.Raw, m, raw)
+	}
+}
+
+func TestRawMessageTyped(t *testing.T) {
+	raw := uint32(0x7F3C4091)
+	expected := RawMessage{NoteOn{1, 0x40, 0x3C}, raw}
+	if m := newMessage(raw).typed(nil); m != expected {
+		t.Errorf("Read %v from 0x%08X instead of %v", m, raw, expected)
+	} else if m.Uint32() != raw {
+		t.Errorf("Received 0x%08X from %v instead of 0x%08X", m.Uint32(), m, raw)
+	}
+	standard := NoteOn{1, 0x40, 0x3C}
+	if m := newMessage(standard.Uint32()).typed(nil); m != standard {
+		t.Errorf("Read %v instead of %v", m, standard)
+	}
+}
+
+func TestSongSelect(t *testing.T) {
+	b := MessageBytes(SongSelect{5})
+	if !bytes.Equal(b, []byte{0xF3, 0x05}) {
+		t.Errorf("Received % X from song select 5 instead of F3 05", b)
+	}
+	if m, err := ParseMessage(b); err != nil || m != (SongSelect{5}) {
+		t.Errorf("Received %v (%v) from parsing % X instead of %v", m, err, b, SongSelect{5})
+	}
+}
+
+func TestPitchBend(t *testing.T) {
+	tests := []struct {
+		m        PitchBend
+		expected []byte
+	}{
+		{PitchBend{0, 0}, []byte{0xE0, 0x00, 0x00}},
+		{PitchBend{1, PitchBendCenter}, []byte{0xE1, 0x00, 0x40}},
+		{PitchBend{2, 16383}, []byte{0xE2, 0x7F, 0x7F}},
+	}
+	for _, test := range tests {
+		b := MessageBytes(test.m)
+		if !bytes.Equal(b, test.expected) {
+			t.Errorf("Received % X from %v instead of % X", b, test.m, test.expected)
+		}
+		if m := newMessage(test.m.Uint32()).typed(nil); m != test.m {
+			t.Errorf("Read %v instead of %v", m, test.m)
+		}
+	}
+}
+
+func TestNoteOffVelocity(t *testing.T) {
+	for _, b := range [][]byte{{0x81, 60, 64}, {0x81, 60, 0}} {
+		expected := NoteOff{1, 60, int(b[2])}
+		if m := newMessage(expected.Uint32()).typed(nil); m != expected {
+			t.Errorf("Read %v instead of %v", m, expected)
+		}
+		if m, err := ParseMessage(b); err != nil || m != expected {
+			t.Errorf("Parsed % X as %v (%v) instead of %v", b, m, err, expected)
+		}
+	}
+}
+
+// The bytes dumped, written and parsed for each message must agree.
+func TestMessageLengths(t *testing.T) {
+	tests := []struct {
+		m      Message
+		length int
+	}{
+		{NoteOn{1, 60, 100}, 3},
+		{NoteOff{1, 60, 64}, 3},
+		{PolyAftertouch{1, 60, 80}, 3},
+		{ControlChange{2, 7, 100, ControlChangeNames[7]}, 3},
+		{ProgramChange{3, 42}, 2},
+		{ChannelPressure{4, 90}, 2},
+		{PitchBend{5, 12000}, 3},
+		{SysEx{[]byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}}, 6},
+		{Clock{}, 1},
+		{Start{}, 1},
+		{Continue{}, 1},
+		{Stop{}, 1},
+		{SongPosition{1000}, 3},
+		{SongSelect{5}, 2},
+		{QuarterFrame{7, 3}, 2},
+	}
+	for _, test := range tests {
+		if n := len(strings.Fields(HexDump(test.m))); n != test.length {
+			t.Errorf("Dumped %v as %q instead of %d bytes", test.m, HexDump(test.m), test.length)
+		}
+		// PortMidi writes as many bytes of a short message as its status calls for.
+		written := MessageLength(int(test.m.Uint32() & 0xFF))
+		if _, ok := test.m.(SysEx); ok {
+			written = len(test.m.(SysEx).Data)
+		}
+		if written != test.length {
+			t.Errorf("Wrote %d bytes of %v instead of %d", written, test.m, test.length)
+		}
+		b := MessageBytes(test.m)
+		if m, err := ParseMessage(b); err != nil || !reflect.DeepEqual(m, test.m) {
+			t.Errorf("Parsed % X as %v (%v) instead of %v", b, m, err, test.m)
+		}
+		var p StreamParser
+		if m := p.Parse(b[:len(b)-1]); len(m) != 0 {
+			t.Errorf("Parsed % X as %v before the last byte of %v", b, m, test.m)
+		}
+		if m := p.Parse(b[len(b)-1:]); len(m) != 1 || !reflect.DeepEqual(m[0], test.m) {
+			t.Errorf("Parsed % X as %v instead of %v", b, m, test.m)
+		}
+	}
+}
+
 func TestPipe(t *testing.T) {
 	src := NewDevice()
 	dst := NewDevice()
@@ -38,6 +160,210 @@ func TestPipe(t *testing.T) {
 	pipe.Close()
 }
 
+func TestPipeTransport(t *testing.T) {
+	pipe := NewPipe(NewDevice(), NewDevice())
+	pipe.Open()
+	go pipe.Connect()
+	defer pipe.Close()
+	for _, expected := range []Message{SongSelect{3}, SongPosition{16383}, Continue{}} {
+		pipe.From.Out <- expected
+		if actual := <-pipe.To.In; actual != expected {
+			t.Errorf("Received %v from pipe instead of %v", actual, expected)
+		}
+	}
+}
+
+func TestPipeResume(t *testing.T) {
+	pipe := NewPipe(NewDevice(), NewDevice())
+	pipe.Open()
+	go pipe.Connect()
+	defer pipe.Close()
+
+	stale := NoteOn{0, 64, 127}
+	pipe.Pause()
+	pipe.From.Out <- stale
+	pipe.Resume(false)
+	if actual := <-pipe.To.In; actual != stale {
+		t.Errorf("Received %v from pipe after resuming instead of %v", actual, stale)
+	}
+
+	pipe.Pause()
+	pipe.From.Out <- stale
+	pipe.From.Out <- ControlChange{0, 1, 64, ""}
+	pipe.Resume(true)
+	for channel := 0; channel < 16; channel++ {
+		actual, ok := (<-pipe.To.In).(ControlChange)
+		if !ok || actual.Channel != channel || actual.ID != 123 {
+			t.Errorf("Received %v from pipe instead of All Notes Off on channel %d",
+				actual, channel)
+		}
+	}
+	expected := NoteOn{0, 60, 100}
+	pipe.From.Out <- expected
+	if actual := <-pipe.To.In; actual != expected {
+		t.Errorf("Received %v from pipe instead of %v", actual, expected)
+	}
+}
+
+func TestPipeClosedDestination(t *testing.T) {
+	pipe := NewPipe(NewDevice(), NewDevice())
+	pipe.Open()
+	disconnected := make(chan bool)
+	go func() {
+		pipe.Connect()
+		disconnected <- true
+	}()
+	pipe.From.Out <- NoteOn{0, 64, 127}
+	// The pipe is now blocked sending to the destination, which is closed under it.
+	if err := pipe.To.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Errorf("Pipe did not disconnect after its destination was closed")
+	}
+}
+
+func TestConnectorsClosedDestination(t *testing.T) {
+	connectors := map[string]func(from, to *Device) interface{ Connect() }{
+		"Filter": func(from, to *Device) interface{ Connect() } {
+			return NewFilter(from, to, func(m Message) []Message { return []Message{m} })
+		},
+		"Looper":        func(from, to *Device) interface{ Connect() } { return NewLooper(from, to) },
+		"Recorder":      func(from, to *Device) interface{ Connect() } { return NewRecorder(from, to) },
+		"ChordMemory":   func(from, to *Device) interface{ Connect() } { return NewChordMemory(from, to) },
+		"MidiLearn":     func(from, to *Device) interface{ Connect() } { return NewMidiLearn(from, to) },
+		"StepSequencer": func(from, to *Device) interface{ Connect() } { return NewStepSequencer(from, to, nil) },
+		"EnvelopeFollower": func(from, to *Device) interface{ Connect() } {
+			return NewEnvelopeFollower(from, to, 0, 1, time.Millisecond, time.Millisecond)
+		},
+		"VelocitySplit": func(from, to *Device) interface{ Connect() } { return NewVelocitySplit(from, nil, to) },
+		"Crossfade":     func(from, to *Device) interface{ Connect() } { return NewCrossfade(from, to, to, 1) },
+	}
+	for name, connector := range connectors {
+		from, to := NewDevice(), NewDevice()
+		c := connector(from, to)
+		from.Open()
+		to.Open()
+		disconnected := make(chan bool)
+		go func() {
+			c.Connect()
+			close(disconnected)
+		}()
+		from.Out <- NoteOn{0, 64, 127}
+		// The connector is now blocked sending to the destination, which is closed under it.
+		if err := to.Close(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second):
+			t.Errorf("%v did not disconnect after its destination was closed", name)
+		}
+	}
+}
+
+// Measures Pipe throughput when MIDI data queues up on buffered wires.
+func BenchmarkPipe(b *testing.B) {
+	from, to := NewDevice(), NewDevice()
+	from.Out = make(chan Message, 64)
+	to.In = make(chan Message, 64)
+	pipe := NewPipe(from, to)
+	pipe.Open()
+	go pipe.Connect()
+	defer pipe.Close()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			pipe.From.Out <- NoteOn{0, i % 128, 100}
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		<-pipe.To.In
+	}
+}
+
+func TestConnectorNames(t *testing.T) {
+	var logs bytes.Buffer
+	Debug.SetOutput(&logs)
+	defer Debug.SetOutput(ioutil.Discard)
+	pipe := NewPipe(NewDevice(), NewDevice())
+	pipe.Name = "drums"
+	pipe.Open()
+	go pipe.Connect()
+	pipe.From.Out <- NoteOn{0, 36, 127}
+	<-pipe.To.In
+	pipe.Close()
+	chain := NewChain(NewDevice(), NewDevice())
+	chain.Close()
+	for _, expected := range []string{
+		`Pipe "drums" connected`, `Pipe "drums" closed`, "Chain closed"} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Errorf("Logs did not contain %q: %v", expected, logs.String())
+		}
+	}
+}
+
+func TestChainClose(t *testing.T) {
+	var logs bytes.Buffer
+	Debug.SetOutput(&logs)
+	defer Debug.SetOutput(ioutil.Discard)
+	devices := []*MemDevice{NewMemDevice(), NewMemDevice(), NewMemDevice()}
+	defer func() {
+		for _, d := range devices {
+			d.Close()
+		}
+	}()
+	goroutines := runtime.NumGoroutine()
+	chain := NewChain(devices[0].Device, devices[1].Device, devices[2].Device)
+	chain.Open()
+	chain.Connect()
+	devices[0].Send(NoteOn{0, 60, 100})
+	if err := chain.Close(); err != nil {
+		t.Errorf("Received %v from closing a chain", err)
+	}
+	stopped := logs.String()
+	if last, first := strings.Index(stopped, "stopped pipe 1"), strings.Index(stopped, "stopped pipe 0"); last < 0 || first < last {
+		t.Errorf("Pipes were not stopped from the last to the first: %v", stopped)
+	}
+	for i, d := range devices[1:] {
+		var notesOff int
+		for deadline := time.Now().Add(time.Second); notesOff < 16 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+			notesOff = 0
+			for _, m := range d.Received() {
+				if c, ok := m.(ControlChange); ok && c.ID == 123 {
+					notesOff++
+				}
+			}
+		}
+		if notesOff != 16 {
+			t.Errorf("Device %d received All Notes Off on %d channels instead of 16", i+1, notesOff)
+		}
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines were left running after closing the chain", runtime.NumGoroutine()-goroutines)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestChainCloseUnread(t *testing.T) {
+	from := NewMemDevice()
+	defer from.Close()
+	chain := NewChain(from.Device, NewDevice()) // Nothing reads the last device's In wire.
+	chain.Open()
+	chain.Connect()
+	closed := make(chan error, 1)
+	go func() { closed <- chain.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Closing a chain waited for its last device to read All Notes Off")
+	}
+}
+
 /*
 
 TODO(aoeu): Reimplement all tests and examples.
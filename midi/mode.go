@@ -0,0 +1,105 @@
+package midi
+
+/*
+Channel mode messages are control changes 120 to 127, which set how a
+device responds on a channel rather than a control's value. They are read
+as ControlChanges; the ChannelModeMessages filter sends them as the types
+here instead.
+*/
+
+// Returns the control change of a channel mode message.
+func modeMessage(channel, id, value int) ControlChange {
+	return ControlChange{channel, id, value, ControlChangeName(id)}
+}
+
+func onOff(on bool) int {
+	if on {
+		return 127
+	}
+	return 0
+}
+
+// AllSoundOff silences a channel immediately, including the release of notes.
+type AllSoundOff struct {
+	Channel int
+}
+
+func (a AllSoundOff) Uint32() uint32 { return modeMessage(a.Channel, 120, 0).Uint32() }
+
+// ResetAllControllers resets a channel's controllers to their defaults.
+type ResetAllControllers struct {
+	Channel int
+}
+
+func (r ResetAllControllers) Uint32() uint32 { return modeMessage(r.Channel, 121, 0).Uint32() }
+
+// LocalControl connects (or disconnects) a device's keyboard to its own sound.
+type LocalControl struct {
+	Channel int
+	On      bool
+}
+
+func (l LocalControl) Uint32() uint32 { return modeMessage(l.Channel, 122, onOff(l.On)).Uint32() }
+
+// AllNotesOff ends the notes sounding on a channel.
+type AllNotesOff struct {
+	Channel int
+}
+
+func (a AllNotesOff) Uint32() uint32 { return modeMessage(a.Channel, 123, 0).Uint32() }
+
+// OmniMode sets whether a device responds on all channels, also ending all notes.
+type OmniMode struct {
+	Channel int
+	On      bool
+}
+
+func (o OmniMode) Uint32() uint32 {
+	if o.On {
+		return modeMessage(o.Channel, 125, 0).Uint32()
+	}
+	return modeMessage(o.Channel, 124, 0).Uint32()
+}
+
+// MonoMode sets a device to play one note at a time on each channel, also
+// ending all notes.
+type MonoMode struct {
+	Channel  int
+	Channels int // How many channels, from Channel up, or 0 for as many as the device has voices.
+}
+
+func (m MonoMode) Uint32() uint32 { return modeMessage(m.Channel, 126, m.Channels).Uint32() }
+
+// PolyMode sets a device to play many notes at a time, also ending all notes.
+type PolyMode struct {
+	Channel int
+}
+
+func (p PolyMode) Uint32() uint32 { return modeMessage(p.Channel, 127, 0).Uint32() }
+
+// Sends channel mode control changes (120 to 127) as the channel mode message types.
+func ChannelModeMessages() FilterFunc {
+	return func(m Message) []Message {
+		n, ok := m.(ControlChange)
+		if !ok {
+			return []Message{m}
+		}
+		switch n.ID {
+		case 120:
+			return []Message{AllSoundOff{n.Channel}}
+		case 121:
+			return []Message{ResetAllControllers{n.Channel}}
+		case 122:
+			return []Message{LocalControl{n.Channel, n.Value >= 64}}
+		case 123:
+			return []Message{AllNotesOff{n.Channel}}
+		case 124, 125:
+			return []Message{OmniMode{n.Channel, n.ID == 125}}
+		case 126:
+			return []Message{MonoMode{n.Channel, n.Value}}
+		case 127:
+			return []Message{PolyMode{n.Channel}}
+		}
+		return []Message{m}
+	}
+}
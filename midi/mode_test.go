@@ -0,0 +1,54 @@
+package midi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestChannelModeMessages(t *testing.T) {
+	f := ChannelModeMessages()
+	tests := []struct {
+		m     Message
+		bytes []byte
+	}{
+		{AllSoundOff{1}, []byte{0xB1, 120, 0}},
+		{ResetAllControllers{2}, []byte{0xB2, 121, 0}},
+		{LocalControl{3, false}, []byte{0xB3, 122, 0}},
+		{LocalControl{3, true}, []byte{0xB3, 122, 127}},
+		{AllNotesOff{4}, []byte{0xB4, 123, 0}},
+		{OmniMode{5, false}, []byte{0xB5, 124, 0}},
+		{OmniMode{5, true}, []byte{0xB5, 125, 0}},
+		{MonoMode{6, 4}, []byte{0xB6, 126, 4}},
+		{PolyMode{7}, []byte{0xB7, 127, 0}},
+	}
+	for _, test := range tests {
+		b := MessageBytes(test.m)
+		if !bytes.Equal(b, test.bytes) {
+			t.Errorf("Received % X from %v instead of % X", b, test.m, test.bytes)
+		}
+		cc, _ := ParseMessage(b)
+		if actual := f(cc); !reflect.DeepEqual(actual, []Message{test.m}) {
+			t.Errorf("Received %v from %v instead of %v", actual, cc, test.m)
+		}
+	}
+	other := ControlChange{0, 7, 100, ""}
+	if actual := f(other); !reflect.DeepEqual(actual, []Message{other}) {
+		t.Errorf("Received %v from %v", actual, other)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	d := NewMemDevice()
+	defer d.Close()
+	d.Panic()
+	received := waitForReceived(d, 32)
+	if len(received) != 32 {
+		t.Fatalf("Received %d messages from a panic instead of 32", len(received))
+	}
+	for channel := 0; channel < 16; channel++ {
+		if received[2*channel] != (AllSoundOff{channel}) || received[2*channel+1] != (AllNotesOff{channel}) {
+			t.Errorf("Received %v on channel %d from a panic", received[2*channel:2*channel+2], channel)
+		}
+	}
+}
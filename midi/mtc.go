@@ -0,0 +1,95 @@
+package midi
+
+/*
+MIDI Time Code (MTC) sends SMPTE timecode as eight quarter frame messages,
+each carrying a nibble of the time, two frames' worth of quarter frames per
+timecode. The pieces are, in the order they are sent while playing forward:
+    0: frames, low nibble      1: frames, high nibble
+    2: seconds, low nibble     3: seconds, high nibble
+    4: minutes, low nibble     5: minutes, high nibble
+    6: hours, low nibble       7: hours, high bit and the frame rate
+*/
+
+import "fmt"
+
+const QUARTER_FRAME int = 0xF1
+
+// QuarterFrame is one of the eight pieces of an MTC timecode.
+type QuarterFrame struct {
+	Piece int // From 0 to 7.
+	Value int // 4 bits.
+}
+
+func (q QuarterFrame) Uint32() uint32 {
+	return message{0, QUARTER_FRAME, (q.Piece&0x07)<<4 | q.Value&0x0F, 0, 0}.Uint32()
+}
+
+// The frame rates of MTC timecode.
+type FrameRate int
+
+const (
+	FPS24     FrameRate = iota // 24 frames per second, for film.
+	FPS25                      // 25 frames per second, for PAL video.
+	FPS30Drop                  // 29.97 frames per second, drop frame, for NTSC video.
+	FPS30                      // 30 frames per second.
+)
+
+// A Timecode is an SMPTE time, as sent by MTC.
+type Timecode struct {
+	Hours   int
+	Minutes int
+	Seconds int
+	Frames  int
+	Rate    FrameRate
+}
+
+func (t Timecode) String() string {
+	return fmt.Sprintf("%02d:%02d:%02d:%02d", t.Hours, t.Minutes, t.Seconds, t.Frames)
+}
+
+// Returns the quarter frames that send the timecode, in order.
+func (t Timecode) QuarterFrames() []QuarterFrame {
+	values := [8]int{
+		t.Frames & 0x0F, t.Frames >> 4 & 0x01,
+		t.Seconds & 0x0F, t.Seconds >> 4 & 0x03,
+		t.Minutes & 0x0F, t.Minutes >> 4 & 0x03,
+		t.Hours & 0x0F, t.Hours>>4&0x01 | int(t.Rate)<<1,
+	}
+	frames := make([]QuarterFrame, 8)
+	for piece, value := range values {
+		frames[piece] = QuarterFrame{piece, value}
+	}
+	return frames
+}
+
+// A TimecodeReader assembles the timecode sent by quarter frames.
+type TimecodeReader struct {
+	values   [8]int
+	received int // A bit for each piece received since the last timecode.
+}
+
+// Adds a quarter frame, returning the timecode once all eight pieces of one
+// have been received. Only a timecode whose pieces were each received since the
+// last is returned, so timecodes are not mixed up when MTC jumps or reverses.
+func (r *TimecodeReader) Add(q QuarterFrame) (t Timecode, ok bool) {
+	if q.Piece < 0 || q.Piece > 7 {
+		return t, false
+	}
+	if q.Piece == 0 {
+		r.received = 0
+	}
+	r.values[q.Piece] = q.Value & 0x0F
+	r.received |= 1 << uint(q.Piece)
+	if q.Piece != 7 || r.received != 0xFF {
+		return t, false
+	}
+	r.received = 0
+	v := r.values
+	return Timecode{
+		Hours:   v[6] | (v[7]&0x01)<<4,
+		Minutes: v[4] | (v[5]&0x03)<<4,
+		Seconds: v[2] | (v[3]&0x03)<<4,
+		Frames:  v[0] | (v[1]&0x01)<<4,
+		Rate:    FrameRate(v[7] >> 1 & 0x03),
+	}, true
+}
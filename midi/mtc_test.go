@@ -0,0 +1,26 @@
+package midi
+
+import "testing"
+
+func TestTimecodeReader(t *testing.T) {
+	expected := Timecode{23, 59, 58, 29, FPS30Drop}
+	var r TimecodeReader
+	// Start partway through a timecode, which can't be assembled.
+	frames := append(Timecode{1, 2, 3, 4, FPS25}.QuarterFrames()[3:], expected.QuarterFrames()...)
+	var received []Timecode
+	for _, q := range frames {
+		m, _ := ParseMessage(MessageBytes(q))
+		if m != q {
+			t.Errorf("Parsed %v instead of %v", m, q)
+		}
+		if tc, ok := r.Add(q); ok {
+			received = append(received, tc)
+		}
+	}
+	if len(received) != 1 || received[0] != expected {
+		t.Errorf("Assembled %v instead of %v", received, expected)
+	}
+	if s := expected.String(); s != "23:59:58:29" {
+		t.Errorf("Received %q from %v", s, expected)
+	}
+}
@@ -0,0 +1,124 @@
+package midi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Loads control change names from a JSON file mapping IDs to names, e.g.:
+//
+//	{"74": "Filter Cutoff", "71": "Filter Resonance"}
+func LoadControlChangeNames(fileName string) (map[int]string, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read control change names: %w", err)
+	}
+	names := make(map[int]string)
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("Could not parse control change names in %v: %w", fileName, err)
+	}
+	return names, nil
+}
+
+// Names set by SetControlChangeNames in place of those in ControlChangeNames.
+// The map is replaced rather than changed, so it may be read once taken under the lock.
+var (
+	controlChangeNamesMu       sync.RWMutex
+	controlChangeNameOverrides map[int]string
+)
+
+// Overrides the General MIDI names in ControlChangeNames, for all devices,
+// leaving ControlChangeNames itself unchanged. It is safe to call while ports
+// are connected.
+func SetControlChangeNames(names map[int]string) {
+	controlChangeNamesMu.Lock()
+	defer controlChangeNamesMu.Unlock()
+	overrides := make(map[int]string, len(controlChangeNameOverrides)+len(names))
+	for id, name := range controlChangeNameOverrides {
+		overrides[id] = name
+	}
+	for id, name := range names {
+		overrides[id] = name
+	}
+	controlChangeNameOverrides = overrides
+}
+
+// Restores the General MIDI names in ControlChangeNames, undoing SetControlChangeNames.
+func ResetControlChangeNames() {
+	controlChangeNamesMu.Lock()
+	defer controlChangeNamesMu.Unlock()
+	controlChangeNameOverrides = nil
+}
+
+// Returns the name of a control change, preferring any names given over ControlChangeName.
+func controlChangeName(id int, names map[int]string) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	if name := ControlChangeName(id); name != "" {
+		return name
+	}
+	return "Unknown"
+}
+
+// Returns the name of a control change, as set by SetControlChangeNames or
+// otherwise in ControlChangeNames, or "" if it has none.
+func ControlChangeName(id int) string {
+	controlChangeNamesMu.RLock()
+	overrides := controlChangeNameOverrides
+	controlChangeNamesMu.RUnlock()
+	if name, ok := overrides[id]; ok {
+		return name
+	}
+	return ControlChangeNames[id]
+}
+
+// The octave of middle C (key 60) in note names. Scientific pitch notation,
+// the default, names it C4; some manufacturers (e.g. Yamaha) name it C3.
+var MiddleCOctave = 4
+
+var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// Returns the name of a key (0 to 127) in scientific pitch notation, e.g. "C#4" for 61,
+// with sharps rather than flats and the octave of middle C set by MiddleCOctave.
+// Keys outside that range have no name, so "" is returned.
+func NoteName(key int) string {
+	if key < 0 || key > 127 {
+		return ""
+	}
+	return fmt.Sprintf("%v%d", noteNames[key%12], key/12+MiddleCOctave-5)
+}
+
+// Returns the key of a note name in scientific pitch notation, e.g. 61 for
+// "C#4" or "Db4", with the octave of middle C set by MiddleCOctave.
+func NoteNumber(name string) (int, error) {
+	if name == "" {
+		return 0, fmt.Errorf("Empty note name")
+	}
+	key := strings.Index("C D EF G A B", strings.ToUpper(name[:1]))
+	if key < 0 || name[0] == ' ' {
+		return 0, fmt.Errorf("Invalid note name %q", name)
+	}
+	rest := name[1:]
+	for len(rest) > 0 && (rest[0] == '#' || rest[0] == 'b') {
+		if rest[0] == '#' {
+			key++
+		} else {
+			key--
+		}
+		rest = rest[1:]
+	}
+	octave, err := strconv.Atoi(rest)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid octave in note name %q", name)
+	}
+	key += (octave - MiddleCOctave + 5) * 12
+	if key < 0 || key > 127 {
+		return 0, fmt.Errorf("Note %q is out of range", name)
+	}
+	return key, nil
+}
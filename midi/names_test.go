@@ -0,0 +1,130 @@
+package midi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadControlChangeNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "midi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "names.json")
+	if err := ioutil.WriteFile(fileName, []byte(`{"74": "Filter Cutoff"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	names, err := LoadControlChangeNames(fileName)
+	if err != nil {
+		t.Fatalf("Could not load control change names: %v", err)
+	}
+	if actual := controlChangeName(74, names); actual != "Filter Cutoff" {
+		t.Errorf("Received %q as the name for control change 74 instead of %q",
+			actual, "Filter Cutoff")
+	}
+	if actual := controlChangeName(7, names); actual != ControlChangeNames[7] {
+		t.Errorf("Received %q as the name for control change 7 instead of %q",
+			actual, ControlChangeNames[7])
+	}
+
+	defaultName := ControlChangeNames[74]
+	defer ResetControlChangeNames()
+	SetControlChangeNames(names)
+	cc := ControlChange{Channel: 0, ID: 74, Value: 100}
+	if !strings.Contains(cc.String(), "Filter Cutoff") {
+		t.Errorf("Received %v instead of a control change named %q", cc, "Filter Cutoff")
+	}
+	if ControlChangeNames[74] != defaultName {
+		t.Errorf("Changed the General MIDI name of control change 74 to %q", ControlChangeNames[74])
+	}
+	ResetControlChangeNames()
+	if actual := ControlChangeName(74); actual != defaultName {
+		t.Errorf("Received %q as the name for control change 74 after resetting instead of %q",
+			actual, defaultName)
+	}
+}
+
+func TestSetControlChangeNamesConcurrently(t *testing.T) {
+	defer ResetControlChangeNames()
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = ControlChange{Channel: 0, ID: 74, Value: i}.String()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		SetControlChangeNames(map[int]string{74: fmt.Sprintf("Cutoff %d", i)})
+	}
+	<-done
+}
+
+func TestGeneralMIDINames(t *testing.T) {
+	tests := []struct {
+		m        Message
+		expected string
+	}{
+		{ProgramChange{0, 0}, "ProgramChange{Channel:0 Program:0 Name:Acoustic Grand Piano}"},
+		{ProgramChange{1, 127}, "ProgramChange{Channel:1 Program:127 Name:Gunshot}"},
+		{NoteOn{PercussionChannel, 38, 100}, "NoteOn{Channel:9 Key:38 Velocity:100 Name:Acoustic Snare}"},
+		{NoteOff{PercussionChannel, 81, 0}, "NoteOff{Channel:9 Key:81 Velocity:0 Name:Open Triangle}"},
+		{NoteOn{0, 61, 100}, "NoteOn{Channel:0 Key:61 Velocity:100 Name:C#4}"},
+		{NoteOn{PercussionChannel, 20, 100}, "NoteOn{Channel:9 Key:20 Velocity:100}"},
+	}
+	for _, test := range tests {
+		if actual := fmt.Sprint(test.m); actual != test.expected {
+			t.Errorf("Received %q instead of %q", actual, test.expected)
+		}
+	}
+	if len(ProgramNames) != 128 {
+		t.Errorf("Named %d programs instead of 128", len(ProgramNames))
+	}
+}
+
+func TestNoteNames(t *testing.T) {
+	defer func(octave int) { MiddleCOctave = octave }(MiddleCOctave)
+	tests := []struct {
+		middleC int
+		key     int
+		name    string
+	}{
+		{4, 60, "C4"},
+		{4, 61, "C#4"},
+		{4, 69, "A4"},
+		{4, 0, "C-1"},
+		{4, 127, "G9"},
+		{3, 60, "C3"},
+		{3, 0, "C-2"},
+	}
+	for _, test := range tests {
+		MiddleCOctave = test.middleC
+		if name := NoteName(test.key); name != test.name {
+			t.Errorf("Named key %d %q instead of %q with middle C as C%d", test.key, name, test.name, test.middleC)
+		}
+		if key, err := NoteNumber(test.name); err != nil || key != test.key {
+			t.Errorf("Received key %d (%v) for %q instead of %d with middle C as C%d", key, err, test.name, test.key, test.middleC)
+		}
+	}
+	MiddleCOctave = 4
+	if key, err := NoteNumber("Db4"); err != nil || key != 61 {
+		t.Errorf("Received key %d (%v) for %q instead of 61", key, err, "Db4")
+	}
+	for _, name := range []string{"", "H4", "C", "C#x", "G#9", "Cb-1", " 4"} {
+		if key, err := NoteNumber(name); err == nil {
+			t.Errorf("Received key %d for %q instead of an error", key, name)
+		}
+	}
+	for _, key := range []int{-1, -13, 128} {
+		if name := NoteName(key); name != "" {
+			t.Errorf("Named key %d %q instead of leaving it unnamed", key, name)
+		}
+	}
+	if s := (NoteOn{0, -1, 100}).String(); s != "NoteOn{Channel:0 Key:-1 Velocity:100}" {
+		t.Errorf("Described a note with an invalid key as %q", s)
+	}
+}
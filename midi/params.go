@@ -0,0 +1,141 @@
+package midi
+
+/*
+Some controls send more than the 7 bits of a control change, as several:
+High resolution (14-bit) controls 0 to 31 send their MSB as the control
+change with their ID and their LSB as the one with their ID plus 32.
+Registered and non-registered parameter numbers (RPNs and NRPNs) address
+more parameters than there are control changes, with a sequence of them:
+    99 and 98 (NRPN) or 101 and 100 (RPN): the parameter's MSB and LSB.
+    6 and 38: the value's MSB and LSB (Data Entry.)
+The parameter stays selected for further data entry until another is.
+*/
+
+// A HighResControlChange sets a control (0 to 31) to a 14-bit value, as the
+// control changes returned by Messages.
+type HighResControlChange struct {
+	Channel int
+	ID      int // From 0 to 31.
+	Value   int // 14 bits.
+}
+
+// Returns the first of the control changes, the MSB. MessageBytes and system ports send both.
+func (h HighResControlChange) Uint32() uint32 {
+	return h.Messages()[0].Uint32()
+}
+
+// Returns the control changes of the MSB and the LSB, in order.
+func (h HighResControlChange) Messages() []Message {
+	return []Message{
+		ControlChange{h.Channel, h.ID, h.Value >> 7 & 0x7F, ControlChangeName(h.ID)},
+		ControlChange{h.Channel, h.ID + 32, h.Value & 0x7F, ControlChangeName(h.ID + 32)},
+	}
+}
+
+// Sends the MSB and LSB control changes of the high resolution controls with
+// the IDs (0 to 31) as HighResControlChanges. An MSB sends one with the LSB
+// set to 0, as a control may send only the MSB when its coarse value changes;
+// an LSB then sends one with the value of both, and an LSB on its own sends
+// one with the last MSB received.
+func HighResControlChanges(ids ...int) FilterFunc {
+	highRes := make(map[int]bool)
+	for _, id := range ids {
+		highRes[id] = true
+	}
+	msbs := make(map[[2]int]int) // Last MSB by channel and control.
+	return func(m Message) []Message {
+		n, ok := m.(ControlChange)
+		switch {
+		case !ok:
+		case highRes[n.ID]:
+			msbs[[2]int{n.Channel, n.ID}] = n.Value
+			return []Message{HighResControlChange{n.Channel, n.ID, n.Value << 7}}
+		case n.ID >= 32 && highRes[n.ID-32]:
+			msb := msbs[[2]int{n.Channel, n.ID - 32}]
+			return []Message{HighResControlChange{n.Channel, n.ID - 32, msb<<7 | n.Value}}
+		}
+		return []Message{m}
+	}
+}
+
+// A ParameterChange sets a registered (RPN) or non-registered (NRPN)
+// parameter, as the control changes returned by Messages.
+type ParameterChange struct {
+	Channel    int
+	Registered bool // Whether the parameter is an RPN rather than an NRPN.
+	Parameter  int  // 14 bits.
+	Value      int  // 14 bits.
+}
+
+// Returns the first of the parameter change's control changes, as a SysEx
+// returns its first bytes. MessageBytes and system ports send all of them.
+func (p ParameterChange) Uint32() uint32 {
+	return p.Messages()[0].Uint32()
+}
+
+// Returns the control changes that select the parameter and set its value, in order.
+func (p ParameterChange) Messages() []Message {
+	msb, lsb := 99, 98
+	if p.Registered {
+		msb, lsb = 101, 100
+	}
+	cc := func(id, value int) Message {
+		return ControlChange{p.Channel, id, value & 0x7F, ControlChangeName(id)}
+	}
+	return []Message{
+		cc(msb, p.Parameter>>7),
+		cc(lsb, p.Parameter),
+		cc(6, p.Value>>7),
+		cc(38, p.Value),
+	}
+}
+
+// Sends the control changes that select a parameter and set its value as
+// ParameterChanges. A ParameterChange is sent for each Data Entry MSB (6) or
+// LSB (38) received for a selected parameter; an MSB on its own sets the LSB
+// to 0. Data entry while no parameter is selected is sent on as is.
+func ParameterChanges() FilterFunc {
+	type state struct {
+		registered bool
+		parameter  [2]int // MSB and LSB, or -1 if not received.
+		value      int
+	}
+	channels := make(map[int]*state)
+	return func(m Message) []Message {
+		n, ok := m.(ControlChange)
+		if !ok {
+			return []Message{m}
+		}
+		s := channels[n.Channel]
+		if s == nil {
+			s = &state{parameter: [2]int{-1, -1}}
+			channels[n.Channel] = s
+		}
+		switch n.ID {
+		case 99, 101:
+			if registered := n.ID == 101; s.registered != registered {
+				s.registered, s.parameter[1] = registered, -1
+			}
+			s.parameter[0] = n.Value
+			return nil
+		case 98, 100:
+			if registered := n.ID == 100; s.registered != registered {
+				s.registered, s.parameter[0] = registered, -1
+			}
+			s.parameter[1] = n.Value
+			return nil
+		case 6, 38:
+			if s.parameter[0] < 0 || s.parameter[1] < 0 ||
+				s.registered && s.parameter == [2]int{127, 127} { // The null RPN.
+				return []Message{m}
+			}
+			if n.ID == 6 {
+				s.value = n.Value << 7
+			} else {
+				s.value = s.value&^0x7F | n.Value
+			}
+			return []Message{ParameterChange{n.Channel, s.registered, s.parameter[0]<<7 | s.parameter[1], s.value}}
+		}
+		return []Message{m}
+	}
+}
@@ -0,0 +1,79 @@
+package midi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParameterChanges(t *testing.T) {
+	f := ParameterChanges()
+	cc := func(channel, id, value int) Message { return ControlChange{channel, id, value, ""} }
+	tests := []struct {
+		in       Message
+		expected []Message
+	}{
+		{cc(0, 6, 10), []Message{cc(0, 6, 10)}},
+		{cc(0, 99, 3), nil},
+		{cc(0, 98, 5), nil},
+		{cc(0, 6, 64), []Message{ParameterChange{0, false, 3<<7 | 5, 64 << 7}}},
+		{cc(0, 38, 1), []Message{ParameterChange{0, false, 3<<7 | 5, 64<<7 | 1}}},
+		{cc(1, 38, 1), []Message{cc(1, 38, 1)}},
+		{cc(0, 100, 0), nil},
+		{cc(0, 6, 2), []Message{cc(0, 6, 2)}},
+		{cc(0, 101, 0), nil},
+		{cc(0, 6, 2), []Message{ParameterChange{0, true, 0, 2 << 7}}},
+		{cc(0, 101, 127), nil},
+		{cc(0, 100, 127), nil},
+		{cc(0, 6, 2), []Message{cc(0, 6, 2)}},
+		{cc(0, 7, 100), []Message{cc(0, 7, 100)}},
+	}
+	for _, test := range tests {
+		if actual := f(test.in); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Received %v from %v instead of %v", actual, test.in, test.expected)
+		}
+	}
+}
+
+func TestParameterChangeControlChanges(t *testing.T) {
+	p := ParameterChange{2, false, 1000, 9000}
+	expected := []byte{0xB2, 99, 7, 0xB2, 98, 104, 0xB2, 6, 70, 0xB2, 38, 40}
+	if b := MessageBytes(p); !bytes.Equal(b, expected) {
+		t.Errorf("Received % X from %v instead of % X", b, p, expected)
+	}
+	f := ParameterChanges()
+	var decoded []Message
+	for _, cc := range p.Messages() {
+		decoded = append(decoded, f(cc)...)
+	}
+	if len(decoded) != 2 || decoded[1] != p {
+		t.Errorf("Decoded %v from the control changes of %v", decoded, p)
+	}
+}
+
+func TestHighResControlChanges(t *testing.T) {
+	f := HighResControlChanges(1, 7)
+	cc := func(channel, id, value int) Message { return ControlChange{channel, id, value, ""} }
+	tests := []struct {
+		in       Message
+		expected []Message
+	}{
+		{cc(0, 1, 64), []Message{HighResControlChange{0, 1, 64 << 7}}},
+		{cc(0, 33, 3), []Message{HighResControlChange{0, 1, 64<<7 | 3}}},
+		{cc(0, 33, 4), []Message{HighResControlChange{0, 1, 64<<7 | 4}}},
+		{cc(0, 1, 65), []Message{HighResControlChange{0, 1, 65 << 7}}}, // Only the MSB changes.
+		{cc(0, 1, 66), []Message{HighResControlChange{0, 1, 66 << 7}}},
+		{cc(1, 39, 5), []Message{HighResControlChange{1, 7, 5}}},
+		{cc(0, 2, 64), []Message{cc(0, 2, 64)}},
+		{cc(0, 34, 1), []Message{cc(0, 34, 1)}},
+	}
+	for _, test := range tests {
+		if actual := f(test.in); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Received %v from %v instead of %v", actual, test.in, test.expected)
+		}
+	}
+	h := HighResControlChange{3, 7, 16383}
+	if b, expected := MessageBytes(h), []byte{0xB3, 7, 127, 0xB3, 39, 127}; !bytes.Equal(b, expected) {
+		t.Errorf("Received % X from %v instead of % X", b, h, expected)
+	}
+}
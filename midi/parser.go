@@ -0,0 +1,58 @@
+package midi
+
+// A StreamParser splits a stream of bytes, as sent over a MIDI cable, into
+// messages. Messages may leave out their status byte when it is the same
+// as the message before's (running status), and real time messages may
+// come between the bytes of any other.
+type StreamParser struct {
+	status byte   // The running status, or 0 if there is none.
+	data   []byte // The bytes so far of the message being parsed.
+}
+
+// Returns the messages completed by the bytes, in order.
+func (p *StreamParser) Parse(b []byte) []Message {
+	var messages []Message
+	for _, c := range b {
+		switch {
+		case int(c) >= CLOCK:
+			m, _ := ParseMessage([]byte{c})
+			messages = append(messages, m)
+			continue
+		case c == 0xF7 && len(p.data) > 0 && int(p.data[0]) == SYSEX:
+			messages = append(messages, SysEx{append(p.data, c)})
+			p.data = nil
+			continue
+		case c&0x80 != 0:
+			// A status byte ends any incomplete message before it.
+			p.data = []byte{c}
+			p.status = 0
+			if int(c) < SYSEX {
+				p.status = c
+			}
+		case len(p.data) > 0:
+			p.data = append(p.data, c)
+		case p.status != 0:
+			p.data = []byte{p.status, c}
+		default:
+			continue // A data byte without a status.
+		}
+		if int(p.data[0]) == SYSEX {
+			continue
+		}
+		switch n := MessageLength(int(p.data[0])); {
+		case n == 0:
+			p.data = nil // An undefined status.
+		case n == len(p.data):
+			m, _ := ParseMessage(p.data)
+			messages = append(messages, m)
+			p.data = nil
+		}
+	}
+	return messages
+}
+
+// Discards the running status and any incomplete message, as after an error in the stream.
+func (p *StreamParser) Reset() {
+	p.status = 0
+	p.data = nil
+}
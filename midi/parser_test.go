@@ -0,0 +1,42 @@
+package midi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStreamParser(t *testing.T) {
+	var p StreamParser
+	b := []byte{
+		0x90, 60, 100, 64, 100, // Running status.
+		0xB0, 7, 0xF8, 90, // A real time message within another.
+		0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7,
+		60, 0, // No running status after a system message.
+		0xC1, 5, 6,
+	}
+	expected := []Message{
+		NoteOn{0, 60, 100},
+		NoteOn{0, 64, 100},
+		Clock{},
+		ControlChange{0, 7, 90, ControlChangeNames[7]},
+		SysEx{[]byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+		ProgramChange{1, 5},
+		ProgramChange{1, 6},
+	}
+	if actual := p.Parse(b); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Parsed %v instead of %v", actual, expected)
+	}
+}
+
+func TestStreamParserReset(t *testing.T) {
+	var p StreamParser
+	p.Parse([]byte{0x90, 60}) // Cut off.
+	p.Reset()
+	if actual := p.Parse([]byte{64, 100}); len(actual) != 0 {
+		t.Errorf("Parsed %v from data bytes after a reset", actual)
+	}
+	expected := []Message{NoteOff{0, 60, 0}}
+	if actual := p.Parse([]byte{0x80, 60, 0}); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Parsed %v after a reset instead of %v", actual, expected)
+	}
+}
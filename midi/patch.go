@@ -0,0 +1,73 @@
+package midi
+
+import "fmt"
+
+// A PatchSelect chooses a patch by bank and program, as the Bank Select MSB
+// (control change 0) and LSB (32) followed by a ProgramChange.
+type PatchSelect struct {
+	Channel int
+	Bank    int // 14 bits.
+	Program int
+}
+
+// Returns the first of the messages, the Bank Select MSB. MessageBytes and system ports send all of them.
+func (p PatchSelect) Uint32() uint32 {
+	return p.Messages()[0].Uint32()
+}
+
+func (p PatchSelect) MarshalMIDI() []byte { return MessageBytes(p) }
+
+func (p *PatchSelect) UnmarshalMIDI(b []byte) error {
+	var parser StreamParser
+	f := PatchSelects()
+	var last Message
+	for _, m := range parser.Parse(b) {
+		for _, selected := range f(m) {
+			last = selected
+		}
+	}
+	s, ok := last.(PatchSelect)
+	if !ok {
+		return fmt.Errorf("% X is not a patch select", b)
+	}
+	*p = s
+	return nil
+}
+
+// Returns the bank selects and the program change, in order.
+func (p PatchSelect) Messages() []Message {
+	return []Message{
+		ControlChange{p.Channel, 0, p.Bank >> 7 & 0x7F, ControlChangeName(0)},
+		ControlChange{p.Channel, 32, p.Bank & 0x7F, ControlChangeName(32)},
+		ProgramChange{p.Channel, p.Program},
+	}
+}
+
+// Sends bank selects followed by a program change as a PatchSelect. Bank
+// selects are held until the program change that they apply to is received;
+// a bank select MSB on its own selects an LSB of 0. Program changes without
+// bank selects are sent on as is.
+func PatchSelects() FilterFunc {
+	banks := make(map[int]int) // Selected bank by channel.
+	return func(m Message) []Message {
+		switch n := m.(type) {
+		case ControlChange:
+			switch n.ID {
+			case 0:
+				banks[n.Channel] = n.Value << 7
+				return nil
+			case 32:
+				banks[n.Channel] = banks[n.Channel]&^0x7F | n.Value
+				return nil
+			}
+		case ProgramChange:
+			bank, ok := banks[n.Channel]
+			if !ok {
+				break
+			}
+			delete(banks, n.Channel)
+			return []Message{PatchSelect{n.Channel, bank, n.Program}}
+		}
+		return []Message{m}
+	}
+}
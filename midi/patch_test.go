@@ -0,0 +1,37 @@
+package midi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPatchSelects(t *testing.T) {
+	f := PatchSelects()
+	tests := []struct {
+		in       Message
+		expected []Message
+	}{
+		{ProgramChange{0, 5}, []Message{ProgramChange{0, 5}}},
+		{ControlChange{0, 0, 1, ""}, nil},
+		{ControlChange{0, 32, 2, ""}, nil},
+		{ControlChange{1, 0, 3, ""}, nil},
+		{ProgramChange{0, 6}, []Message{PatchSelect{0, 1<<7 | 2, 6}}},
+		{ProgramChange{0, 7}, []Message{ProgramChange{0, 7}}},
+		{ProgramChange{1, 8}, []Message{PatchSelect{1, 3 << 7, 8}}},
+		{ControlChange{0, 7, 100, ""}, []Message{ControlChange{0, 7, 100, ""}}},
+	}
+	for _, test := range tests {
+		if actual := f(test.in); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Received %v from %v instead of %v", actual, test.in, test.expected)
+		}
+	}
+	p := PatchSelect{2, 1<<7 | 5, 42}
+	if b, expected := MessageBytes(p), []byte{0xB2, 0, 1, 0xB2, 32, 5, 0xC2, 42}; !bytes.Equal(b, expected) {
+		t.Errorf("Received % X from %v instead of % X", b, p, expected)
+	}
+	var u PatchSelect
+	if err := u.UnmarshalMIDI(p.MarshalMIDI()); err != nil || u != p {
+		t.Errorf("Unmarshaled %v (%v) instead of %v", u, err, p)
+	}
+}
@@ -0,0 +1,108 @@
+package midi
+
+import (
+	"sync"
+	"time"
+)
+
+// Returns the messages of the tracks as one track, in time order. Messages
+// scheduled for the same time are ordered by Priority, then by track.
+func MergeTracks(tracks ...Track) Track {
+	var merged Track
+	for _, t := range tracks {
+		merged = append(merged, t...)
+	}
+	sortTimed(merged)
+	return merged
+}
+
+// A MultiTrackPlayer plays several tracks to a device together, e.g. the
+// per channel tracks of a Recorder, timing all of them against one clock.
+type MultiTrackPlayer struct {
+	To     *Device
+	Tracks []Track
+	// Messages played more than MaxAge late, as when the device held up
+	// playback, are dropped rather than played at once, or none if 0.
+	MaxAge time.Duration
+	mu     sync.Mutex
+	stop   chan bool
+	done   chan bool
+}
+
+// Creates a new MultiTrackPlayer of the tracks to the device.
+func NewMultiTrackPlayer(to *Device, tracks ...Track) *MultiTrackPlayer {
+	return &MultiTrackPlayer{
+		To:     to,
+		Tracks: tracks,
+	}
+}
+
+// Begins playing the tracks from the start, stopping any playback already
+// underway. Returns a channel that is closed when playback ends.
+func (p *MultiTrackPlayer) Play() <-chan bool {
+	p.Stop()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stop, p.done = make(chan bool), make(chan bool)
+	go p.play(MergeTracks(p.Tracks...), p.stop, p.done)
+	return p.done
+}
+
+// Stops playback, waiting for it to end.
+func (p *MultiTrackPlayer) Stop() {
+	p.mu.Lock()
+	stop, done := p.stop, p.done
+	p.stop = nil
+	p.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (p *MultiTrackPlayer) play(t Track, stop, done chan bool) {
+	defer close(done)
+	start := time.Now()
+	for _, e := range t {
+		select {
+		case <-time.After(time.Until(start.Add(e.Time))):
+		case <-stop:
+			return
+		}
+		if stale(e.Message, start.Add(e.Time), p.MaxAge) {
+			continue
+		}
+		if !p.To.sendFrom(e.Message, stop) {
+			return
+		}
+	}
+}
+
+// Reports whether a message due at the time is more than maxAge late, so that
+// a player held up, e.g. by a stalled device, drops it rather than sending a
+// flood of stale messages once it carries on. Messages that end notes are
+// never stale, so that no note is left on. A maxAge of 0 is no limit.
+func stale(m Message, due time.Time, maxAge time.Duration) bool {
+	if endsNotes(m) || maxAge == 0 {
+		return false
+	}
+	if time.Since(due) <= maxAge {
+		return false
+	}
+	Debug.Printf("Dropped %v, %v late", m, time.Since(due))
+	return true
+}
+
+// Reports whether a message ends notes: a note off in any form, including a
+// NoteOn with velocity 0, or All Notes Off or All Sound Off.
+func endsNotes(m Message) bool {
+	switch n := m.(type) {
+	case NoteOff, AllNotesOff, AllSoundOff:
+		return true
+	case NoteOn:
+		return n.Velocity == 0
+	case ControlChange:
+		return n.ID == 120 || n.ID == 123
+	}
+	return false
+}
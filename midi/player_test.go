@@ -0,0 +1,84 @@
+package midi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMultiTrackPlayer(t *testing.T) {
+	tracks := []Track{
+		{
+			{0, NoteOn{0, 60, 100}},
+			{40 * time.Millisecond, NoteOff{0, 60, 0}},
+		},
+		{
+			{20 * time.Millisecond, NoteOn{1, 48, 100}},
+			{40 * time.Millisecond, NoteOn{1, 50, 100}},
+			{60 * time.Millisecond, NoteOff{1, 48, 0}},
+		},
+	}
+	expected := MergeTracks(tracks...)
+	to := NewDevice()
+	p := NewMultiTrackPlayer(to, tracks...)
+	start := time.Now()
+	done := p.Play()
+	for _, e := range expected {
+		m := <-to.In
+		elapsed := time.Since(start)
+		if m != e.Message {
+			t.Errorf("Played %v instead of %v", m, e.Message)
+		}
+		if elapsed < e.Time || elapsed > e.Time+25*time.Millisecond {
+			t.Errorf("Played %v after %v instead of %v", m, elapsed, e.Time)
+		}
+	}
+	<-done
+}
+
+func TestMergeTracks(t *testing.T) {
+	merged := MergeTracks(
+		Track{{0, NoteOn{0, 60, 100}}, {10, NoteOn{0, 62, 100}}},
+		Track{{5, NoteOn{1, 48, 100}}, {10, NoteOff{1, 48, 0}}},
+	)
+	expected := Track{
+		{0, NoteOn{0, 60, 100}},
+		{5, NoteOn{1, 48, 100}},
+		{10, NoteOff{1, 48, 0}}, // Note offs come first.
+		{10, NoteOn{0, 62, 100}},
+	}
+	for i := range expected {
+		if merged[i] != expected[i] {
+			t.Errorf("Merged %v instead of %v", merged, expected)
+			break
+		}
+	}
+}
+
+func TestMultiTrackPlayerMaxAge(t *testing.T) {
+	track := Track{
+		{0, NoteOn{0, 60, 100}},
+		{5 * time.Millisecond, NoteOn{0, 64, 100}},
+		{10 * time.Millisecond, NoteOff{0, 60, 0}},
+		{11 * time.Millisecond, NoteOn{0, 64, 0}},
+		{12 * time.Millisecond, ControlChange{0, 123, 0, ""}},
+		{13 * time.Millisecond, AllSoundOff{1}},
+		{100 * time.Millisecond, NoteOn{0, 67, 100}},
+	}
+	to := NewDevice()
+	p := NewMultiTrackPlayer(to, track)
+	p.MaxAge = 10 * time.Millisecond
+	done := p.Play()
+	// Stall, so that the messages after the first are late.
+	time.Sleep(50 * time.Millisecond)
+	var played []Message
+	for len(played) < 6 {
+		played = append(played, <-to.In)
+	}
+	<-done
+	expected := []Message{NoteOn{0, 60, 100}, NoteOff{0, 60, 0}, NoteOn{0, 64, 0},
+		ControlChange{0, 123, 0, ""}, AllSoundOff{1}, NoteOn{0, 67, 100}}
+	if !reflect.DeepEqual(played, expected) {
+		t.Errorf("Played %v after stalling instead of %v", played, expected)
+	}
+}
@@ -0,0 +1,80 @@
+package midi
+
+import (
+	"fmt"
+	"io"
+)
+
+type portReader struct {
+	messages <-chan Message
+	done     <-chan bool
+	buf      []byte // The rest of the bytes of the last message received.
+}
+
+// Returns a reader of the raw MIDI bytes of the messages a port sends, e.g.
+// the messages a SystemOutPort reads from its device, for parsers and other
+// code that work with byte streams. Each read returns at most one message,
+// waiting for one if none is left, and io.EOF once the port is closed.
+func NewReader(p Porter) io.Reader {
+	return &portReader{messages: p.Messages(), done: p.Done()}
+}
+
+func (r *portReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for len(r.buf) == 0 {
+		select {
+		case m, ok := <-r.messages:
+			if !ok {
+				return 0, io.EOF
+			}
+			r.buf = m.MarshalMIDI()
+		case <-r.done:
+			// Messages still buffered by the port are read before io.EOF.
+			select {
+			case m := <-r.messages:
+				r.buf = m.MarshalMIDI()
+			default:
+				return 0, io.EOF
+			}
+		}
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+type portWriter struct {
+	port   Porter
+	parser StreamParser // Unless the port is a *Port, whose writers share its own.
+}
+
+// Returns a writer that parses raw MIDI bytes, as sent over a MIDI cable,
+// into the messages it sends to a port, e.g. for a SystemInPort to write to
+// its device. Messages may be split across writes, and the writers of a port
+// share its running status, which the Reset of a Device made from it discards.
+// The port must be open.
+func NewWriter(p Porter) io.Writer {
+	return &portWriter{port: p}
+}
+
+func (w *portWriter) Write(b []byte) (int, error) {
+	if !w.port.IsOpen() {
+		return 0, fmt.Errorf("Writing MIDI bytes: %w", ErrPortNotOpen)
+	}
+	var messages []Message
+	if p, ok := w.port.(interface{ parse([]byte) []Message }); ok {
+		messages = p.parse(b)
+	} else {
+		messages = w.parser.Parse(b)
+	}
+	for _, m := range messages {
+		select {
+		case w.port.Messages() <- m:
+		case <-w.port.Done():
+			return 0, fmt.Errorf("Writing MIDI bytes: %w", ErrPortNotOpen)
+		}
+	}
+	return len(b), nil
+}
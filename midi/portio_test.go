@@ -0,0 +1,46 @@
+package midi
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	p := NewPort(true)
+	go func() {
+		p.messages <- NoteOn{0, 60, 100}
+		p.messages <- TimedMessage{0, SysEx{[]byte{0xF0, 0x7D, 0x01, 0xF7}}}
+		p.Close()
+	}()
+	actual, err := io.ReadAll(NewReader(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x90, 60, 100, 0xF0, 0x7D, 0x01, 0xF7}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("Read % X instead of % X", actual, expected)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	p := NewPort(true)
+	p.messages = make(chan Message, 2)
+	w := NewWriter(p)
+	// A note on split across writes, then a note off with running status.
+	for _, b := range [][]byte{{0x90, 60}, {100, 60, 0}} {
+		if n, err := w.Write(b); n != len(b) || err != nil {
+			t.Errorf("Wrote (%d, %v) instead of (%d, nil)", n, err, len(b))
+		}
+	}
+	for _, expected := range []Message{NoteOn{0, 60, 100}, NoteOn{0, 60, 0}} {
+		if m := <-p.messages; m != expected {
+			t.Errorf("Sent %v instead of %v", m, expected)
+		}
+	}
+	p.Close()
+	if _, err := w.Write([]byte{0xF8}); !errors.Is(err, ErrPortNotOpen) {
+		t.Errorf("Received %v from writing to a closed port instead of %v", err, ErrPortNotOpen)
+	}
+}
@@ -0,0 +1,371 @@
+//go:build alsa
+
+package portmidi
+
+/*
+#cgo LDFLAGS: -lasound
+#include <alsa/asoundlib.h>
+#include <errno.h>
+#include <poll.h>
+#include <stdlib.h>
+
+// Waits up to timeout milliseconds for input on a stream opened in nonblocking
+// mode, returning the number of bytes read into buf (0 if none arrived) or a
+// negative error code.
+static ssize_t read_input(snd_rawmidi_t *in, unsigned char *buf, size_t size, int timeout) {
+	struct pollfd fds[8];
+	unsigned short revents;
+	int n = snd_rawmidi_poll_descriptors(in, fds, 8);
+	int ready = poll(fds, n, timeout);
+	if (ready < 0) {
+		return errno == EINTR ? 0 : -errno;
+	}
+	if (ready == 0) {
+		return 0;
+	}
+	snd_rawmidi_poll_descriptors_revents(in, fds, n, &revents);
+	if (revents & (POLLERR | POLLHUP)) {
+		return -ENODEV;
+	}
+	if (!(revents & POLLIN)) {
+		return 0;
+	}
+	ssize_t r = snd_rawmidi_read(in, buf, size);
+	return r == -EAGAIN ? 0 : r;
+}
+
+// Opens a sequencer client with a port, both named name, that other clients
+// can write to if input or read from otherwise.
+static int open_virtual(const char *name, int input, snd_seq_t **seq, int *port) {
+	int err = snd_seq_open(seq, "default", input ? SND_SEQ_OPEN_INPUT : SND_SEQ_OPEN_OUTPUT, input ? SND_SEQ_NONBLOCK : 0);
+	if (err < 0) {
+		return err;
+	}
+	snd_seq_set_client_name(*seq, name);
+	unsigned int caps = input ? SND_SEQ_PORT_CAP_WRITE | SND_SEQ_PORT_CAP_SUBS_WRITE : SND_SEQ_PORT_CAP_READ | SND_SEQ_PORT_CAP_SUBS_READ;
+	*port = snd_seq_create_simple_port(*seq, name, caps, SND_SEQ_PORT_TYPE_MIDI_GENERIC | SND_SEQ_PORT_TYPE_APPLICATION);
+	if (*port < 0) {
+		err = *port;
+		snd_seq_close(*seq);
+	}
+	return err < 0 ? err : 0;
+}
+
+// Waits up to timeout milliseconds for an event on a sequencer client opened
+// for input and decodes it into buf, returning the number of bytes (0 if none
+// arrived or it isn't a MIDI message) or a negative error code.
+static long read_virtual(snd_seq_t *seq, snd_midi_event_t *decoder, unsigned char *buf, long size, int timeout) {
+	struct pollfd fds[8];
+	int n = snd_seq_poll_descriptors(seq, fds, 8, POLLIN);
+	int ready = poll(fds, n, timeout);
+	if (ready < 0) {
+		return errno == EINTR ? 0 : -errno;
+	}
+	if (ready == 0) {
+		return 0;
+	}
+	snd_seq_event_t *ev;
+	int err = snd_seq_event_input(seq, &ev);
+	if (err == -EAGAIN) {
+		return 0;
+	}
+	if (err < 0) {
+		return err;
+	}
+	long r = snd_midi_event_decode(decoder, buf, size, ev);
+	return r == -ENOENT ? 0 : r;
+}
+
+// Encodes the bytes of MIDI messages as events sent from the port to its subscribers.
+static int write_virtual(snd_seq_t *seq, int port, snd_midi_event_t *encoder, const unsigned char *buf, long size) {
+	snd_seq_event_t ev;
+	while (size > 0) {
+		snd_seq_ev_clear(&ev);
+		long n = snd_midi_event_encode(encoder, buf, size, &ev);
+		if (n <= 0) {
+			return n < 0 ? n : -EINVAL;
+		}
+		buf += n;
+		size -= n;
+		if (ev.type == SND_SEQ_EVENT_NONE) {
+			continue; // The message continues in the next bytes.
+		}
+		snd_seq_ev_set_source(&ev, port);
+		snd_seq_ev_set_subs(&ev);
+		snd_seq_ev_set_direct(&ev);
+		int err = snd_seq_event_output_direct(seq, &ev);
+		if (err < 0) {
+			return err;
+		}
+	}
+	return 0;
+}
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+var system backend = &alsa{}
+
+// How often a reading stream checks whether it has been closed, in milliseconds.
+const alsaPollTimeout = 10
+
+// The ALSA rawmidi API, whose streams are the subdevices of each sound card's
+// MIDI devices, in each direction.
+type alsa struct {
+	addresses []string // By stream ID, e.g. "hw:1,0,0".
+}
+
+func alsaError(code C.int) error {
+	if code >= 0 {
+		return nil
+	}
+	return errors.New(C.GoString(C.snd_strerror(code)))
+}
+
+func (a *alsa) streams() ([]StreamInfo, error) {
+	var infos []StreamInfo
+	a.addresses = nil
+	card := C.int(-1)
+	for {
+		if err := alsaError(C.snd_card_next(&card)); err != nil {
+			return infos, err
+		}
+		if card < 0 {
+			return infos, nil
+		}
+		var ctl *C.snd_ctl_t
+		name := C.CString(fmt.Sprintf("hw:%d", card))
+		code := C.snd_ctl_open(&ctl, name, 0)
+		C.free(unsafe.Pointer(name))
+		if code < 0 {
+			continue // The card may be in use or lack a control interface.
+		}
+		device := C.int(-1)
+		for C.snd_ctl_rawmidi_next_device(ctl, &device) >= 0 && device >= 0 {
+			infos = a.appendSubdevices(infos, ctl, card, device, C.SND_RAWMIDI_STREAM_INPUT)
+			infos = a.appendSubdevices(infos, ctl, card, device, C.SND_RAWMIDI_STREAM_OUTPUT)
+		}
+		C.snd_ctl_close(ctl)
+	}
+}
+
+// Appends the streams of a MIDI device's subdevices in one direction. A
+// subdevice is named by the device, unless the device has several.
+func (a *alsa) appendSubdevices(infos []StreamInfo, ctl *C.snd_ctl_t, card, device C.int, direction C.snd_rawmidi_stream_t) []StreamInfo {
+	var info *C.snd_rawmidi_info_t
+	if C.snd_rawmidi_info_malloc(&info) < 0 {
+		return infos
+	}
+	defer C.snd_rawmidi_info_free(info)
+	C.snd_rawmidi_info_set_device(info, C.uint(device))
+	C.snd_rawmidi_info_set_stream(info, direction)
+	C.snd_rawmidi_info_set_subdevice(info, 0)
+	if C.snd_ctl_rawmidi_info(ctl, info) < 0 {
+		return infos // The device has no subdevices in this direction.
+	}
+	n := int(C.snd_rawmidi_info_get_subdevices_count(info))
+	for sub := 0; sub < n; sub++ {
+		C.snd_rawmidi_info_set_subdevice(info, C.uint(sub))
+		if C.snd_ctl_rawmidi_info(ctl, info) < 0 {
+			continue
+		}
+		name := C.GoString(C.snd_rawmidi_info_get_name(info))
+		if n > 1 {
+			name = C.GoString(C.snd_rawmidi_info_get_subdevice_name(info))
+		}
+		infos = append(infos, StreamInfo{
+			IsInput:   direction == C.SND_RAWMIDI_STREAM_INPUT,
+			IsOutput:  direction == C.SND_RAWMIDI_STREAM_OUTPUT,
+			Name:      name,
+			Interface: "ALSA",
+		})
+		a.addresses = append(a.addresses, fmt.Sprintf("hw:%d,%d,%d", card, device, sub))
+	}
+	return infos
+}
+
+func (a *alsa) openInput(deviceID int, q *eventQueue) (io.Closer, error) {
+	var handle *C.snd_rawmidi_t
+	address := C.CString(a.addresses[deviceID])
+	defer C.free(unsafe.Pointer(address))
+	if err := alsaError(C.snd_rawmidi_open(&handle, nil, address, C.SND_RAWMIDI_NONBLOCK)); err != nil {
+		return nil, err
+	}
+	in := &alsaInput{handle: handle, done: make(chan bool), stopped: make(chan bool)}
+	go in.read(q)
+	return in, nil
+}
+
+func (a *alsa) openOutput(deviceID int) (io.WriteCloser, error) {
+	var handle *C.snd_rawmidi_t
+	address := C.CString(a.addresses[deviceID])
+	defer C.free(unsafe.Pointer(address))
+	if err := alsaError(C.snd_rawmidi_open(nil, &handle, address, 0)); err != nil {
+		return nil, err
+	}
+	return alsaOutput{handle}, nil
+}
+
+// Virtual ports are sequencer clients, as rawmidi can't create devices.
+func (a *alsa) openVirtualInput(name string, q *eventQueue) (io.Closer, error) {
+	v, err := openVirtual(name, true)
+	if err != nil {
+		return nil, err
+	}
+	in := &alsaVirtualInput{alsaVirtual: v, done: make(chan bool), stopped: make(chan bool)}
+	go in.read(q)
+	return in, nil
+}
+
+func (a *alsa) openVirtualOutput(name string) (io.WriteCloser, error) {
+	v, err := openVirtual(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &alsaVirtualOutput{alsaVirtual: v}, nil
+}
+
+// A sequencer client with a port, and a parser of MIDI messages to and from its events.
+type alsaVirtual struct {
+	seq    *C.snd_seq_t
+	port   C.int
+	parser *C.snd_midi_event_t
+}
+
+func openVirtual(name string, input bool) (*alsaVirtual, error) {
+	v := &alsaVirtual{}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	isInput := C.int(0)
+	if input {
+		isInput = 1
+	}
+	if err := alsaError(C.open_virtual(cName, isInput, &v.seq, &v.port)); err != nil {
+		return nil, err
+	}
+	if err := alsaError(C.snd_midi_event_new(alsaVirtualBufferSize, &v.parser)); err != nil {
+		C.snd_seq_close(v.seq)
+		return nil, err
+	}
+	C.snd_midi_event_no_status(v.parser, 1)
+	return v, nil
+}
+
+func (v *alsaVirtual) close() error {
+	C.snd_midi_event_free(v.parser)
+	return alsaError(C.snd_seq_close(v.seq))
+}
+
+// The largest SysEx event a virtual port parses at once.
+const alsaVirtualBufferSize = 1024
+
+type alsaVirtualInput struct {
+	*alsaVirtual
+	done    chan bool // Closed to stop reading.
+	stopped chan bool // Closed once reading has stopped.
+}
+
+func (in *alsaVirtualInput) read(q *eventQueue) {
+	defer close(in.stopped)
+	buf := make([]byte, alsaVirtualBufferSize)
+	for {
+		select {
+		case <-in.done:
+			return
+		default:
+		}
+		n := C.read_virtual(in.seq, in.parser, (*C.uchar)(unsafe.Pointer(&buf[0])), C.long(len(buf)), alsaPollTimeout)
+		switch {
+		case n == -C.ENOSPC: // The client's input pool overflowed.
+			q.lost()
+		case n < 0:
+			q.fail(alsaError(C.int(n)))
+			return
+		case n > 0:
+			q.receive(buf[:n], Time())
+		}
+	}
+}
+
+func (in *alsaVirtualInput) Close() error {
+	close(in.done)
+	<-in.stopped
+	return in.close()
+}
+
+type alsaVirtualOutput struct {
+	*alsaVirtual
+}
+
+func (out *alsaVirtualOutput) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if err := alsaError(C.write_virtual(out.seq, out.port, out.parser, (*C.uchar)(unsafe.Pointer(&b[0])), C.long(len(b)))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (out *alsaVirtualOutput) Close() error {
+	return out.close()
+}
+
+type alsaInput struct {
+	handle  *C.snd_rawmidi_t
+	done    chan bool // Closed to stop reading.
+	stopped chan bool // Closed once reading has stopped.
+}
+
+// Passes the bytes read to the queue until closed or the device fails.
+func (in *alsaInput) read(q *eventQueue) {
+	defer close(in.stopped)
+	buf := make([]byte, 256)
+	for {
+		select {
+		case <-in.done:
+			return
+		default:
+		}
+		n := C.read_input(in.handle, (*C.uchar)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)), alsaPollTimeout)
+		if n < 0 {
+			q.fail(alsaError(C.int(n)))
+			return
+		}
+		if n > 0 {
+			q.receive(buf[:n], Time())
+		}
+	}
+}
+
+func (in *alsaInput) Close() error {
+	close(in.done)
+	<-in.stopped
+	return alsaError(C.snd_rawmidi_close(in.handle))
+}
+
+type alsaOutput struct {
+	handle *C.snd_rawmidi_t
+}
+
+func (out alsaOutput) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n := C.snd_rawmidi_write(out.handle, unsafe.Pointer(&b[0]), C.size_t(len(b)))
+	if n < 0 {
+		return 0, alsaError(C.int(n))
+	}
+	return int(n), nil
+}
+
+// Waits for the bytes written to be sent before closing.
+func (out alsaOutput) Close() error {
+	C.snd_rawmidi_drain(out.handle)
+	return alsaError(C.snd_rawmidi_close(out.handle))
+}
@@ -0,0 +1,306 @@
+//go:build darwin && coremidi
+
+package portmidi
+
+/*
+#cgo LDFLAGS: -framework CoreMIDI -framework CoreFoundation
+#include <CoreMIDI/CoreMIDI.h>
+#include <mach/mach_time.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+extern void coreMIDIReceive(uintptr_t stream, unsigned char *data, int length, unsigned long long ago);
+
+// Passes each packet received to Go, with how many nanoseconds ago it was
+// timestamped (0 for packets timestamped now or in the future.)
+static void read_packets(const MIDIPacketList *list, void *portRefCon, void *stream) {
+	mach_timebase_info_data_t base;
+	mach_timebase_info(&base);
+	uint64_t now = mach_absolute_time();
+	const MIDIPacket *p = &list->packet[0];
+	for (UInt32 i = 0; i < list->numPackets; i++) {
+		unsigned long long ago = 0;
+		if (p->timeStamp != 0 && p->timeStamp < now) {
+			ago = (now - p->timeStamp) * base.numer / base.denom;
+		}
+		coreMIDIReceive((uintptr_t)stream, (unsigned char *)p->data, p->length, ago);
+		p = MIDIPacketNext(p);
+	}
+}
+
+// Passes the packets sent to a virtual destination to Go.
+static void read_virtual_packets(const MIDIPacketList *list, void *stream, void *srcConnRefCon) {
+	read_packets(list, NULL, stream);
+}
+
+static OSStatus create_client(MIDIClientRef *client) {
+	return MIDIClientCreate(CFSTR("Go"), NULL, NULL, client);
+}
+
+static OSStatus open_input(MIDIClientRef client, MIDIEndpointRef source, uintptr_t stream, MIDIPortRef *port) {
+	OSStatus err = MIDIInputPortCreate(client, CFSTR("Input"), read_packets, NULL, port);
+	if (err != noErr) {
+		return err;
+	}
+	err = MIDIPortConnectSource(*port, source, (void *)stream);
+	if (err != noErr) {
+		MIDIPortDispose(*port);
+	}
+	return err;
+}
+
+static OSStatus open_output(MIDIClientRef client, MIDIPortRef *port) {
+	return MIDIOutputPortCreate(client, CFSTR("Output"), port);
+}
+
+// Creates a destination that other programs send to, passing what they send to Go.
+static OSStatus open_virtual_input(MIDIClientRef client, const char *name, uintptr_t stream, MIDIEndpointRef *destination) {
+	CFStringRef s = CFStringCreateWithCString(NULL, name, kCFStringEncodingUTF8);
+	OSStatus err = MIDIDestinationCreate(client, s, read_virtual_packets, (void *)stream, destination);
+	CFRelease(s);
+	return err;
+}
+
+// Creates a source that other programs receive from.
+static OSStatus open_virtual_output(MIDIClientRef client, const char *name, MIDIEndpointRef *source) {
+	CFStringRef s = CFStringCreateWithCString(NULL, name, kCFStringEncodingUTF8);
+	OSStatus err = MIDISourceCreate(client, s, source);
+	CFRelease(s);
+	return err;
+}
+
+// Sends bytes as a single packet, to be delivered in nanoseconds or, if 0,
+// immediately, from the port to the destination or, without a port, from the
+// virtual source.
+static OSStatus send_packet(MIDIPortRef port, MIDIEndpointRef destination, const Byte *data, UInt16 length, unsigned long long in) {
+	MIDITimeStamp when = 0;
+	if (in > 0) {
+		mach_timebase_info_data_t base;
+		mach_timebase_info(&base);
+		when = mach_absolute_time() + in * base.denom / base.numer;
+	}
+	ByteCount size = sizeof(MIDIPacketList) + length;
+	MIDIPacketList *list = malloc(size);
+	if (list == NULL) {
+		return kMIDIMsgIOError;
+	}
+	MIDIPacket *p = MIDIPacketListInit(list);
+	p = MIDIPacketListAdd(list, size, p, when, length, data);
+	OSStatus err = kMIDIMsgIOError;
+	if (p != NULL) {
+		err = port == 0 ? MIDIReceived(destination, list) : MIDISend(port, destination, list);
+	}
+	free(list);
+	return err;
+}
+
+// Returns the endpoint's display name, which includes its device's name, as a
+// string to be freed, or NULL.
+static char *endpoint_name(MIDIEndpointRef endpoint) {
+	CFStringRef name = NULL;
+	if (MIDIObjectGetStringProperty(endpoint, kMIDIPropertyDisplayName, &name) != noErr || name == NULL) {
+		return NULL;
+	}
+	CFIndex size = CFStringGetMaximumSizeForEncoding(CFStringGetLength(name), kCFStringEncodingUTF8) + 1;
+	char *s = malloc(size);
+	if (s != NULL && !CFStringGetCString(name, s, size, kCFStringEncodingUTF8)) {
+		free(s);
+		s = NULL;
+	}
+	CFRelease(name);
+	return s;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+var system backend = &coreMIDI{}
+
+// CoreMIDI, whose streams are the system's sources (inputs) then its
+// destinations (outputs), including other applications' virtual endpoints.
+type coreMIDI struct {
+	client    C.MIDIClientRef
+	endpoints []C.MIDIEndpointRef // By stream ID.
+}
+
+func coreMIDIError(status C.OSStatus) error {
+	if status == C.noErr {
+		return nil
+	}
+	return fmt.Errorf("CoreMIDI error %d", int(status))
+}
+
+// Creates the client that ports are opened on, unless it has been already.
+func (c *coreMIDI) createClient() error {
+	if c.client != 0 {
+		return nil
+	}
+	return coreMIDIError(C.create_client(&c.client))
+}
+
+func (c *coreMIDI) streams() ([]StreamInfo, error) {
+	var infos []StreamInfo
+	c.endpoints = nil
+	if err := c.createClient(); err != nil {
+		return nil, err
+	}
+	for i := C.ItemCount(0); i < C.MIDIGetNumberOfSources(); i++ {
+		source := C.MIDIGetSource(i)
+		infos = append(infos, StreamInfo{IsInput: true, Name: endpointName(source), Interface: "CoreMIDI"})
+		c.endpoints = append(c.endpoints, source)
+	}
+	for i := C.ItemCount(0); i < C.MIDIGetNumberOfDestinations(); i++ {
+		destination := C.MIDIGetDestination(i)
+		infos = append(infos, StreamInfo{IsOutput: true, Name: endpointName(destination), Interface: "CoreMIDI"})
+		c.endpoints = append(c.endpoints, destination)
+	}
+	return infos, nil
+}
+
+func endpointName(endpoint C.MIDIEndpointRef) string {
+	name := C.endpoint_name(endpoint)
+	if name == nil {
+		return fmt.Sprintf("Endpoint %d", uint32(endpoint))
+	}
+	defer C.free(unsafe.Pointer(name))
+	return C.GoString(name)
+}
+
+// The queues of open input streams, by the handle their packets are received with.
+var coreMIDIInputs struct {
+	sync.Mutex
+	queues map[uintptr]*eventQueue
+	next   uintptr
+}
+
+// Returns the handle that packets for the queue are to be received with.
+func registerCoreMIDIInput(q *eventQueue) uintptr {
+	coreMIDIInputs.Lock()
+	defer coreMIDIInputs.Unlock()
+	if coreMIDIInputs.queues == nil {
+		coreMIDIInputs.queues = make(map[uintptr]*eventQueue)
+	}
+	coreMIDIInputs.next++
+	coreMIDIInputs.queues[coreMIDIInputs.next] = q
+	return coreMIDIInputs.next
+}
+
+func (c *coreMIDI) openInput(deviceID int, q *eventQueue) (io.Closer, error) {
+	if err := c.createClient(); err != nil {
+		return nil, err
+	}
+	stream := registerCoreMIDIInput(q)
+	in := &coreMIDIInput{stream: stream}
+	if err := coreMIDIError(C.open_input(c.client, c.endpoints[deviceID], C.uintptr_t(stream), &in.port)); err != nil {
+		in.unregister()
+		return nil, err
+	}
+	return in, nil
+}
+
+func (c *coreMIDI) openOutput(deviceID int) (io.WriteCloser, error) {
+	if err := c.createClient(); err != nil {
+		return nil, err
+	}
+	out := &coreMIDIOutput{destination: c.endpoints[deviceID]}
+	if err := coreMIDIError(C.open_output(c.client, &out.port)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Virtual ports are endpoints of the client, named name.
+func (c *coreMIDI) openVirtualInput(name string, q *eventQueue) (io.Closer, error) {
+	if err := c.createClient(); err != nil {
+		return nil, err
+	}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	in := &coreMIDIInput{stream: registerCoreMIDIInput(q)}
+	if err := coreMIDIError(C.open_virtual_input(c.client, cName, C.uintptr_t(in.stream), &in.destination)); err != nil {
+		in.unregister()
+		return nil, err
+	}
+	return in, nil
+}
+
+func (c *coreMIDI) openVirtualOutput(name string) (io.WriteCloser, error) {
+	if err := c.createClient(); err != nil {
+		return nil, err
+	}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	out := &coreMIDIOutput{}
+	if err := coreMIDIError(C.open_virtual_output(c.client, cName, &out.destination)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type coreMIDIInput struct {
+	port        C.MIDIPortRef
+	destination C.MIDIEndpointRef // Of a virtual port, in place of a port.
+	stream      uintptr
+}
+
+func (in *coreMIDIInput) unregister() {
+	coreMIDIInputs.Lock()
+	delete(coreMIDIInputs.queues, in.stream)
+	coreMIDIInputs.Unlock()
+}
+
+func (in *coreMIDIInput) Close() error {
+	var err error
+	if in.port != 0 {
+		err = coreMIDIError(C.MIDIPortDispose(in.port))
+	} else {
+		err = coreMIDIError(C.MIDIEndpointDispose(in.destination))
+	}
+	in.unregister()
+	return err
+}
+
+type coreMIDIOutput struct {
+	port        C.MIDIPortRef     // Zero for a virtual port.
+	destination C.MIDIEndpointRef // Or, for a virtual port, its source.
+}
+
+func (out *coreMIDIOutput) Write(b []byte) (int, error) {
+	if err := out.send(b, 0); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Has CoreMIDI send the bytes at a time on the clock returned by Time, or
+// immediately if that has passed.
+func (out *coreMIDIOutput) writeAt(b []byte, timestamp time.Duration) error {
+	in := timestamp - Time()
+	if in < 0 {
+		in = 0
+	}
+	return out.send(b, in)
+}
+
+func (out *coreMIDIOutput) send(b []byte, in time.Duration) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if len(b) > 0xFFFF {
+		return fmt.Errorf("Message of %d bytes is too long for a CoreMIDI packet", len(b))
+	}
+	return coreMIDIError(C.send_packet(out.port, out.destination, (*C.Byte)(unsafe.Pointer(&b[0])), C.UInt16(len(b)), C.ulonglong(in)))
+}
+
+func (out *coreMIDIOutput) Close() error {
+	if out.port == 0 {
+		return coreMIDIError(C.MIDIEndpointDispose(out.destination))
+	}
+	return coreMIDIError(C.MIDIPortDispose(out.port))
+}
@@ -0,0 +1,25 @@
+//go:build darwin && coremidi
+
+package portmidi
+
+// Kept apart from coremidi.go, as a file exporting Go functions to C can't
+// define C functions in its preamble.
+
+// #include <stdint.h>
+import "C"
+import (
+	"time"
+	"unsafe"
+)
+
+// Called by CoreMIDI's read callback with the bytes of each packet received.
+//
+//export coreMIDIReceive
+func coreMIDIReceive(stream C.uintptr_t, data *C.uchar, length C.int, ago C.ulonglong) {
+	coreMIDIInputs.Lock()
+	q := coreMIDIInputs.queues[uintptr(stream)]
+	coreMIDIInputs.Unlock()
+	if q != nil {
+		q.receive(C.GoBytes(unsafe.Pointer(data), length), Time()-time.Duration(ago))
+	}
+}
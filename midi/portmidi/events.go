@@ -0,0 +1,273 @@
+package portmidi
+
+import (
+	"sync"
+	"time"
+)
+
+type event struct {
+	message   uint32
+	timestamp time.Duration
+}
+
+// An eventQueue buffers the bytes received from a stream by a backend other
+// than PortMidi as the events PortMidi would read: one for each message, with
+// a SysEx message split into events of 4 bytes. Events that don't fit in the
+// buffer are dropped, and the next read reports the overflow.
+type eventQueue struct {
+	mu              sync.Mutex
+	size            int // The number of events buffered, DefaultBufferSize if 0.
+	events          []event
+	overflowed      bool
+	err             error // Ends the stream once the events before it are read.
+	filters         int
+	droppedChannels int    // The channels not in the channel mask, a bit each.
+	status          byte   // Of the message being received, kept for running status.
+	data            []byte // The data bytes of the message being received.
+	sysEx           []byte // The bytes of a SysEx message not yet in an event.
+	inSysEx         bool
+	ready           chan bool // Receives when there may be an event or error to read.
+}
+
+// Discards any events and partly received messages, as when the stream is
+// reopened, and sets the number of events buffered.
+func (q *eventQueue) reset(size int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.size = size
+	q.events, q.overflowed, q.err = nil, false, nil
+	q.filters, q.droppedChannels = 0, 0
+	q.status, q.data, q.sysEx, q.inSysEx = 0, nil, nil, false
+}
+
+// Parses bytes received at a time on the clock returned by Time into events.
+// Messages may be split across calls, and real-time messages may come
+// between the bytes of other messages.
+func (q *eventQueue) receive(b []byte, at time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, c := range b {
+		switch {
+		case c >= 0xF8:
+			if messageLength(c) == 1 {
+				q.push(c, uint32(c), at)
+			}
+		case c == 0xF0:
+			q.status, q.data = 0, q.data[:0]
+			q.sysEx, q.inSysEx = append(q.sysEx[:0], c), true
+		case c == 0xF7 && q.inSysEx:
+			q.sysEx = append(q.sysEx, c)
+			q.endSysEx(at)
+		case c&0x80 != 0:
+			if q.inSysEx {
+				q.endSysEx(at) // Unterminated, it ends at the next status.
+			}
+			q.status, q.data = c, q.data[:0]
+			switch messageLength(c) {
+			case 0:
+				q.status = 0
+			case 1:
+				q.push(c, uint32(c), at)
+				q.status = 0
+			}
+		case q.inSysEx:
+			q.sysEx = append(q.sysEx, c)
+			if len(q.sysEx) == 4 {
+				q.push(0xF0, pack(q.sysEx), at)
+				q.sysEx = q.sysEx[:0]
+			}
+		case q.status != 0:
+			q.data = append(q.data, c)
+			if len(q.data)+1 == messageLength(q.status) {
+				q.push(q.status, pack(append([]byte{q.status}, q.data...)), at)
+				q.data = q.data[:0]
+				if q.status >= 0xF0 {
+					q.status = 0 // System common messages have no running status.
+				}
+			}
+		}
+	}
+}
+
+func (q *eventQueue) endSysEx(at time.Duration) {
+	if len(q.sysEx) > 0 {
+		q.push(0xF0, pack(q.sysEx), at)
+	}
+	q.sysEx, q.inSysEx = q.sysEx[:0], false
+}
+
+// Buffers an event unless the filters or channel mask drop its status.
+func (q *eventQueue) push(status byte, message uint32, at time.Duration) {
+	if q.dropped(status) {
+		return
+	}
+	size := q.size
+	if size == 0 {
+		size = DefaultBufferSize
+	}
+	if len(q.events) >= size {
+		q.overflowed = true
+		return
+	}
+	q.events = append(q.events, event{message, at})
+	q.notify()
+}
+
+// Signals that there may be something to read, without blocking, as one
+// signal is enough to wake a reader. Must be called with q locked.
+func (q *eventQueue) notify() {
+	if q.ready == nil {
+		q.ready = make(chan bool, 1)
+	}
+	select {
+	case q.ready <- true:
+	default:
+	}
+}
+
+// Returns a channel that receives when there may be an event or error to
+// read, once for any number of them.
+func (q *eventQueue) readyChan() <-chan bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.ready == nil {
+		q.ready = make(chan bool, 1)
+	}
+	return q.ready
+}
+
+// The filter flags have a bit for each status: channel messages from bit 0x18
+// (note off) and system messages from bit 0 (SysEx).
+func (q *eventQueue) dropped(status byte) bool {
+	if status >= 0xF0 {
+		return q.filters&(1<<(status&0x0F)) != 0
+	}
+	return q.filters&(1<<(0x10+status>>4)) != 0 || q.droppedChannels&(1<<(status&0x0F)) != 0
+}
+
+// Reports that events were lost before they reached the queue, as by an overflow.
+func (q *eventQueue) lost() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.overflowed = true
+	q.notify()
+}
+
+// Ends the stream with an error, e.g. when its device is unplugged.
+func (q *eventQueue) fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.notify()
+}
+
+func (q *eventQueue) setFilter(filters int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.filters = filters
+}
+
+func (q *eventQueue) setChannelMask(mask int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.droppedChannels = ^mask & 0xFFFF
+}
+
+// Reports whether an event is available, or ErrBufferOverflow or the error
+// that ended the stream.
+func (q *eventQueue) poll() (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.overflowed {
+		return false, ErrBufferOverflow
+	}
+	if len(q.events) == 0 && q.err != nil {
+		return false, q.err
+	}
+	return len(q.events) > 0, nil
+}
+
+// Returns the next event, if there is one, or ErrBufferOverflow once if events
+// were dropped, or the error that ended the stream once every event is read.
+func (q *eventQueue) read() (e event, ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.overflowed {
+		q.overflowed = false
+		return e, false, ErrBufferOverflow
+	}
+	if len(q.events) == 0 {
+		return e, false, q.err
+	}
+	e = q.events[0]
+	q.events = q.events[1:]
+	return e, true, nil
+}
+
+// Moves as many events as are available and fit into events, returning how
+// many, or ErrBufferOverflow once if events were dropped, or the error that
+// ended the stream once every event is read.
+func (q *eventQueue) readEvents(events []Event) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.overflowed {
+		q.overflowed = false
+		return 0, ErrBufferOverflow
+	}
+	if len(q.events) == 0 {
+		return 0, q.err
+	}
+	n := len(events)
+	if len(q.events) < n {
+		n = len(q.events)
+	}
+	for i, e := range q.events[:n] {
+		events[i] = Event{e.message, e.timestamp}
+	}
+	q.events = q.events[n:]
+	return n, nil
+}
+
+// Packs up to 4 bytes into a message as PortMidi does, the first in the lowest byte.
+func pack(b []byte) uint32 {
+	var message uint32
+	for i, c := range b {
+		message |= uint32(c) << (8 * i)
+	}
+	return message
+}
+
+// Returns the bytes of a message packed as PortMidi does, or nil if its status
+// is not of a message with a fixed length.
+func unpack(message uint32) []byte {
+	n := messageLength(byte(message))
+	if n == 0 {
+		return nil
+	}
+	return []byte{byte(message), byte(message >> 8), byte(message >> 16)}[:n]
+}
+
+// Returns the length of a message with the status, or 0 for a status of no
+// fixed length (SysEx), an undefined status, or a data byte.
+func messageLength(status byte) int {
+	switch {
+	case status < 0x80:
+		return 0
+	case status < 0xF0:
+		if status&0xF0 == 0xC0 || status&0xF0 == 0xD0 {
+			return 2
+		}
+		return 3
+	}
+	switch status {
+	case 0xF1, 0xF3:
+		return 2
+	case 0xF2:
+		return 3
+	case 0xF6, 0xF8, 0xFA, 0xFB, 0xFC, 0xFE, 0xFF:
+		return 1
+	}
+	return 0
+}
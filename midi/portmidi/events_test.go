@@ -0,0 +1,171 @@
+package portmidi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns the messages of the events that can be read from the queue.
+func readAll(q *eventQueue) (messages []uint32) {
+	for {
+		e, ok, _ := q.read()
+		if !ok {
+			return messages
+		}
+		messages = append(messages, e.message)
+	}
+}
+
+func TestEventQueueReceive(t *testing.T) {
+	tests := []struct {
+		description string
+		received    [][]byte
+		expected    []uint32
+	}{
+		{"messages", [][]byte{{0x90, 60, 100, 0xC1, 5}}, []uint32{0x643C90, 0x05C1}},
+		{"running status", [][]byte{{0x90, 60, 100, 62, 0}}, []uint32{0x643C90, 0x003E90}},
+		{"a message split across reads", [][]byte{{0xB0, 7}, {127}}, []uint32{0x7F07B0}},
+		{"a real-time message within a message", [][]byte{{0x90, 60, 0xF8, 100}}, []uint32{0xF8, 0x643C90}},
+		{"a SysEx message", [][]byte{{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}}, []uint32{0x067F7EF0, 0xF701}},
+		{"a real-time message within a SysEx message", [][]byte{{0xF0, 0x7D, 0xFE, 0xF7}}, []uint32{0xFE, 0xF77DF0}},
+		{"an unterminated SysEx message", [][]byte{{0xF0, 0x7D, 0x90, 60, 100}}, []uint32{0x7DF0, 0x643C90}},
+		{"no running status for system common messages", [][]byte{{0xF3, 1, 2}}, []uint32{0x01F3}},
+		{"data bytes without a status", [][]byte{{60, 100, 0xF4, 1}}, nil},
+	}
+	for _, test := range tests {
+		var q eventQueue
+		for _, b := range test.received {
+			q.receive(b, 0)
+		}
+		if actual := readAll(&q); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Read %X instead of %X from %v", actual, test.expected, test.description)
+		}
+	}
+}
+
+func TestEventQueueFilters(t *testing.T) {
+	var q eventQueue
+	q.setFilter(FilterClock | FilterSysEx | FilterNote)
+	q.setChannelMask(1 << 2)
+	q.receive([]byte{0xF8, 0xF0, 0x7D, 0xF7, 0x92, 60, 100, 0xB2, 7, 100, 0xB3, 7, 100, 0xFA}, 0)
+	expected := []uint32{0x6407B2, 0xFA}
+	if actual := readAll(&q); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Read %X instead of %X", actual, expected)
+	}
+}
+
+func TestEventQueueOverflow(t *testing.T) {
+	var q eventQueue
+	for i := 0; i <= DefaultBufferSize; i++ {
+		q.receive([]byte{0xF8}, time.Duration(i))
+	}
+	if _, err := q.poll(); err != ErrBufferOverflow {
+		t.Errorf("Polled %v instead of an overflow", err)
+	}
+	if _, _, err := q.read(); err != ErrBufferOverflow {
+		t.Errorf("Read %v instead of an overflow", err)
+	}
+	e, ok, err := q.read()
+	if !ok || err != nil || e.timestamp != 0 {
+		t.Errorf("Read (%v, %v, %v) instead of the first event after an overflow", e, ok, err)
+	}
+}
+
+func TestEventQueueSize(t *testing.T) {
+	var q eventQueue
+	q.reset(2)
+	q.receive([]byte{0xF8, 0xF8}, 0)
+	if _, err := q.poll(); err != nil {
+		t.Errorf("Polled %v with the buffer full", err)
+	}
+	q.receive([]byte{0xF8}, 0)
+	if _, err := q.poll(); err != ErrBufferOverflow {
+		t.Errorf("Polled %v instead of an overflow past the buffer size", err)
+	}
+}
+
+func TestEventQueueFail(t *testing.T) {
+	var q eventQueue
+	q.receive([]byte{0xFC}, 0)
+	q.fail(ErrBufferOverflow)
+	if ok, err := q.poll(); !ok || err != nil {
+		t.Errorf("Polled (%v, %v) instead of the event received before failing", ok, err)
+	}
+	q.read()
+	if _, ok, err := q.read(); ok || err == nil {
+		t.Errorf("Read (%v, %v) instead of the error once every event was read", ok, err)
+	}
+}
+
+func TestEventQueueReady(t *testing.T) {
+	var q eventQueue
+	ready := q.readyChan()
+	q.receive([]byte{0x90, 60}, 0)
+	select {
+	case <-ready:
+		t.Error("Signalled ready for part of a message")
+	default:
+	}
+	q.receive([]byte{100, 0x80, 60, 0}, 0)
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("Didn't signal ready for the messages received")
+	}
+	select {
+	case <-ready:
+		t.Error("Signalled ready more than once for messages received together")
+	default:
+	}
+	q.fail(ErrBufferOverflow)
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Error("Didn't signal ready for the stream failing")
+	}
+}
+
+func TestEventQueueReadEvents(t *testing.T) {
+	var q eventQueue
+	q.receive([]byte{0x90, 60, 100, 62, 100, 64, 100}, time.Millisecond)
+	events := make([]Event, 2)
+	n, err := q.readEvents(events)
+	if n != 2 || err != nil {
+		t.Fatalf("Read (%d, %v) instead of 2 events", n, err)
+	}
+	expected := []Event{{0x643C90, time.Millisecond}, {0x643E90, time.Millisecond}}
+	for i, e := range expected {
+		if events[i] != e {
+			t.Errorf("Read %v instead of %v", events[i], e)
+		}
+	}
+	if n, err := q.readEvents(events); n != 1 || err != nil || events[0].Message != 0x644090 {
+		t.Errorf("Read (%d, %v, %v) instead of the last event", n, err, events[0])
+	}
+	if n, err := q.readEvents(events); n != 0 || err != nil {
+		t.Errorf("Read (%d, %v) from an empty queue", n, err)
+	}
+	q.lost()
+	if _, err := q.readEvents(events); err != ErrBufferOverflow {
+		t.Errorf("Read %v instead of ErrBufferOverflow", err)
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	tests := []struct {
+		message  uint32
+		expected []byte
+	}{
+		{0x643C90, []byte{0x90, 60, 100}},
+		{0x05C1, []byte{0xC1, 5}},
+		{0xF8, []byte{0xF8}},
+		{0xF0, nil},
+		{0x3C, nil},
+	}
+	for _, test := range tests {
+		if actual := unpack(test.message); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Unpacked 0x%X to % X instead of % X", test.message, actual, test.expected)
+		}
+	}
+}
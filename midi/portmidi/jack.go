@@ -0,0 +1,460 @@
+//go:build jack
+
+package portmidi
+
+/*
+#cgo LDFLAGS: -ljack
+#include <jack/jack.h>
+#include <jack/midiport.h>
+#include <jack/ringbuffer.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+#define MAX_STREAMS 64
+#define BUFFER_SIZE 65536
+
+// Precedes the data of each event in a stream's ringbuffer.
+typedef struct {
+	jack_nframes_t frame; // When the event is sent or was received.
+	uint32_t size;
+	uint32_t immediate; // Sent at the start of the next cycle, ignoring the frame.
+} event_header;
+
+// A port registered for a stream. The process callback only uses it while it
+// is active, and the ringbuffer passes events to or from Go without locking.
+typedef struct {
+	jack_port_t *port;
+	jack_ringbuffer_t *buffer;
+	int is_input;
+	volatile int active;
+	volatile int overflowed;
+} stream;
+
+static stream streams[MAX_STREAMS];
+
+// Copies the events received on input ports into their ringbuffers, and
+// writes the events due in this cycle from output ports' ringbuffers at
+// their frames within it.
+static int process(jack_nframes_t nframes, void *arg) {
+	jack_client_t *client = arg;
+	jack_nframes_t start = jack_last_frame_time(client);
+	for (int i = 0; i < MAX_STREAMS; i++) {
+		stream *s = &streams[i];
+		if (!s->active) {
+			continue;
+		}
+		void *buf = jack_port_get_buffer(s->port, nframes);
+		if (s->is_input) {
+			jack_nframes_t n = jack_midi_get_event_count(buf);
+			for (jack_nframes_t j = 0; j < n; j++) {
+				jack_midi_event_t e;
+				if (jack_midi_event_get(&e, buf, j) != 0) {
+					continue;
+				}
+				event_header h = {start + e.time, e.size, 0};
+				if (jack_ringbuffer_write_space(s->buffer) < sizeof h + e.size) {
+					s->overflowed = 1;
+					continue;
+				}
+				jack_ringbuffer_write(s->buffer, (const char *)&h, sizeof h);
+				jack_ringbuffer_write(s->buffer, (const char *)e.buffer, e.size);
+			}
+			continue;
+		}
+		jack_midi_clear_buffer(buf);
+		jack_nframes_t last = 0;
+		event_header h;
+		while (jack_ringbuffer_peek(s->buffer, (char *)&h, sizeof h) == sizeof h) {
+			int32_t offset = (int32_t)(h.frame - start);
+			if (!h.immediate && offset >= (int32_t)nframes) {
+				break; // Due in a later cycle.
+			}
+			if (h.immediate || offset < (int32_t)last) {
+				offset = last; // Late events are sent now, keeping events in order.
+			}
+			jack_ringbuffer_read_advance(s->buffer, sizeof h);
+			jack_midi_data_t *data = jack_midi_event_reserve(buf, offset, h.size);
+			if (data != NULL) {
+				jack_ringbuffer_read(s->buffer, (char *)data, h.size);
+			} else {
+				jack_ringbuffer_read_advance(s->buffer, h.size);
+			}
+			last = offset;
+		}
+	}
+	return 0;
+}
+
+static jack_client_t *open_client(const char *name) {
+	jack_status_t status;
+	jack_client_t *client = jack_client_open(name, JackNoStartServer, &status);
+	if (client == NULL) {
+		return NULL;
+	}
+	jack_set_process_callback(client, process, client);
+	if (jack_activate(client) != 0) {
+		jack_client_close(client);
+		return NULL;
+	}
+	return client;
+}
+
+// Registers a port for a stream in a free slot, returning the slot or -1.
+static int register_stream(jack_client_t *client, const char *name, int is_input) {
+	for (int i = 0; i < MAX_STREAMS; i++) {
+		stream *s = &streams[i];
+		if (s->port != NULL) {
+			continue;
+		}
+		s->port = jack_port_register(client, name, JACK_DEFAULT_MIDI_TYPE, is_input ? JackPortIsInput : JackPortIsOutput, 0);
+		if (s->port == NULL) {
+			return -1;
+		}
+		s->buffer = jack_ringbuffer_create(BUFFER_SIZE);
+		s->is_input = is_input;
+		s->overflowed = 0;
+		__sync_synchronize();
+		s->active = 1;
+		return i;
+	}
+	return -1;
+}
+
+// Unregisters a stream's port once the process callback can no longer be using it.
+static void unregister_stream(jack_client_t *client, int i) {
+	stream *s = &streams[i];
+	s->active = 0;
+	__sync_synchronize();
+	jack_port_unregister(client, s->port);
+	jack_nframes_t period = jack_get_buffer_size(client);
+	jack_nframes_t rate = jack_get_sample_rate(client);
+	if (rate > 0) {
+		struct timespec wait = {0, 2 * 1000000000LL * period / rate};
+		nanosleep(&wait, NULL);
+	}
+	jack_ringbuffer_free(s->buffer);
+	s->buffer = NULL;
+	s->port = NULL;
+}
+
+// Reads the next event received by an input stream into buf, returning its
+// size, 0 if there is none, or -1 if it is larger than buf (and dropped.)
+static int read_event(int i, jack_nframes_t *frame, unsigned char *buf, uint32_t size) {
+	stream *s = &streams[i];
+	event_header h;
+	if (jack_ringbuffer_read_space(s->buffer) < sizeof h) {
+		return 0;
+	}
+	jack_ringbuffer_read(s->buffer, (char *)&h, sizeof h);
+	*frame = h.frame;
+	if (h.size > size) {
+		jack_ringbuffer_read_advance(s->buffer, h.size);
+		return -1;
+	}
+	jack_ringbuffer_read(s->buffer, (char *)buf, h.size);
+	return h.size;
+}
+
+// Reports whether events were lost, as an input stream's ringbuffer was full.
+static int take_overflow(int i) {
+	int overflowed = streams[i].overflowed;
+	streams[i].overflowed = 0;
+	return overflowed;
+}
+
+// Queues an event for an output stream to send at the frame, or immediately.
+static int write_event(int i, jack_nframes_t frame, int immediate, const unsigned char *data, uint32_t size) {
+	stream *s = &streams[i];
+	event_header h = {frame, size, immediate};
+	if (jack_ringbuffer_write_space(s->buffer) < sizeof h + size) {
+		return -1;
+	}
+	jack_ringbuffer_write(s->buffer, (const char *)&h, sizeof h);
+	jack_ringbuffer_write(s->buffer, (const char *)data, size);
+	return 0;
+}
+
+// Returns how many microseconds ago the frame was.
+static long long frame_age(jack_client_t *client, jack_nframes_t frame) {
+	return (long long)(jack_get_time() - jack_frames_to_time(client, frame));
+}
+
+// Returns the frame that is the given microseconds from now.
+static jack_nframes_t frame_in(jack_client_t *client, long long us) {
+	return jack_time_to_frames(client, jack_get_time() + us);
+}
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+var system backend = &jack{}
+
+// How often an input stream's events are passed to its queue.
+const jackPollInterval = time.Millisecond
+
+// The JACK audio connection kit, whose streams are the MIDI ports of the
+// other clients in the session graph: their output ports are input streams
+// and their input ports output streams. Opening a stream registers a port of
+// this process's client, named after the program, and connects it.
+type jack struct {
+	mu     sync.Mutex
+	client *C.jack_client_t
+	ports  []string // Full port names, by stream ID.
+}
+
+var errNoJACKServer = errors.New("Could not connect to a JACK server")
+
+// Opens the client, unless it has been already. Must be called with mu locked.
+func (j *jack) open() error {
+	if j.client != nil {
+		return nil
+	}
+	name := C.CString(filepath.Base(os.Args[0]))
+	defer C.free(unsafe.Pointer(name))
+	if j.client = C.open_client(name); j.client == nil {
+		return errNoJACKServer
+	}
+	return nil
+}
+
+func (j *jack) streams() ([]StreamInfo, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.ports = nil
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	var infos []StreamInfo
+	for _, direction := range []C.ulong{C.JackPortIsOutput, C.JackPortIsInput} {
+		for _, name := range j.midiPorts(direction) {
+			infos = append(infos, StreamInfo{
+				IsInput:   direction == C.JackPortIsOutput,
+				IsOutput:  direction == C.JackPortIsInput,
+				Name:      name,
+				Interface: "JACK",
+			})
+			j.ports = append(j.ports, name)
+		}
+	}
+	return infos, nil
+}
+
+// Returns the names of other clients' MIDI ports with the flag.
+func (j *jack) midiPorts(flag C.ulong) []string {
+	midiType := C.CString(C.JACK_DEFAULT_MIDI_TYPE)
+	defer C.free(unsafe.Pointer(midiType))
+	list := C.jack_get_ports(j.client, nil, midiType, flag)
+	if list == nil {
+		return nil
+	}
+	defer C.jack_free(unsafe.Pointer(list))
+	var names []string
+	for p := list; *p != nil; p = (**C.char)(unsafe.Add(unsafe.Pointer(p), unsafe.Sizeof(*p))) {
+		if C.jack_port_is_mine(j.client, C.jack_port_by_name(j.client, *p)) == 0 {
+			names = append(names, C.GoString(*p))
+		}
+	}
+	return names
+}
+
+// Returns the name of the other client's port that a stream is of, and the
+// name of the port registered to connect to it.
+func (j *jack) port(deviceID int) (theirs, ours string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	theirs = j.ports[deviceID]
+	return theirs, strings.NewReplacer(":", "_", " ", "_").Replace(theirs)
+}
+
+// Registers a port for a stream and connects it to the other client's port
+// theirs, unless that is empty, as for a virtual port.
+func (j *jack) register(ours, theirs string, input bool) (slot C.int, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.open(); err != nil {
+		return -1, err
+	}
+	name := C.CString(ours)
+	defer C.free(unsafe.Pointer(name))
+	isInput := C.int(0)
+	if input {
+		isInput = 1
+	}
+	if slot = C.register_stream(j.client, name, isInput); slot < 0 {
+		return -1, fmt.Errorf("Could not register JACK port %q", ours)
+	}
+	if theirs == "" {
+		return slot, nil
+	}
+	source, destination := C.CString(theirs), C.jack_port_name(C.streams[slot].port)
+	if !input {
+		source, destination = C.jack_port_name(C.streams[slot].port), C.CString(theirs)
+	}
+	code := C.jack_connect(j.client, source, destination)
+	if input {
+		C.free(unsafe.Pointer(source))
+	} else {
+		C.free(unsafe.Pointer(destination))
+	}
+	if code != 0 && code != C.EEXIST {
+		C.unregister_stream(j.client, slot)
+		return -1, fmt.Errorf("Could not connect to JACK port %q", theirs)
+	}
+	return slot, nil
+}
+
+func (j *jack) openInput(deviceID int, q *eventQueue) (io.Closer, error) {
+	theirs, ours := j.port(deviceID)
+	return j.openInputPort(ours, theirs, q)
+}
+
+func (j *jack) openOutput(deviceID int) (io.WriteCloser, error) {
+	theirs, ours := j.port(deviceID)
+	return j.openOutputPort(ours, theirs)
+}
+
+// Virtual ports are ports of the client, named name, left for the session to connect.
+func (j *jack) openVirtualInput(name string, q *eventQueue) (io.Closer, error) {
+	return j.openInputPort(name, "", q)
+}
+
+func (j *jack) openVirtualOutput(name string) (io.WriteCloser, error) {
+	return j.openOutputPort(name, "")
+}
+
+func (j *jack) openInputPort(ours, theirs string, q *eventQueue) (io.Closer, error) {
+	slot, err := j.register(ours, theirs, true)
+	if err != nil {
+		return nil, err
+	}
+	in := &jackInput{jack: j, slot: slot, done: make(chan bool), stopped: make(chan bool)}
+	go in.read(q)
+	return in, nil
+}
+
+func (j *jack) openOutputPort(ours, theirs string) (io.WriteCloser, error) {
+	slot, err := j.register(ours, theirs, false)
+	if err != nil {
+		return nil, err
+	}
+	return &jackOutput{jack: j, slot: slot}, nil
+}
+
+func (j *jack) unregister(slot C.int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	C.unregister_stream(j.client, slot)
+}
+
+func (j *jack) terminate() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.client == nil {
+		return nil
+	}
+	code := C.jack_client_close(j.client)
+	j.client = nil
+	if code != 0 {
+		return fmt.Errorf("Could not close the JACK client: error %d", int(code))
+	}
+	return nil
+}
+
+type jackInput struct {
+	jack    *jack
+	slot    C.int
+	done    chan bool // Closed to stop reading.
+	stopped chan bool // Closed once reading has stopped.
+}
+
+// Passes the events received to the queue, timestamped by the frames they
+// were received at, until closed.
+func (in *jackInput) read(q *eventQueue) {
+	defer close(in.stopped)
+	buf := make([]byte, C.BUFFER_SIZE)
+	ticker := time.NewTicker(jackPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-in.done:
+			return
+		case <-ticker.C:
+		}
+		if C.take_overflow(in.slot) != 0 {
+			q.lost()
+		}
+		for {
+			var frame C.jack_nframes_t
+			n := C.read_event(in.slot, &frame, (*C.uchar)(unsafe.Pointer(&buf[0])), C.uint32_t(len(buf)))
+			if n == 0 {
+				break
+			}
+			if n < 0 {
+				q.lost()
+				continue
+			}
+			ago := time.Duration(C.frame_age(in.jack.client, frame)) * time.Microsecond
+			q.receive(buf[:n], Time()-ago)
+		}
+	}
+}
+
+func (in *jackInput) Close() error {
+	close(in.done)
+	<-in.stopped
+	in.jack.unregister(in.slot)
+	return nil
+}
+
+type jackOutput struct {
+	jack *jack
+	slot C.int
+	mu   sync.Mutex // Writes are made from one goroutine at a time, as the ringbuffer requires.
+}
+
+// Writes a message to be sent at the start of the next cycle.
+func (out *jackOutput) Write(b []byte) (int, error) {
+	if err := out.queue(b, 0, true); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Sends a message at the frame of the timestamp, on the clock returned by Time,
+// or at the start of the next cycle if that has passed.
+func (out *jackOutput) writeAt(b []byte, timestamp time.Duration) error {
+	frame := C.frame_in(out.jack.client, C.longlong((timestamp-Time())/time.Microsecond))
+	return out.queue(b, frame, false)
+}
+
+func (out *jackOutput) queue(b []byte, frame C.jack_nframes_t, immediate bool) error {
+	if len(b) == 0 {
+		return nil
+	}
+	flag := C.int(0)
+	if immediate {
+		flag = 1
+	}
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if C.write_event(out.slot, frame, flag, (*C.uchar)(unsafe.Pointer(&b[0])), C.uint32_t(len(b))) != 0 {
+		return ErrBufferOverflow
+	}
+	return nil
+}
+
+func (out *jackOutput) Close() error {
+	out.jack.unregister(out.slot)
+	return nil
+}
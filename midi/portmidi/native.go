@@ -0,0 +1,531 @@
+//go:build alsa || coremidi || winmm || rawmidi || jack || rtmidi
+
+package portmidi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A system MIDI API that streams are opened with in place of PortMidi,
+// chosen by build tag. Input and Output give its streams PortMidi's behaviour.
+type backend interface {
+	// Returns the system's streams, whose indices are their IDs until the
+	// streams are next returned.
+	streams() ([]StreamInfo, error)
+	// Opens an input stream, which passes the bytes it receives to the queue
+	// (or fails it) until closed.
+	openInput(deviceID int, q *eventQueue) (io.Closer, error)
+	// Opens an output stream, each write to which is of whole messages.
+	openOutput(deviceID int) (io.WriteCloser, error)
+}
+
+// Implemented by backends that can create virtual ports, which other programs
+// see as MIDI devices: an input port receives what they send to it, and what
+// is written to an output port is sent to the programs connected to it.
+type virtualizer interface {
+	openVirtualInput(name string, q *eventQueue) (io.Closer, error)
+	openVirtualOutput(name string) (io.WriteCloser, error)
+}
+
+// Implemented by output streams that send messages at their timestamps,
+// rather than as they are written.
+type timedWriter interface {
+	writeAt(b []byte, timestamp time.Duration) error
+}
+
+// Implemented by backends that hold resources of the system until terminated.
+type terminator interface {
+	terminate() error
+}
+
+var errNotOpen = errors.New("Stream is not open")
+
+// The system's streams, listed by Initialize or when first needed, as PortMidi does.
+var streams struct {
+	sync.Mutex
+	infos  []StreamInfo
+	opened map[int]io.Closer // The Input or Output each open stream was opened by, by ID.
+	listed bool
+}
+
+// Lists the streams, moving each open stream to its ID in the new list, or
+// forgetting it if it's no longer listed. Must be called with streams locked.
+func listStreams() error {
+	infos, err := system.streams()
+	opened := make(map[int]io.Closer)
+	ids := make([]int, 0, len(streams.opened))
+	for id := range streams.opened {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		for newID, info := range infos {
+			if _, ok := opened[newID]; !ok && info == streams.infos[id] {
+				opened[newID] = streams.opened[id]
+				break
+			}
+		}
+	}
+	streams.infos, streams.opened, streams.listed = infos, opened, true
+	return err
+}
+
+// Lists the system's streams, again if they were already, so that those
+// added or removed since are. Streams already open stay open, but the IDs of
+// others may change.
+func Initialize() error {
+	streams.Lock()
+	defer streams.Unlock()
+	return listStreams()
+}
+
+// Refresh lists the system's streams again, as Initialize does with this backend.
+func Refresh() error {
+	return Initialize()
+}
+
+// The time that streams are timestamped relative to, unless a TimeSource is set.
+var start = time.Now()
+
+// Time returns the time on the clock, or the TimeSource set, that every stream
+// opened by this package uses to timestamp MIDI data, so the times of different streams are comparable.
+func Time() time.Duration {
+	if t, ok := sourceTime(); ok {
+		return t
+	}
+	return time.Since(start)
+}
+
+func Terminate() error {
+	if t, ok := system.(terminator); ok {
+		return t.terminate()
+	}
+	return nil
+}
+
+func NumStreams() int {
+	streams.Lock()
+	defer streams.Unlock()
+	if !streams.listed {
+		listStreams()
+	}
+	return len(streams.infos)
+}
+
+// Returns an empty StreamInfo for an ID that isn't one of the system's streams.
+func NewStreamInfo(deviceID int) *StreamInfo {
+	streams.Lock()
+	defer streams.Unlock()
+	if deviceID < 0 || deviceID >= len(streams.infos) {
+		return &StreamInfo{}
+	}
+	info := streams.infos[deviceID]
+	_, info.IsOpen = streams.opened[deviceID]
+	return &info
+}
+
+// Marks a stream as opened by the Input or Output, returning an error if it is
+// already open or isn't one of the system's streams in the direction.
+func openStream(deviceID int, input bool, by io.Closer) error {
+	streams.Lock()
+	defer streams.Unlock()
+	if !streams.listed {
+		listStreams()
+	}
+	if n := len(streams.infos); deviceID < 0 || deviceID >= n {
+		return fmt.Errorf("Invalid device ID %d: there are %d devices", deviceID, n)
+	}
+	if info := streams.infos[deviceID]; input && !info.IsInput || !input && !info.IsOutput {
+		return fmt.Errorf("Invalid device ID %d: %q is not an %s", deviceID, info.Name, direction(input))
+	}
+	if _, ok := streams.opened[deviceID]; ok {
+		return fmt.Errorf("Device %d is already open", deviceID)
+	}
+	streams.opened[deviceID] = by
+	return nil
+}
+
+// Marks the stream opened by the Input or Output as closed, wherever it was
+// moved to when the streams were last listed.
+func closeStream(by io.Closer) {
+	streams.Lock()
+	defer streams.Unlock()
+	for id, opener := range streams.opened {
+		if opener == by {
+			delete(streams.opened, id)
+		}
+	}
+}
+
+func direction(input bool) string {
+	if input {
+		return "input"
+	}
+	return "output"
+}
+
+type Output struct {
+	// The number of messages PortMidi buffers before they are written. Only
+	// PortMidi has a buffer of that size, so it is unused by this backend.
+	BufferSize int
+	// Added to the timestamps of messages written to get the time they are
+	// sent at. JACK and CoreMIDI send them at that time themselves; other
+	// backends queue them until then, so are only as precise as the Go
+	// scheduler. Messages written with no timestamp are sent immediately.
+	// Set before the stream is opened.
+	Latency  time.Duration
+	deviceID int
+	stream   io.WriteCloser
+	virtual  string // The name of a virtual port, created when opened.
+}
+
+func NewOutput(deviceID int) *Output {
+	return &Output{deviceID: deviceID}
+}
+
+// NewVirtualOutput returns an output stream that, once opened, other programs
+// see as a MIDI device named name that they can receive from. Opening it
+// creates the device and closing it deletes it.
+func NewVirtualOutput(name string) *Output {
+	return &Output{deviceID: -1, virtual: name}
+}
+
+func (o *Output) Open() error {
+	if o.virtual != "" {
+		v, ok := system.(virtualizer)
+		if !ok {
+			return ErrNoVirtualPorts
+		}
+		if o.stream != nil {
+			return fmt.Errorf("Virtual device %q is already open", o.virtual)
+		}
+		stream, err := v.openVirtualOutput(o.virtual)
+		if err == nil {
+			o.stream = o.scheduled(stream)
+		}
+		return err
+	}
+	if err := openStream(o.deviceID, false, o); err != nil {
+		return err
+	}
+	stream, err := system.openOutput(o.deviceID)
+	if err != nil {
+		closeStream(o)
+		return err
+	}
+	o.stream = o.scheduled(stream)
+	return nil
+}
+
+// Returns the stream, queueing the messages written to it until they are due
+// if it sends them as they are written and there is a Latency.
+func (o *Output) scheduled(stream io.WriteCloser) io.WriteCloser {
+	if _, ok := stream.(timedWriter); ok || o.Latency == 0 {
+		return stream
+	}
+	return &scheduledStream{WriteCloser: stream}
+}
+
+func (o *Output) Close() error {
+	if o.stream == nil {
+		return errNotOpen
+	}
+	err := o.stream.Close()
+	o.stream = nil
+	closeStream(o)
+	return err
+}
+
+func (o Output) Write(u Uint32er) error {
+	return o.WriteAt(u, 0)
+}
+
+// WriteAt writes a message timestamped with a time on the clock returned by
+// Time, to be sent at that time plus the Latency. Without a Latency, it is
+// written immediately, as with PortMidi, unless the backend sends messages at
+// their timestamps (JACK and CoreMIDI).
+func (o Output) WriteAt(u Uint32er, timestamp time.Duration) error {
+	b := unpack(u.Uint32())
+	if b == nil {
+		return fmt.Errorf("Invalid status byte 0x%02X", byte(u.Uint32()))
+	}
+	return o.write(b, timestamp)
+}
+
+// WriteEvents writes short messages, each timestamped as by WriteAt, in
+// order, joining those sent immediately into a single write to the stream.
+// SysEx messages must be written with WriteSysEx.
+func (o Output) WriteEvents(events []Event) error {
+	if o.stream == nil {
+		return errNotOpen
+	}
+	_, timed := o.stream.(timedWriter)
+	var b []byte
+	for _, e := range events {
+		m := unpack(e.Message)
+		if m == nil {
+			return fmt.Errorf("Invalid status byte 0x%02X", byte(e.Message))
+		}
+		if e.Timestamp == 0 || !timed && o.Latency == 0 {
+			b = append(b, m...)
+			continue
+		}
+		if err := o.writeNow(b); err != nil {
+			return err
+		}
+		b = b[:0]
+		if err := o.write(m, e.Timestamp); err != nil {
+			return err
+		}
+	}
+	return o.writeNow(b)
+}
+
+func (o Output) writeNow(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := o.stream.Write(b)
+	return err
+}
+
+// WriteSysEx writes a system exclusive message, which must end with 0xF7.
+func (o Output) WriteSysEx(msg []byte) error {
+	return o.WriteSysExAt(msg, 0)
+}
+
+// WriteSysExAt writes a system exclusive message, which must end with 0xF7,
+// timestamped as by WriteAt.
+func (o Output) WriteSysExAt(msg []byte, timestamp time.Duration) error {
+	if len(msg) == 0 || msg[len(msg)-1] != 0xF7 {
+		return errors.New("SysEx message is not terminated by 0xF7")
+	}
+	return o.write(msg, timestamp)
+}
+
+func (o Output) write(b []byte, timestamp time.Duration) error {
+	if o.stream == nil {
+		return errNotOpen
+	}
+	if w, ok := o.stream.(timedWriter); ok && timestamp != 0 {
+		return w.writeAt(b, timestamp+o.Latency)
+	}
+	_, err := o.stream.Write(b)
+	return err
+}
+
+// An output stream that queues timestamped messages until they are due, for
+// backends that send messages as they are written, so that a message written
+// early doesn't hold up the writes after it.
+type scheduledStream struct {
+	io.WriteCloser
+	mu      sync.Mutex
+	pending []scheduledWrite // In the order they are due.
+	timer   *time.Timer      // Fires when the first pending message is due.
+	err     error            // From writing a pending message, returned by the next write.
+}
+
+type scheduledWrite struct {
+	b  []byte
+	at time.Duration
+}
+
+func (s *scheduledStream) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.takeErr(); err != nil {
+		return 0, err
+	}
+	return s.WriteCloser.Write(b)
+}
+
+func (s *scheduledStream) writeAt(b []byte, timestamp time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.takeErr(); err != nil {
+		return err
+	}
+	if len(s.pending) == 0 && timestamp <= Time() {
+		_, err := s.WriteCloser.Write(b)
+		return err
+	}
+	// Messages due at the same time are sent in the order they were written.
+	i := sort.Search(len(s.pending), func(i int) bool { return s.pending[i].at > timestamp })
+	s.pending = append(s.pending, scheduledWrite{})
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = scheduledWrite{b: append([]byte(nil), b...), at: timestamp}
+	s.schedule()
+	return nil
+}
+
+// Returns and clears the error from writing a pending message. Must be called
+// with mu locked.
+func (s *scheduledStream) takeErr() error {
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// Sets the timer for the first pending message. Must be called with mu locked.
+func (s *scheduledStream) schedule() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(s.pending[0].at-Time(), s.writeDue)
+}
+
+// Writes the pending messages that are due.
+func (s *scheduledStream) writeDue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := Time()
+	for len(s.pending) > 0 && s.pending[0].at <= now {
+		if _, err := s.WriteCloser.Write(s.pending[0].b); err != nil && s.err == nil {
+			s.err = err
+		}
+		s.pending = s.pending[1:]
+	}
+	if len(s.pending) > 0 {
+		s.schedule()
+	}
+}
+
+// Writes the pending messages immediately, rather than losing them, and closes
+// the stream.
+func (s *scheduledStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	for _, w := range s.pending {
+		s.WriteCloser.Write(w.b)
+	}
+	s.pending = nil
+	return s.WriteCloser.Close()
+}
+
+type Input struct {
+	// The number of events buffered before they are read, or 0 for
+	// DefaultBufferSize. Set before the stream is opened.
+	BufferSize int
+	deviceID   int
+	stream     io.Closer
+	queue      eventQueue
+	virtual    string // The name of a virtual port, created when opened.
+}
+
+func NewInput(deviceID int) *Input {
+	return &Input{deviceID: deviceID}
+}
+
+// NewVirtualInput returns an input stream that, once opened, other programs
+// see as a MIDI device named name that they can send to. Opening it creates
+// the device and closing it deletes it.
+func NewVirtualInput(name string) *Input {
+	return &Input{deviceID: -1, virtual: name}
+}
+
+func (i *Input) Open() error {
+	if i.virtual != "" {
+		v, ok := system.(virtualizer)
+		if !ok {
+			return ErrNoVirtualPorts
+		}
+		if i.stream != nil {
+			return fmt.Errorf("Virtual device %q is already open", i.virtual)
+		}
+		i.queue.reset(i.BufferSize)
+		stream, err := v.openVirtualInput(i.virtual, &i.queue)
+		if err == nil {
+			i.stream = stream
+		}
+		return err
+	}
+	if err := openStream(i.deviceID, true, i); err != nil {
+		return err
+	}
+	i.queue.reset(i.BufferSize)
+	stream, err := system.openInput(i.deviceID, &i.queue)
+	if err != nil {
+		closeStream(i)
+		return err
+	}
+	i.stream = stream
+	return nil
+}
+
+func (i *Input) Close() error {
+	if i.stream == nil {
+		return errNotOpen
+	}
+	err := i.stream.Close()
+	i.stream = nil
+	closeStream(i)
+	return err
+}
+
+// Poll reports whether input is available, or an error if the stream overflowed or failed.
+func (i *Input) Poll() (dataAvailable bool, err error) {
+	if i.stream == nil {
+		return false, errNotOpen
+	}
+	return i.queue.poll()
+}
+
+// Ready returns a channel that receives when MIDI data or an error may be
+// available to read, to wait on rather than polling. It receives once for any
+// number of events, so read until Poll reports none before waiting again.
+func (i *Input) Ready() <-chan bool {
+	return i.queue.readyChan()
+}
+
+// SetFilter drops the message types in filters before they are read.
+// The stream must be open.
+func (i *Input) SetFilter(filters int) error {
+	if i.stream == nil {
+		return errNotOpen
+	}
+	i.queue.setFilter(filters)
+	return nil
+}
+
+// SetChannelMask drops channel messages on channels whose bit (1 << channel)
+// isn't set in the mask before they are read. The stream must be open.
+func (i *Input) SetChannelMask(mask int) error {
+	if i.stream == nil {
+		return errNotOpen
+	}
+	i.queue.setChannelMask(mask)
+	return nil
+}
+
+// ReadEvents reads the events available, up to as many as fit in events,
+// returning how many were read, or ErrBufferOverflow if messages were lost
+// since the last read, or the error that ended the stream.
+func (i *Input) ReadEvents(events []Event) (int, error) {
+	if i.stream == nil {
+		return 0, errNotOpen
+	}
+	return i.queue.readEvents(events)
+}
+
+func (i *Input) Read() uint32 {
+	message, _, _ := i.ReadEvent()
+	return message
+}
+
+// ReadEvent returns a message with its timestamp, on the clock returned by Time,
+// or ErrBufferOverflow if messages were lost since the last read.
+func (i *Input) ReadEvent() (message uint32, timestamp time.Duration, err error) {
+	if i.stream == nil {
+		return 0, 0, errNotOpen
+	}
+	e, _, err := i.queue.read()
+	return e.message, e.timestamp, err
+}
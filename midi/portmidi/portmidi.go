@@ -1,20 +1,96 @@
+//go:build !alsa && !coremidi && !winmm && !rawmidi && !jack && !rtmidi
+
 package portmidi
 
 // #cgo LDFLAGS: -lportmidi
 // #include <portmidi.h>
+// #include <porttime.h>
+// extern PmTimestamp goTimeProc(void *);
 import "C"
 import (
 	"errors"
+	"fmt"
+	"sync"
+	"time"
 	"unsafe"
 )
 
+const one C.int32_t = 1
+
+// PortMidi return codes.
 const (
-	one        C.int32_t = 1
-	fiveTwelve C.int32_t = 512
+	gotData   = int(C.pmGotData)
+	hostError = int(C.pmHostError)
+	badPtr    = int(C.pmBadPtr)
+	overflow  = int(C.pmBufferOverflow)
+)
+
+// PortMidi calls that are swapped out in tests.
+var (
+	pmPoll = func(stream unsafe.Pointer) int { return int(C.Pm_Poll(stream)) }
+	pmRead = func(stream unsafe.Pointer) (message uint32, timestamp int, n int) {
+		var e C.PmEvent
+		n = int(C.Pm_Read(stream, &e, C.int32_t(1)))
+		return uint32(e.message), int(e.timestamp), n
+	}
+	pmReadEvents = func(stream unsafe.Pointer, events []Event) int {
+		buf := pmEventBuffers.Get().(*[]C.PmEvent)
+		defer pmEventBuffers.Put(buf)
+		if len(*buf) < len(events) {
+			*buf = make([]C.PmEvent, len(events))
+		}
+		n := int(C.Pm_Read(stream, &(*buf)[0], C.int32_t(len(events))))
+		for i := 0; i < n; i++ {
+			e := (*buf)[i]
+			events[i] = Event{uint32(e.message), time.Duration(e.timestamp) * time.Millisecond}
+		}
+		return n
+	}
+	pmWrite = func(stream unsafe.Pointer, message uint32, timestamp int) int {
+		e := C.PmEvent{C.PmMessage(message), C.PmTimestamp(timestamp)}
+		return int(C.Pm_Write(stream, &e, one))
+	}
+	pmWriteEvents = func(stream unsafe.Pointer, events []Event) int {
+		buf := pmEventBuffers.Get().(*[]C.PmEvent)
+		defer pmEventBuffers.Put(buf)
+		if len(*buf) < len(events) {
+			*buf = make([]C.PmEvent, len(events))
+		}
+		for i, e := range events {
+			(*buf)[i] = C.PmEvent{C.PmMessage(e.Message), C.PmTimestamp(e.Timestamp / time.Millisecond)}
+		}
+		return int(C.Pm_Write(stream, &(*buf)[0], C.int32_t(len(events))))
+	}
+	pmSetFilter      = func(stream unsafe.Pointer, filters int) int { return int(C.Pm_SetFilter(stream, C.int32_t(filters))) }
+	pmSetChannelMask = func(stream unsafe.Pointer, mask int) int { return int(C.Pm_SetChannelMask(stream, C.int(mask))) }
+	pmTime           = func() int { return int(C.Pt_Time()) }
+	pmErrorText      = func(errNum int) string { return C.GoString(C.Pm_GetErrorText(C.PmError(errNum))) }
+	pmHostErrorText  = func() string {
+		var msg [256]C.char
+		C.Pm_GetHostErrorText(&msg[0], C.uint(len(msg)))
+		return C.GoString(&msg[0])
+	}
 )
 
+// Buffers that Pm_Read reads events into and Pm_Write writes them from,
+// reused across calls.
+var pmEventBuffers = sync.Pool{New: func() interface{} { return new([]C.PmEvent) }}
+
 func newError(errNum C.PmError) error {
-	msg := C.GoString(C.Pm_GetErrorText(errNum))
+	return errorFromCode(int(errNum))
+}
+
+// Host errors are described by the host (OS) error text rather than PortMidi's.
+func errorFromCode(errNum int) error {
+	if errNum == overflow {
+		return ErrBufferOverflow
+	}
+	if errNum == hostError {
+		if msg := pmHostErrorText(); msg != "" {
+			return errors.New(msg)
+		}
+	}
+	msg := pmErrorText(errNum)
 	if msg == "" {
 		return nil
 	}
@@ -22,50 +98,125 @@ func newError(errNum C.PmError) error {
 }
 
 func Initialize() error {
+	startClock()
 	return newError(C.Pm_Initialize())
 }
 
+// Starts PortMidi's clock (Pt_Time), with millisecond resolution, unless it has been already.
+func startClock() {
+	if C.Pt_Started() == 0 {
+		C.Pt_Start(1, nil, nil)
+	}
+}
+
+// Time returns the time on PortMidi's clock (Pt_Time), or the TimeSource set,
+// which every stream opened by this package uses to timestamp MIDI data, so
+// the times of different streams are comparable.
+func Time() time.Duration {
+	if t, ok := sourceTime(); ok {
+		return t
+	}
+	startClock()
+	return time.Duration(pmTime()) * time.Millisecond
+}
+
 func Terminate() error {
 	return newError(C.Pm_Terminate())
 }
 
-func NumStreams() int {
-	return int(C.Pm_CountDevices())
+// Refresh lists the system's streams again, so that those added or removed
+// since PortMidi was initialized are, by terminating and initializing it.
+// As that would close open streams, it returns ErrStreamsOpen if any are.
+func Refresh() error {
+	for i := 0; i < NumStreams(); i++ {
+		if NewStreamInfo(i).IsOpen {
+			return ErrStreamsOpen
+		}
+	}
+	if err := Terminate(); err != nil {
+		return err
+	}
+	return Initialize()
 }
 
-type Uint32er interface {
-	Uint32() uint32
+func NumStreams() int {
+	return int(C.Pm_CountDevices())
 }
 
-type StreamInfo struct {
-	IsInput  bool
-	IsOutput bool
-	IsOpen   bool
-	Name     string
+// Returns an error for an ID that isn't one of the system's streams, which
+// PortMidi may not check before using it.
+func checkDeviceID(deviceID C.PmDeviceID) error {
+	if n := NumStreams(); deviceID < 0 || int(deviceID) >= n {
+		return fmt.Errorf("Invalid device ID %d: there are %d devices", deviceID, n)
+	}
+	return nil
 }
 
 func NewStreamInfo(deviceID int) *StreamInfo {
 	i := C.Pm_GetDeviceInfo(C.PmDeviceID(deviceID))
 	return &StreamInfo{
-		IsInput:  i.input > 0,
-		IsOutput: i.output > 0,
-		IsOpen:   i.opened > 0,
-		Name:     C.GoString(i.name),
+		IsInput:   i.input > 0,
+		IsOutput:  i.output > 0,
+		IsOpen:    i.opened > 0,
+		Name:      C.GoString(i.name),
+		Interface: C.GoString(i.interf),
 	}
 }
 
+// Returns the time proc to open a stream with: PortMidi's clock, unless a
+// TimeSource is set.
+func timeProc() C.PmTimeProcPtr {
+	if _, ok := sourceTime(); ok {
+		return C.PmTimeProcPtr(C.goTimeProc)
+	}
+	return nil
+}
+
+// Returns the buffer size to open a stream with, DefaultBufferSize if 0.
+func bufferSize(size int) C.int32_t {
+	if size == 0 {
+		return DefaultBufferSize
+	}
+	return C.int32_t(size)
+}
+
 type Output struct {
+	// The number of messages PortMidi buffers before they are written, or 0
+	// for DefaultBufferSize. Set before the stream is opened.
+	BufferSize int
+	// Added to the timestamps of messages written to get the time PortMidi
+	// sends them at, which it may leave to the driver for precise timing.
+	// With none, they are sent immediately. Set before the stream is opened.
+	Latency  time.Duration
 	deviceID C.PmDeviceID
 	stream   unsafe.Pointer
+	virtual  bool
 }
 
 func NewOutput(deviceID int) *Output {
 	return &Output{deviceID: C.PmDeviceID(deviceID)}
 }
 
+// NewVirtualOutput returns an output stream that other programs would see as
+// a MIDI device to receive from, but only PortMidi 2 creates virtual devices,
+// so opening it returns ErrNoVirtualPorts.
+func NewVirtualOutput(name string) *Output {
+	return &Output{deviceID: -1, virtual: true}
+}
+
 // Open makes a C call via portmidi to open an output stream used by input ports.
 func (o *Output) Open() error {
-	return newError(C.Pm_OpenOutput(&(o.stream), o.deviceID, nil, fiveTwelve, nil, nil, 0))
+	if o.virtual {
+		return ErrNoVirtualPorts
+	}
+	if err := checkDeviceID(o.deviceID); err != nil {
+		return err
+	}
+	latency := C.int32_t(o.Latency / time.Millisecond)
+	if latency > 0 {
+		startClock() // PortMidi's clock times the stream without a TimeSource.
+	}
+	return newError(C.Pm_OpenOutput(&(o.stream), o.deviceID, nil, bufferSize(o.BufferSize), timeProc(), nil, latency))
 }
 
 func (o *Output) Close() error {
@@ -73,37 +224,169 @@ func (o *Output) Close() error {
 }
 
 func (o Output) Write(u Uint32er) error {
-	e := C.PmEvent{C.PmMessage(u.Uint32()), 0}
-	return newError(C.Pm_Write(o.stream, &e, one))
+	return o.WriteAt(u, 0)
+}
+
+// WriteAt writes a message timestamped with a time on the clock returned by
+// Time. PortMidi only delays messages until their time plus the Latency when
+// the stream is opened with one; otherwise they are written immediately.
+func (o Output) WriteAt(u Uint32er, timestamp time.Duration) error {
+	return errorFromCode(pmWrite(o.stream, u.Uint32(), int(timestamp/time.Millisecond)))
+}
+
+// WriteEvents writes short messages, each timestamped as by WriteAt, in
+// order in a single call to PortMidi, which is cheaper than writing each
+// when many are written at once. SysEx messages must be written with
+// WriteSysEx.
+func (o Output) WriteEvents(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return errorFromCode(pmWriteEvents(o.stream, events))
+}
+
+// WriteSysEx writes a system exclusive message, which must end with 0xF7.
+func (o Output) WriteSysEx(msg []byte) error {
+	return o.WriteSysExAt(msg, 0)
+}
+
+// WriteSysExAt writes a system exclusive message, which must end with 0xF7,
+// timestamped as by WriteAt.
+func (o Output) WriteSysExAt(msg []byte, timestamp time.Duration) error {
+	if len(msg) == 0 || msg[len(msg)-1] != 0xF7 {
+		return errors.New("SysEx message is not terminated by 0xF7")
+	}
+	ms := C.PmTimestamp(timestamp / time.Millisecond)
+	return newError(C.Pm_WriteSysEx(o.stream, ms, (*C.uchar)(unsafe.Pointer(&msg[0]))))
 }
 
 type Input struct {
-	deviceID C.PmDeviceID
-	stream   unsafe.Pointer
+	// The number of events PortMidi buffers before they are read, or 0 for
+	// DefaultBufferSize. Set before the stream is opened.
+	BufferSize int
+	deviceID   C.PmDeviceID
+	stream     unsafe.Pointer
+	virtual    bool
+	idle       time.Duration // How long Ready waits, growing while no data arrives.
+	ready      chan bool
+	poll       *time.Timer // Sends to ready once the stream is due to be polled.
 }
 
+// How long Ready waits for PortMidi's stream to be polled again: at first the
+// resolution of PortMidi's clock, doubling while no data arrives up to the most.
+const (
+	minPollInterval = time.Millisecond
+	maxPollInterval = 8 * time.Millisecond
+)
+
 func NewInput(deviceID int) *Input {
 	return &Input{deviceID: C.PmDeviceID(deviceID)}
 }
 
+// NewVirtualInput returns an input stream that other programs would see as a
+// MIDI device to send to, but only PortMidi 2 creates virtual devices, so
+// opening it returns ErrNoVirtualPorts.
+func NewVirtualInput(name string) *Input {
+	return &Input{deviceID: -1, virtual: true}
+}
+
 // open makes a C call via portmidi to open an input stream used by output ports.
 func (i *Input) Open() error {
-	return newError(C.Pm_OpenInput(&(i.stream), i.deviceID, nil, fiveTwelve, nil, nil))
+	if i.virtual {
+		return ErrNoVirtualPorts
+	}
+	if err := checkDeviceID(i.deviceID); err != nil {
+		return err
+	}
+	return newError(C.Pm_OpenInput(&(i.stream), i.deviceID, nil, bufferSize(i.BufferSize), timeProc(), nil))
 }
 
 func (i *Input) Close() error {
 	return newError(C.Pm_Close(i.stream))
 }
 
+// Poll reports whether input is available, or an error if PortMidi returned an error code.
 func (i *Input) Poll() (dataAvailable bool, err error) {
-	d, err := C.Pm_Poll(i.stream)
-	return d > 0, err
+	n := pmPoll(i.stream)
+	if n < 0 {
+		return false, errorFromCode(n)
+	}
+	if n == gotData {
+		i.idle = 0
+	}
+	return n == gotData, nil
+}
+
+// Ready returns a channel that receives when MIDI data may be available to
+// read, to wait on rather than polling. PortMidi can only be polled, so it
+// receives after a millisecond, backing off to every 8 milliseconds while the
+// stream stays idle, until data is read again.
+func (i *Input) Ready() <-chan bool {
+	switch {
+	case i.idle < minPollInterval:
+		i.idle = minPollInterval
+	case i.idle < maxPollInterval:
+		i.idle *= 2
+	}
+	if i.poll == nil {
+		i.ready = make(chan bool, 1)
+		i.poll = time.AfterFunc(i.idle, func() {
+			select {
+			case i.ready <- true:
+			default:
+			}
+		})
+	} else {
+		i.poll.Reset(i.idle)
+	}
+	return i.ready
+}
+
+// SetFilter drops the message types in filters before they are read.
+// The stream must be open.
+func (i *Input) SetFilter(filters int) error {
+	return errorFromCode(pmSetFilter(i.stream, filters))
+}
+
+// SetChannelMask drops channel messages on channels whose bit (1 << channel)
+// isn't set in the mask before they are read. The stream must be open.
+func (i *Input) SetChannelMask(mask int) error {
+	return errorFromCode(pmSetChannelMask(i.stream, mask))
+}
+
+// ReadEvents reads the events available, up to as many as fit in events, in a
+// single call to PortMidi, returning how many were read, or ErrBufferOverflow
+// if messages were lost since the last read. Reading many at once is cheaper
+// than calling ReadEvent for each when MIDI data comes in bursts.
+func (i *Input) ReadEvents(events []Event) (int, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+	n := pmReadEvents(i.stream, events)
+	if n < 0 {
+		return 0, errorFromCode(n)
+	}
+	if n > 0 {
+		i.idle = 0
+	}
+	return n, nil
 }
 
 func (i *Input) Read() uint32 {
-	var e C.PmEvent
-	if n := C.Pm_Read(i.stream, &e, C.int32_t(1)); n > 0 {
-		return uint32(e.message)
+	message, _, _ := i.ReadEvent()
+	return message
+}
+
+// ReadEvent returns a message with its timestamp, on the clock returned by Time,
+// or ErrBufferOverflow if messages were lost since the last read.
+func (i *Input) ReadEvent() (message uint32, timestamp time.Duration, err error) {
+	message, ms, n := pmRead(i.stream)
+	if n < 0 {
+		return 0, 0, errorFromCode(n)
+	}
+	if n == 0 {
+		return 0, 0, nil
 	}
-	return 0
+	i.idle = 0
+	return message, time.Duration(ms) * time.Millisecond, nil
 }
@@ -0,0 +1,172 @@
+//go:build !alsa && !coremidi && !winmm && !rawmidi && !jack && !rtmidi
+
+package portmidi
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestPoll(t *testing.T) {
+	defer func(f func(unsafe.Pointer) int) { pmPoll = f }(pmPoll)
+	tests := []struct {
+		code          int
+		dataAvailable bool
+		isError       bool
+	}{
+		{0, false, false},
+		{gotData, true, false},
+		{badPtr, false, true},
+	}
+	for _, test := range tests {
+		pmPoll = func(unsafe.Pointer) int { return test.code }
+		dataAvailable, err := NewInput(0).Poll()
+		if dataAvailable != test.dataAvailable || (err != nil) != test.isError {
+			t.Errorf("Received (%v, %v) from polling with PortMidi code %d", dataAvailable, err, test.code)
+		}
+	}
+}
+
+func TestHostError(t *testing.T) {
+	defer func(f func() string) { pmHostErrorText = f }(pmHostErrorText)
+	expected := "Device was unplugged"
+	pmHostErrorText = func() string { return expected }
+	if err := errorFromCode(hostError); err == nil || err.Error() != expected {
+		t.Errorf("Received %v from a host error instead of %q", err, expected)
+	}
+	if err := errorFromCode(badPtr); err == nil || err.Error() == expected {
+		t.Errorf("Received %v from a PortMidi error instead of its own text", err)
+	}
+	if err := errorFromCode(0); err != nil {
+		t.Errorf("Received %v for no error", err)
+	}
+}
+
+func TestSetFilter(t *testing.T) {
+	defer func(f func(unsafe.Pointer, int) int) { pmSetFilter = f }(pmSetFilter)
+	var actual int
+	pmSetFilter = func(_ unsafe.Pointer, filters int) int {
+		actual = filters
+		return 0
+	}
+	expected := FilterActiveSensing | FilterClock
+	if err := NewInput(0).SetFilter(expected); err != nil {
+		t.Errorf("Received %v from setting a filter", err)
+	}
+	if actual != expected {
+		t.Errorf("Set filter flags 0x%X instead of 0x%X", actual, expected)
+	}
+	pmSetFilter = func(unsafe.Pointer, int) int { return badPtr }
+	if err := NewInput(0).SetFilter(FilterSysEx); err == nil {
+		t.Error("Received no error from PortMidi failing to set a filter")
+	}
+}
+
+func TestSetChannelMask(t *testing.T) {
+	defer func(f func(unsafe.Pointer, int) int) { pmSetChannelMask = f }(pmSetChannelMask)
+	var actual int
+	pmSetChannelMask = func(_ unsafe.Pointer, mask int) int {
+		actual = mask
+		return 0
+	}
+	if err := NewInput(0).SetChannelMask(0x8001); err != nil {
+		t.Errorf("Received %v from setting a channel mask", err)
+	}
+	if actual != 0x8001 {
+		t.Errorf("Set channel mask 0x%X instead of 0x8001", actual)
+	}
+}
+
+func TestOpenInvalidDeviceID(t *testing.T) {
+	for _, id := range []int{-1, NumStreams(), 1 << 30} {
+		if err := NewOutput(id).Open(); err == nil {
+			t.Errorf("Received no error from opening an output with device ID %d", id)
+		}
+		if err := NewInput(id).Open(); err == nil {
+			t.Errorf("Received no error from opening an input with device ID %d", id)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	defer func(f func() int) { pmTime = f }(pmTime)
+	pmTime = func() int { return 1500 }
+	if actual := Time(); actual != 1500*time.Millisecond {
+		t.Errorf("Received %v from the clock instead of %v", actual, 1500*time.Millisecond)
+	}
+}
+
+type fixedClock time.Duration
+
+func (c fixedClock) Time() time.Duration { return time.Duration(c) }
+
+func TestTimeSource(t *testing.T) {
+	SetTimeSource(fixedClock(2 * time.Second))
+	if actual := Time(); actual != 2*time.Second {
+		t.Errorf("Received %v from the clock instead of the time source's %v", actual, 2*time.Second)
+	}
+	if actual := int(goTimeProc(nil)); actual != 2000 {
+		t.Errorf("PortMidi received %dms from the time proc instead of 2000ms", actual)
+	}
+	SetTimeSource(nil)
+	defer func(f func() int) { pmTime = f }(pmTime)
+	pmTime = func() int { return 1500 }
+	if actual := Time(); actual != 1500*time.Millisecond {
+		t.Errorf("Received %v from the clock with no time source instead of PortMidi's", actual)
+	}
+}
+
+func TestReadEventOverflow(t *testing.T) {
+	defer func(f func(unsafe.Pointer) (uint32, int, int)) { pmRead = f }(pmRead)
+	pmRead = func(unsafe.Pointer) (uint32, int, int) { return 0, 0, overflow }
+	if _, _, err := NewInput(0).ReadEvent(); !errors.Is(err, ErrBufferOverflow) {
+		t.Errorf("Received %v from reading an overflowed stream instead of %v", err, ErrBufferOverflow)
+	}
+	pmRead = func(unsafe.Pointer) (uint32, int, int) { return 0x7F3C90, 5, 1 }
+	message, timestamp, err := NewInput(0).ReadEvent()
+	if message != 0x7F3C90 || timestamp != 5*time.Millisecond || err != nil {
+		t.Errorf("Received (%X, %v, %v) from reading a message", message, timestamp, err)
+	}
+}
+
+type uint32er uint32
+
+func (u uint32er) Uint32() uint32 { return uint32(u) }
+
+func TestWriteAt(t *testing.T) {
+	defer func(f func(unsafe.Pointer, uint32, int) int) { pmWrite = f }(pmWrite)
+	var message uint32
+	var timestamp int
+	pmWrite = func(_ unsafe.Pointer, m uint32, ms int) int {
+		message, timestamp = m, ms
+		return 0
+	}
+	if err := NewOutput(0).WriteAt(uint32er(0x643C90), 1500*time.Millisecond); err != nil {
+		t.Errorf("Received %v from writing", err)
+	}
+	if message != 0x643C90 || timestamp != 1500 {
+		t.Errorf("Wrote %X at %dms instead of 643C90 at 1500ms", message, timestamp)
+	}
+}
+
+func TestReadyBackoff(t *testing.T) {
+	in := NewInput(0)
+	ready := in.Ready()
+	for waits := 1; waits < 6; waits++ {
+		select {
+		case <-ready:
+		case <-time.After(time.Second):
+			t.Fatal("Ready did not receive")
+		}
+		if r := in.Ready(); r != ready {
+			t.Fatal("Ready returned a new channel instead of reusing its own")
+		}
+	}
+	if in.idle != maxPollInterval {
+		t.Errorf("Waited %v while idle instead of backing off to %v", in.idle, maxPollInterval)
+	}
+	<-ready
+	in.poll.Stop()
+}
@@ -0,0 +1,21 @@
+//go:build !alsa && !coremidi && !winmm && !rawmidi && !jack && !rtmidi
+
+package portmidi
+
+// Kept apart from portmidi.go, as a file exporting Go functions to C can't
+// define C functions in its preamble.
+
+// #include <portmidi.h>
+import "C"
+import (
+	"time"
+	"unsafe"
+)
+
+// The time proc streams are opened with while a TimeSource is set, by which
+// PortMidi timestamps what they read and sends what is written at its time.
+//
+//export goTimeProc
+func goTimeProc(info unsafe.Pointer) C.PmTimestamp {
+	return C.PmTimestamp(Time() / time.Millisecond)
+}
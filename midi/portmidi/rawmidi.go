@@ -0,0 +1,127 @@
+//go:build linux && rawmidi
+
+package portmidi
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var system backend = &rawmidi{}
+
+// Where the rawmidi device files and ALSA's descriptions of them are,
+// swapped out in tests.
+var (
+	rawmidiDevices = "/dev/snd"
+	rawmidiProc    = "/proc/asound"
+)
+
+// The rawmidi device files of ALSA's kernel drivers, read and written without
+// cgo. Each device is an input stream then an output stream, in the directions
+// it has, ordered by card and device.
+type rawmidi struct {
+	paths []string // By stream ID.
+}
+
+type rawmidiDevice struct {
+	path         string
+	card, device int
+}
+
+func (r *rawmidi) streams() ([]StreamInfo, error) {
+	var infos []StreamInfo
+	r.paths = nil
+	paths, err := filepath.Glob(filepath.Join(rawmidiDevices, "midiC*D*"))
+	if err != nil {
+		return nil, err
+	}
+	var devices []rawmidiDevice
+	for _, path := range paths {
+		d := rawmidiDevice{path: path}
+		if _, err := fmt.Sscanf(filepath.Base(path), "midiC%dD%d", &d.card, &d.device); err == nil {
+			devices = append(devices, d)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].card != devices[j].card {
+			return devices[i].card < devices[j].card
+		}
+		return devices[i].device < devices[j].device
+	})
+	for _, d := range devices {
+		name, input, output := describeRawmidi(d)
+		if input {
+			infos = append(infos, StreamInfo{IsInput: true, Name: name, Interface: "ALSA"})
+			r.paths = append(r.paths, d.path)
+		}
+		if output {
+			infos = append(infos, StreamInfo{IsOutput: true, Name: name, Interface: "ALSA"})
+			r.paths = append(r.paths, d.path)
+		}
+	}
+	return infos, nil
+}
+
+// Returns a device's name and directions from its description under
+// /proc/asound, e.g. "UM-ONE" followed by "Output 0" and "Input 0" sections.
+// Without one, the device is named by its file and assumed to have both.
+func describeRawmidi(d rawmidiDevice) (name string, input, output bool) {
+	f, err := os.Open(filepath.Join(rawmidiProc, fmt.Sprintf("card%d", d.card), fmt.Sprintf("midi%d", d.device)))
+	if err != nil {
+		return filepath.Base(d.path), true, true
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case name == "":
+			name = strings.TrimSpace(line)
+		case strings.HasPrefix(line, "Input"):
+			input = true
+		case strings.HasPrefix(line, "Output"):
+			output = true
+		}
+	}
+	if name == "" {
+		name = filepath.Base(d.path)
+	}
+	return name, input, output
+}
+
+func (r *rawmidi) openInput(deviceID int, q *eventQueue) (io.Closer, error) {
+	f, err := os.OpenFile(r.paths[deviceID], os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	go readRawmidi(f, q)
+	return f, nil
+}
+
+// Passes the bytes read to the queue until the file is closed or the device fails.
+func readRawmidi(f *os.File, q *eventQueue) {
+	buf := make([]byte, 256)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			q.receive(buf[:n], Time())
+		}
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil {
+			q.fail(err)
+			return
+		}
+	}
+}
+
+func (r *rawmidi) openOutput(deviceID int) (io.WriteCloser, error) {
+	return os.OpenFile(r.paths[deviceID], os.O_WRONLY, 0)
+}
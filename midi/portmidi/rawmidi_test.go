@@ -0,0 +1,237 @@
+//go:build linux && rawmidi
+
+package portmidi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type message uint32
+
+func (m message) Uint32() uint32 { return uint32(m) }
+
+// Makes fake rawmidi device files, as FIFOs, with descriptions under a fake
+// /proc/asound, and returns each FIFO opened for reading and writing.
+func fakeRawmidi(t *testing.T, descriptions map[string]string) map[string]*os.File {
+	dir := t.TempDir()
+	devices, proc := rawmidiDevices, rawmidiProc
+	t.Cleanup(func() { rawmidiDevices, rawmidiProc = devices, proc })
+	rawmidiDevices, rawmidiProc = filepath.Join(dir, "snd"), filepath.Join(dir, "asound")
+	fifos := make(map[string]*os.File)
+	for name, description := range descriptions {
+		var card, device int
+		if err := os.MkdirAll(rawmidiDevices, 0755); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(rawmidiDevices, name)
+		if err := syscall.Mkfifo(path, 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		fifos[name] = f
+		if description == "" {
+			continue
+		}
+		if _, err := fmt.Sscanf(name, "midiC%dD%d", &card, &device); err != nil {
+			t.Fatal(err)
+		}
+		cardDir := filepath.Join(rawmidiProc, fmt.Sprintf("card%d", card))
+		if err := os.MkdirAll(cardDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(cardDir, fmt.Sprintf("midi%d", device)), []byte(description), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return fifos
+}
+
+func TestRawmidiStreams(t *testing.T) {
+	fakeRawmidi(t, map[string]string{
+		"midiC10D0": "",
+		"midiC2D1":  "Keys\n\nInput 0\n  Rx bytes     : 0\n",
+		"midiC2D0":  "UM-ONE\n\nOutput 0\n  Tx bytes     : 0\nInput 0\n  Rx bytes     : 0\n",
+	})
+	expected := []StreamInfo{
+		{IsInput: true, Name: "UM-ONE", Interface: "ALSA"},
+		{IsOutput: true, Name: "UM-ONE", Interface: "ALSA"},
+		{IsInput: true, Name: "Keys", Interface: "ALSA"},
+		{IsInput: true, Name: "midiC10D0", Interface: "ALSA"},
+		{IsOutput: true, Name: "midiC10D0", Interface: "ALSA"},
+	}
+	if n := NumStreams(); n != len(expected) {
+		t.Fatalf("Listed %d streams instead of %d", n, len(expected))
+	}
+	for id, info := range expected {
+		if actual := *NewStreamInfo(id); actual != info {
+			t.Errorf("Listed %+v instead of %+v as stream %d", actual, info, id)
+		}
+	}
+}
+
+func TestRawmidiInput(t *testing.T) {
+	fifo := fakeRawmidi(t, map[string]string{"midiC1D0": "Keys\n\nInput 0\n"})["midiC1D0"]
+	in := NewInput(0)
+	if err := in.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Open(); err == nil {
+		t.Error("Opened an open stream again")
+	}
+	if _, err := fifo.Write([]byte{0x90, 60, 100, 62, 0}); err != nil {
+		t.Fatal(err)
+	}
+	var read []uint32
+	for deadline := time.Now().Add(time.Second); len(read) < 2 && time.Now().Before(deadline); {
+		if ok, err := in.Poll(); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		m, _, err := in.ReadEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = append(read, m)
+	}
+	if len(read) != 2 || read[0] != 0x643C90 || read[1] != 0x003E90 {
+		t.Errorf("Read %X instead of two note ons", read)
+	}
+	if err := in.Close(); err != nil {
+		t.Errorf("Received %v from closing", err)
+	}
+	if NewStreamInfo(0).IsOpen {
+		t.Error("Stream is open after closing")
+	}
+}
+
+func TestRawmidiOutput(t *testing.T) {
+	fifo := fakeRawmidi(t, map[string]string{"midiC1D0": "Synth\n\nOutput 0\n"})["midiC1D0"]
+	out := NewOutput(0)
+	if err := NewInput(0).Open(); err == nil {
+		t.Error("Opened an output stream as an input")
+	}
+	if err := out.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := out.Write(message(0x643C90)); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.WriteSysEx([]byte{0xF0, 0x7D, 0xF7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Write(message(0x3C)); err == nil {
+		t.Error("Wrote a message without a status byte")
+	}
+	expected := []byte{0x90, 60, 100, 0xF0, 0x7D, 0xF7}
+	actual := make([]byte, len(expected))
+	if _, err := io.ReadFull(fifo, actual); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("Wrote % X instead of % X", actual, expected)
+	}
+}
+
+func TestRawmidiRefresh(t *testing.T) {
+	fakeRawmidi(t, map[string]string{"midiC2D0": "Synth\n\nOutput 0\n"})
+	out := NewOutput(0)
+	if err := out.Open(); err != nil {
+		t.Fatal(err)
+	}
+	// Plugging in a device listed before it moves the open stream.
+	fakeRawmidi(t, map[string]string{
+		"midiC1D0": "Keys\n\nInput 0\n",
+		"midiC2D0": "Synth\n\nOutput 0\n",
+	})
+	if err := Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if info := NewStreamInfo(0); info.Name != "Keys" || info.IsOpen {
+		t.Errorf("Listed %+v instead of the closed Keys as stream 0", *info)
+	}
+	if info := NewStreamInfo(1); info.Name != "Synth" || !info.IsOpen {
+		t.Errorf("Listed %+v instead of the open Synth as stream 1", *info)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if NewStreamInfo(1).IsOpen {
+		t.Error("Stream is open after closing")
+	}
+}
+
+func TestRawmidiOutputLatency(t *testing.T) {
+	fifo := fakeRawmidi(t, map[string]string{"midiC1D0": "Synth\n\nOutput 0\n"})["midiC1D0"]
+	out := NewOutput(0)
+	out.Latency = 20 * time.Millisecond
+	if err := out.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	start := Time()
+	if err := out.WriteAt(message(0x643C90), start); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := Time() - start; elapsed >= out.Latency {
+		t.Errorf("Writing a message waited %v for the latency, %v", elapsed, out.Latency)
+	}
+	if err := out.Write(message(0x003C91)); err != nil {
+		t.Fatal(err)
+	}
+	actual := make([]byte, 3)
+	if _, err := io.ReadFull(fifo, actual); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x91, 60, 0}; !bytes.Equal(actual, expected) {
+		t.Errorf("Sent % X first instead of the message with no timestamp, % X", actual, expected)
+	}
+	if _, err := io.ReadFull(fifo, actual); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := Time() - start; elapsed < out.Latency {
+		t.Errorf("Sent a message after %v instead of after the latency, %v", elapsed, out.Latency)
+	}
+	if expected := []byte{0x90, 60, 100}; !bytes.Equal(actual, expected) {
+		t.Errorf("Sent % X instead of % X", actual, expected)
+	}
+}
+
+func TestRawmidiWriteEvents(t *testing.T) {
+	fifo := fakeRawmidi(t, map[string]string{"midiC1D0": "Synth\n\nOutput 0\n"})["midiC1D0"]
+	out := NewOutput(0)
+	if err := out.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	events := []Event{{Message: 0x643C90}, {Message: 0x05C1}, {Message: 0x003C90}}
+	if err := out.WriteEvents(events); err != nil {
+		t.Fatal(err)
+	}
+	actual := make([]byte, 8)
+	if _, err := io.ReadFull(fifo, actual); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x90, 60, 100, 0xC1, 5, 0x90, 60, 0}; !bytes.Equal(actual, expected) {
+		t.Errorf("Wrote % X instead of % X", actual, expected)
+	}
+	if err := out.WriteEvents([]Event{{Message: 0xF0}}); err == nil {
+		t.Error("Wrote a SysEx status as a short message")
+	}
+}
@@ -0,0 +1,324 @@
+//go:build rtmidi
+
+package portmidi
+
+/*
+#cgo LDFLAGS: -lrtmidi
+#include <rtmidi/rtmidi_c.h>
+#include <stdlib.h>
+
+// The messages RtMidi queues for an input stream before they are read.
+#define QUEUE_SIZE 1024
+#define MAX_MESSAGE_SIZE 65536
+
+static RtMidiInPtr new_input(const char *client) {
+	RtMidiInPtr in = rtmidi_in_create(RTMIDI_API_UNSPECIFIED, client, QUEUE_SIZE);
+	if (in != NULL && in->ok) {
+		// RtMidi ignores these by default, but the queue filters them instead.
+		rtmidi_in_ignore_types(in, false, false, false);
+	}
+	return in;
+}
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+var system backend = &rtMidi{}
+
+// How often an input stream's queue in RtMidi is read.
+const rtMidiPollInterval = time.Millisecond
+
+// RtMidi, which reaches the system's MIDI API (ALSA, CoreMIDI or the Windows
+// multimedia API) through one library. Its streams are the ports an RtMidi
+// input and output list, each opened by an RtMidi input or output of its own.
+type rtMidi struct {
+	mu     sync.Mutex
+	client string
+	in     rtMidiPtr // Lists the input streams.
+	out    rtMidiPtr // Lists the output streams.
+	names  []string  // RtMidi's names for the ports, by stream ID.
+}
+
+// An RtMidi input or output, which the C API reports its errors through.
+type rtMidiPtr = *C.struct_RtMidiWrapper
+
+// Returns the error RtMidi reports for the input or output, if any.
+func rtMidiError(p rtMidiPtr) error {
+	if p == nil {
+		return errors.New("RtMidi: could not create a MIDI client")
+	}
+	if bool(p.ok) {
+		return nil
+	}
+	return fmt.Errorf("RtMidi: %s", C.GoString(p.msg))
+}
+
+// Creates the input and output that list ports, unless they have been
+// already. Must be called with mu locked.
+func (r *rtMidi) open() error {
+	if r.in != nil {
+		return nil
+	}
+	r.client = filepath.Base(os.Args[0])
+	client := C.CString(r.client)
+	defer C.free(unsafe.Pointer(client))
+	var in rtMidiPtr = C.new_input(client)
+	if err := rtMidiError(in); err != nil {
+		return err
+	}
+	var out rtMidiPtr = C.rtmidi_out_create(C.RTMIDI_API_UNSPECIFIED, client)
+	if err := rtMidiError(out); err != nil {
+		C.rtmidi_in_free(in)
+		return err
+	}
+	r.in, r.out = in, out
+	return nil
+}
+
+func (r *rtMidi) streams() ([]StreamInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names = nil
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	api := C.GoString(C.rtmidi_api_display_name(C.rtmidi_in_get_current_api(r.in)))
+	var infos []StreamInfo
+	for _, p := range []rtMidiPtr{r.in, r.out} {
+		for i, n := 0, int(C.rtmidi_get_port_count(p)); i < n; i++ {
+			name := portName(p, i)
+			infos = append(infos, StreamInfo{
+				IsInput:   p == r.in,
+				IsOutput:  p == r.out,
+				Name:      name,
+				Interface: api,
+			})
+			r.names = append(r.names, name)
+		}
+	}
+	return infos, nil
+}
+
+// Returns the name of the input or output's port with the number.
+func portName(p rtMidiPtr, number int) string {
+	var size C.int
+	C.rtmidi_get_port_name(p, C.uint(number), nil, &size)
+	if size <= 0 {
+		return ""
+	}
+	buf := make([]byte, size)
+	C.rtmidi_get_port_name(p, C.uint(number), (*C.char)(unsafe.Pointer(&buf[0])), &size)
+	return C.GoString((*C.char)(unsafe.Pointer(&buf[0])))
+}
+
+// Returns the number of the port named name, as RtMidi numbers ports afresh
+// whenever they are listed, or an error if it was unplugged.
+func portNumber(p rtMidiPtr, name string) (C.uint, error) {
+	for i, n := 0, int(C.rtmidi_get_port_count(p)); i < n; i++ {
+		if portName(p, i) == name {
+			return C.uint(i), nil
+		}
+	}
+	return 0, fmt.Errorf("RtMidi: no port named %q", name)
+}
+
+// Returns the name of a stream and of the port to open to connect to it.
+func (r *rtMidi) stream(deviceID int) (name, client string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.names[deviceID], r.client
+}
+
+func (r *rtMidi) openInput(deviceID int, q *eventQueue) (io.Closer, error) {
+	name, client := r.stream(deviceID)
+	return openRtMidiInput(client, q, func(in rtMidiPtr, ours *C.char) error {
+		number, err := portNumber(in, name)
+		if err == nil {
+			C.rtmidi_open_port(in, number, ours)
+		}
+		return err
+	})
+}
+
+func (r *rtMidi) openOutput(deviceID int) (io.WriteCloser, error) {
+	name, client := r.stream(deviceID)
+	return openRtMidiOutput(client, func(out rtMidiPtr, ours *C.char) error {
+		number, err := portNumber(out, name)
+		if err == nil {
+			C.rtmidi_open_port(out, number, ours)
+		}
+		return err
+	})
+}
+
+// Virtual ports are made by RtMidi, except with the Windows multimedia API,
+// which can't make them.
+func (r *rtMidi) openVirtualInput(name string, q *eventQueue) (io.Closer, error) {
+	if runtime.GOOS == "windows" {
+		return nil, ErrNoVirtualPorts
+	}
+	return openRtMidiInput(name, q, func(in rtMidiPtr, ours *C.char) error {
+		C.rtmidi_open_virtual_port(in, ours)
+		return nil
+	})
+}
+
+func (r *rtMidi) openVirtualOutput(name string) (io.WriteCloser, error) {
+	if runtime.GOOS == "windows" {
+		return nil, ErrNoVirtualPorts
+	}
+	return openRtMidiOutput(name, func(out rtMidiPtr, ours *C.char) error {
+		C.rtmidi_open_virtual_port(out, ours)
+		return nil
+	})
+}
+
+// Creates an RtMidi input for a stream, named name, and opens its port with open.
+func openRtMidiInput(name string, q *eventQueue, open func(rtMidiPtr, *C.char) error) (io.Closer, error) {
+	ours := C.CString(name)
+	defer C.free(unsafe.Pointer(ours))
+	var in rtMidiPtr = C.new_input(ours)
+	if err := rtMidiError(in); err != nil {
+		return nil, err
+	}
+	err := open(in, ours)
+	if err == nil {
+		err = rtMidiError(in)
+	}
+	if err != nil {
+		C.rtmidi_in_free(in)
+		return nil, err
+	}
+	s := &rtMidiInput{in: in, done: make(chan bool), stopped: make(chan bool)}
+	go s.read(q)
+	return s, nil
+}
+
+// Creates an RtMidi output for a stream, named name, and opens its port with open.
+func openRtMidiOutput(name string, open func(rtMidiPtr, *C.char) error) (io.WriteCloser, error) {
+	ours := C.CString(name)
+	defer C.free(unsafe.Pointer(ours))
+	var out rtMidiPtr = C.rtmidi_out_create(C.RTMIDI_API_UNSPECIFIED, ours)
+	if err := rtMidiError(out); err != nil {
+		return nil, err
+	}
+	err := open(out, ours)
+	if err == nil {
+		err = rtMidiError(out)
+	}
+	if err != nil {
+		C.rtmidi_out_free(out)
+		return nil, err
+	}
+	return &rtMidiOutput{out: out}, nil
+}
+
+func (r *rtMidi) terminate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.in == nil {
+		return nil
+	}
+	C.rtmidi_in_free(r.in)
+	C.rtmidi_out_free(r.out)
+	r.in, r.out = nil, nil
+	return nil
+}
+
+type rtMidiInput struct {
+	in      rtMidiPtr
+	done    chan bool // Closed to stop reading.
+	stopped chan bool // Closed once reading has stopped.
+}
+
+// Passes the messages RtMidi has queued to the queue until closed. RtMidi
+// queues whole messages, so each is timestamped when it is read.
+func (s *rtMidiInput) read(q *eventQueue) {
+	defer close(s.stopped)
+	buf := make([]byte, C.MAX_MESSAGE_SIZE)
+	ticker := time.NewTicker(rtMidiPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		for {
+			size := C.size_t(len(buf))
+			C.rtmidi_in_get_message(s.in, (*C.uchar)(unsafe.Pointer(&buf[0])), &size)
+			if err := rtMidiError(s.in); err != nil {
+				q.lost() // The message didn't fit in buf.
+				s.in.ok = C.bool(true)
+				break
+			}
+			if size == 0 {
+				break
+			}
+			q.receive(buf[:size], Time())
+		}
+	}
+}
+
+func (s *rtMidiInput) Close() error {
+	close(s.done)
+	<-s.stopped
+	C.rtmidi_close_port(s.in)
+	C.rtmidi_in_free(s.in)
+	return nil
+}
+
+type rtMidiOutput struct {
+	out rtMidiPtr
+	mu  sync.Mutex
+}
+
+// Sends each message written, as RtMidi sends one message at a time.
+func (s *rtMidiOutput) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for written := 0; written < len(b); {
+		n := nextMessage(b[written:])
+		if n == 0 {
+			return written, fmt.Errorf("Invalid status byte 0x%02X", b[written])
+		}
+		if C.rtmidi_out_send_message(s.out, (*C.uchar)(unsafe.Pointer(&b[written])), C.int(n)) != 0 {
+			return written, rtMidiError(s.out)
+		}
+		written += n
+	}
+	return len(b), nil
+}
+
+// Returns the length of the message b starts with, or 0 if it doesn't start
+// with a whole message.
+func nextMessage(b []byte) int {
+	if b[0] == 0xF0 {
+		for i, c := range b {
+			if c == 0xF7 {
+				return i + 1
+			}
+		}
+		return 0
+	}
+	if n := messageLength(b[0]); n <= len(b) {
+		return n
+	}
+	return 0
+}
+
+func (s *rtMidiOutput) Close() error {
+	C.rtmidi_close_port(s.out)
+	C.rtmidi_out_free(s.out)
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package portmidi reads and writes the system's MIDI streams through
+// PortMidi or, built with one of these tags, another MIDI API:
+//
+//	alsa      ALSA's rawmidi API (Linux)
+//	rawmidi   ALSA's rawmidi device files, /dev/snd/midiC*D*, without cgo (Linux)
+//	coremidi  CoreMIDI (macOS)
+//	winmm     The Windows multimedia API, without cgo (Windows)
+//	jack      JACK MIDI ports, for sample-accurate timing in a JACK session
+//	rtmidi    RtMidi 5 or later, which reaches ALSA, CoreMIDI or the Windows multimedia API
+//
+// Every backend numbers the system's streams, each an input or an output,
+// and opens them as an Input or Output with the same methods.
+package portmidi
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrBufferOverflow is returned when PortMidi's buffer for a stream overflowed, losing MIDI data.
+	ErrBufferOverflow = errors.New("PortMidi buffer overflowed, MIDI data was lost.")
+	// ErrNoVirtualPorts is returned when opening a virtual stream with a
+	// backend that can't create them: PortMidi, winmm, rawmidi, or rtmidi
+	// on Windows.
+	ErrNoVirtualPorts = errors.New("Virtual ports are not supported by this backend.")
+	// ErrStreamsOpen is returned by Refresh with PortMidi, which can't list
+	// the system's streams again while any are open.
+	ErrStreamsOpen = errors.New("Streams can't be listed again while any are open.")
+)
+
+// The number of events a stream buffers unless its BufferSize is set, as
+// PortMidi's own programs use.
+const DefaultBufferSize = 512
+
+// Message types that an Input can be set to drop, to be ORed together.
+// Their values are those of PortMidi's PM_FILT_ flags, a bit for each status.
+const (
+	FilterActiveSensing = 1 << 0x0E
+	FilterSysEx         = 1 << 0x00
+	FilterClock         = 1 << 0x08
+	FilterPlay          = 1<<0x0A | 1<<0x0B | 1<<0x0C // Start, Continue and Stop.
+	FilterRealTime      = FilterActiveSensing | FilterSysEx | FilterClock | FilterPlay | 1<<0x09 | 1<<0x0D | 1<<0x0F
+	FilterNote          = 1<<0x18 | 1<<0x19
+	FilterAftertouch    = 1<<0x1A | 1<<0x1D
+	FilterProgram       = 1 << 0x1C
+	FilterControl       = 1 << 0x1B
+	FilterPitchBend     = 1 << 0x1E
+	FilterSystemCommon  = 1<<0x01 | 1<<0x02 | 1<<0x03 | 1<<0x06
+)
+
+// A TimeSource is a clock to time MIDI data by in place of the backend's, e.g.
+// an application's transport, so that events can be scheduled against it. Its
+// time must not go back.
+type TimeSource interface {
+	Time() time.Duration
+}
+
+var timeSource struct {
+	sync.Mutex
+	TimeSource
+}
+
+// SetTimeSource makes Time return the time of t, and so MIDI data read be
+// timestamped by it and MIDI data written be timed by it, or by the backend's
+// clock again if t is nil. With PortMidi, streams already open stay timed by
+// the clock they were opened with, so set it before opening any.
+func SetTimeSource(t TimeSource) {
+	timeSource.Lock()
+	defer timeSource.Unlock()
+	timeSource.TimeSource = t
+}
+
+// Returns the time of the TimeSource set, if one is.
+func sourceTime() (time.Duration, bool) {
+	timeSource.Lock()
+	t := timeSource.TimeSource
+	timeSource.Unlock()
+	if t == nil {
+		return 0, false
+	}
+	return t.Time(), true
+}
+
+// An Event is a message read from an Input with its timestamp, on the clock
+// returned by Time, as read by ReadEvents.
+type Event struct {
+	Message   uint32
+	Timestamp time.Duration
+}
+
+type Uint32er interface {
+	Uint32() uint32
+}
+
+type StreamInfo struct {
+	IsInput   bool
+	IsOutput  bool
+	IsOpen    bool
+	Name      string
+	Interface string // The underlying MIDI API, e.g. "CoreMIDI", "ALSA" or "MMSystem".
+}
@@ -0,0 +1,283 @@
+//go:build windows && winmm
+
+package portmidi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var system backend = &winmm{}
+
+var (
+	winmmDLL                = syscall.NewLazyDLL("winmm.dll")
+	midiInGetNumDevs        = winmmDLL.NewProc("midiInGetNumDevs")
+	midiInGetDevCaps        = winmmDLL.NewProc("midiInGetDevCapsW")
+	midiInGetErrorText      = winmmDLL.NewProc("midiInGetErrorTextW")
+	midiInOpen              = winmmDLL.NewProc("midiInOpen")
+	midiInStart             = winmmDLL.NewProc("midiInStart")
+	midiInReset             = winmmDLL.NewProc("midiInReset")
+	midiInClose             = winmmDLL.NewProc("midiInClose")
+	midiInPrepareHeader     = winmmDLL.NewProc("midiInPrepareHeader")
+	midiInUnprepareHeader   = winmmDLL.NewProc("midiInUnprepareHeader")
+	midiInAddBuffer         = winmmDLL.NewProc("midiInAddBuffer")
+	midiOutGetNumDevs       = winmmDLL.NewProc("midiOutGetNumDevs")
+	midiOutGetDevCaps       = winmmDLL.NewProc("midiOutGetDevCapsW")
+	midiOutGetErrorText     = winmmDLL.NewProc("midiOutGetErrorTextW")
+	midiOutOpen             = winmmDLL.NewProc("midiOutOpen")
+	midiOutClose            = winmmDLL.NewProc("midiOutClose")
+	midiOutShortMsg         = winmmDLL.NewProc("midiOutShortMsg")
+	midiOutLongMsg          = winmmDLL.NewProc("midiOutLongMsg")
+	midiOutPrepareHeader    = winmmDLL.NewProc("midiOutPrepareHeader")
+	midiOutUnprepareHeader  = winmmDLL.NewProc("midiOutUnprepareHeader")
+	winmmInputCallback      = syscall.NewCallback(winmmReceive)
+	winmmInputCallbackFlags = uintptr(0x30000) // CALLBACK_FUNCTION
+)
+
+// Messages to an input callback.
+const (
+	mimData     = 0x3C3 // MIM_DATA
+	mimLongData = 0x3C4 // MIM_LONGDATA
+)
+
+const (
+	mhdrDone         = 1 // MHDR_DONE
+	sysExBufferSize  = 1024
+	sysExBufferCount = 4
+)
+
+type midiInCaps struct { // MIDIINCAPSW
+	mid, pid      uint16
+	driverVersion uint32
+	name          [32]uint16
+	support       uint32
+}
+
+type midiOutCaps struct { // MIDIOUTCAPSW
+	mid, pid                               uint16
+	driverVersion                          uint32
+	name                                   [32]uint16
+	technology, voices, notes, channelMask uint16
+	support                                uint32
+}
+
+type midiHdr struct { // MIDIHDR
+	data          *byte
+	bufferLength  uint32
+	bytesRecorded uint32
+	user          uintptr
+	flags         uint32
+	next          *midiHdr
+	reserved      uintptr
+	offset        uint32
+	reserved2     [8]uintptr
+}
+
+// The Windows multimedia (MMSystem) MIDI API, whose streams are its input
+// devices then its output devices.
+type winmm struct {
+	inputs int // The number of input devices, whose IDs come first.
+}
+
+// Returns an error for an MMSYSERR or MIDIERR code, described by getErrorText.
+func winmmError(code uintptr, getErrorText *syscall.LazyProc) error {
+	if code == 0 {
+		return nil
+	}
+	var text [256]uint16
+	if r, _, _ := getErrorText.Call(code, uintptr(unsafe.Pointer(&text[0])), uintptr(len(text))); r != 0 {
+		return fmt.Errorf("MMSystem error %d", code)
+	}
+	return errors.New(syscall.UTF16ToString(text[:]))
+}
+
+func (w *winmm) streams() ([]StreamInfo, error) {
+	var infos []StreamInfo
+	n, _, _ := midiInGetNumDevs.Call()
+	for id := uintptr(0); id < n; id++ {
+		var caps midiInCaps
+		r, _, _ := midiInGetDevCaps.Call(id, uintptr(unsafe.Pointer(&caps)), unsafe.Sizeof(caps))
+		if err := winmmError(r, midiInGetErrorText); err != nil {
+			return nil, err
+		}
+		infos = append(infos, StreamInfo{IsInput: true, Name: syscall.UTF16ToString(caps.name[:]), Interface: "MMSystem"})
+	}
+	w.inputs = len(infos)
+	n, _, _ = midiOutGetNumDevs.Call()
+	for id := uintptr(0); id < n; id++ {
+		var caps midiOutCaps
+		r, _, _ := midiOutGetDevCaps.Call(id, uintptr(unsafe.Pointer(&caps)), unsafe.Sizeof(caps))
+		if err := winmmError(r, midiOutGetErrorText); err != nil {
+			return nil, err
+		}
+		infos = append(infos, StreamInfo{IsOutput: true, Name: syscall.UTF16ToString(caps.name[:]), Interface: "MMSystem"})
+	}
+	return infos, nil
+}
+
+// The open input streams, by the instance their callbacks are made with. A
+// single callback is shared, as only so many can be made.
+var winmmInputs struct {
+	sync.Mutex
+	streams map[uintptr]*winmmInput
+	next    uintptr
+}
+
+func winmmReceive(handle, msg, instance, param1, param2 uintptr) uintptr {
+	winmmInputs.Lock()
+	in := winmmInputs.streams[instance]
+	winmmInputs.Unlock()
+	if in == nil {
+		return 0
+	}
+	switch msg {
+	case mimData: // param1 is a message packed as PortMidi's, param2 its time since the stream started.
+		if b := unpack(uint32(param1)); b != nil {
+			in.queue.receive(b, in.started+time.Duration(param2)*time.Millisecond)
+		}
+	case mimLongData: // param1 is a SysEx buffer, to be added back once read.
+		h := *(**midiHdr)(unsafe.Pointer(&param1)) // The system's pointer, to the buffer it was given.
+		if h.bytesRecorded > 0 {
+			b := unsafe.Slice(h.data, h.bytesRecorded)
+			in.queue.receive(b, in.started+time.Duration(param2)*time.Millisecond)
+		}
+		select {
+		case in.done <- h: // Multimedia functions can't be called from the callback.
+		default:
+		}
+	}
+	return 0
+}
+
+type winmmInput struct {
+	handle   uintptr
+	instance uintptr
+	queue    *eventQueue
+	started  time.Duration // When the stream started, on the clock returned by Time.
+	headers  []*midiHdr    // SysEx buffers, kept referenced while the system holds them.
+	done     chan *midiHdr // SysEx buffers returned by the system.
+	quit     chan bool     // Closed to stop adding buffers back.
+	stopped  chan bool     // Closed once buffers are no longer added back.
+}
+
+func (w *winmm) openInput(deviceID int, q *eventQueue) (io.Closer, error) {
+	in := &winmmInput{
+		queue:   q,
+		done:    make(chan *midiHdr, sysExBufferCount),
+		quit:    make(chan bool),
+		stopped: make(chan bool),
+	}
+	winmmInputs.Lock()
+	if winmmInputs.streams == nil {
+		winmmInputs.streams = make(map[uintptr]*winmmInput)
+	}
+	winmmInputs.next++
+	in.instance = winmmInputs.next
+	winmmInputs.streams[in.instance] = in
+	winmmInputs.Unlock()
+
+	r, _, _ := midiInOpen.Call(uintptr(unsafe.Pointer(&in.handle)), uintptr(deviceID), winmmInputCallback, in.instance, winmmInputCallbackFlags)
+	if err := winmmError(r, midiInGetErrorText); err != nil {
+		in.unregister()
+		return nil, err
+	}
+	for i := 0; i < sysExBufferCount; i++ {
+		buf := make([]byte, sysExBufferSize)
+		h := &midiHdr{data: &buf[0], bufferLength: sysExBufferSize}
+		in.headers = append(in.headers, h)
+		midiInPrepareHeader.Call(in.handle, uintptr(unsafe.Pointer(h)), unsafe.Sizeof(*h))
+		midiInAddBuffer.Call(in.handle, uintptr(unsafe.Pointer(h)), unsafe.Sizeof(*h))
+	}
+	go in.addBuffers()
+	in.started = Time()
+	r, _, _ = midiInStart.Call(in.handle)
+	if err := winmmError(r, midiInGetErrorText); err != nil {
+		in.Close()
+		return nil, err
+	}
+	return in, nil
+}
+
+// Adds SysEx buffers back once read, until the stream is closed.
+func (in *winmmInput) addBuffers() {
+	defer close(in.stopped)
+	for {
+		select {
+		case h := <-in.done:
+			h.bytesRecorded = 0
+			midiInAddBuffer.Call(in.handle, uintptr(unsafe.Pointer(h)), unsafe.Sizeof(*h))
+		case <-in.quit:
+			return
+		}
+	}
+}
+
+func (in *winmmInput) Close() error {
+	close(in.quit)
+	<-in.stopped
+	midiInReset.Call(in.handle) // Returns the SysEx buffers.
+	for _, h := range in.headers {
+		midiInUnprepareHeader.Call(in.handle, uintptr(unsafe.Pointer(h)), unsafe.Sizeof(*h))
+	}
+	r, _, _ := midiInClose.Call(in.handle)
+	in.unregister()
+	return winmmError(r, midiInGetErrorText)
+}
+
+func (in *winmmInput) unregister() {
+	winmmInputs.Lock()
+	delete(winmmInputs.streams, in.instance)
+	winmmInputs.Unlock()
+}
+
+func (w *winmm) openOutput(deviceID int) (io.WriteCloser, error) {
+	out := &winmmOutput{}
+	r, _, _ := midiOutOpen.Call(uintptr(unsafe.Pointer(&out.handle)), uintptr(deviceID-w.inputs), 0, 0, 0)
+	if err := winmmError(r, midiOutGetErrorText); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type winmmOutput struct {
+	handle uintptr
+}
+
+// Writes a message, or a SysEx message, which is sent before returning.
+func (out *winmmOutput) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if b[0] != 0xF0 {
+		r, _, _ := midiOutShortMsg.Call(out.handle, uintptr(pack(b)))
+		if err := winmmError(r, midiOutGetErrorText); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	h := &midiHdr{data: &b[0], bufferLength: uint32(len(b)), bytesRecorded: uint32(len(b))}
+	size := unsafe.Sizeof(*h)
+	r, _, _ := midiOutPrepareHeader.Call(out.handle, uintptr(unsafe.Pointer(h)), size)
+	if err := winmmError(r, midiOutGetErrorText); err != nil {
+		return 0, err
+	}
+	r, _, _ = midiOutLongMsg.Call(out.handle, uintptr(unsafe.Pointer(h)), size)
+	for r == 0 && h.flags&mhdrDone == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	midiOutUnprepareHeader.Call(out.handle, uintptr(unsafe.Pointer(h)), size)
+	if err := winmmError(r, midiOutGetErrorText); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (out *winmmOutput) Close() error {
+	r, _, _ := midiOutClose.Call(out.handle)
+	return winmmError(r, midiOutGetErrorText)
+}
@@ -10,69 +10,483 @@ that the OS uses to transfer data to them.
 */
 
 import (
+	"errors"
 	"fmt"
 	"github.com/aoeu/audio/midi/portmidi"
+	"sync"
 	"time"
 )
 
 type Port struct {
+	// Guards isOpen, as ports are opened and closed from goroutines other
+	// than the one connecting them, e.g. a DeviceWatcher's.
+	mu         sync.Mutex
 	isOpen     bool
 	messages   chan Message
-	disconnect chan bool
+	disconnect chan bool // Closed when the port is closed.
+	errs       chan error
+	parser     StreamParser // Parses the bytes written by NewWriter. Guarded by mu.
 }
 
+// The number of errors a port holds for its Errors to receive, after which
+// further errors are dropped.
+const errorBufferSize = 16
+
+// Creates a new Port. Its messages channel is made here rather than when it is
+// opened, so that sending to the port before it is opened never blocks on a nil channel.
 func NewPort(isOpen bool) *Port {
 	return &Port{
 		isOpen:     isOpen,
 		messages:   make(chan Message, BufferSize),
-		disconnect: make(chan bool, 1),
+		disconnect: make(chan bool),
+		errs:       make(chan error, errorBufferSize),
 	}
 }
 
 func (p *Port) Open() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.isOpen = true
 	return nil
 }
 
+// Closes the port. Its channel is left open, as other goroutines may still
+// be sending to it; they select on Done to stop instead.
 func (p *Port) Close() error {
-	if p.isOpen {
-		p.isOpen = false
-		p.disconnect <- true
-		close(p.messages)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.isOpen {
+		return nil
+	}
+	p.isOpen = false
+	select {
+	case <-p.disconnect: // Closed when the port was last open.
+	default:
+		close(p.disconnect)
 	}
 	return nil
 }
 
+// Returns a channel that is closed once the port is closed, for goroutines
+// sending to or receiving from the port to stop on.
+func (p *Port) Done() <-chan bool {
+	return p.disconnect
+}
+
+// Reports whether the port is open.
+func (p *Port) IsOpen() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.isOpen
+}
+
 func (p *Port) Connect() {}
 
+// Returns the port's channel, which MIDI data is sent to or received from the port on.
+func (p *Port) Messages() chan Message {
+	return p.messages
+}
+
+// Returns the messages completed by bytes written by NewWriter.
+func (p *Port) parse(b []byte) []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.parser.Parse(b)
+}
+
+// Discards the running status and any incomplete message written by NewWriter.
+func (p *Port) resetParser() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.parser.Reset()
+}
+
+// Returns a channel receiving the errors that occur while the port is
+// connected, e.g. when a system port's stream fails, so that they can be
+// logged or recovered from. Errors are dropped while the channel is full.
+func (p *Port) Errors() <-chan error {
+	return p.errs
+}
+
+// Sends an error to the port's Errors, unless it is full, logging it.
+func (p *Port) report(err error) {
+	Debug.Print(err)
+	select {
+	case p.errs <- err:
+	default:
+	}
+}
+
+// Reports how many messages are buffered by the port and how many it can buffer.
+// PortMidi doesn't report how full its own buffers are, so for system ports
+// this is only what is buffered before writing to or after reading from PortMidi.
+func (p *Port) BufferFill() (used, capacity int) {
+	return len(p.messages), cap(p.messages)
+}
+
+// Creates a linked pair of ports, as if a MIDI cable connected them: the
+// messages sent to the input port are received from the output port, so that
+// a program can send MIDI data to itself, or a pipeline can be tested from
+// end to end, without a system device. Make devices from them with
+// NewDeviceFromPorts.
+func NewLoopback() (in, out Porter) {
+	i, o := NewPort(false), NewPort(false)
+	o.messages = i.messages
+	return i, o
+}
+
 type SystemPort struct {
 	Port
-	id int
+	id   int
+	name string // Of the port's device, which its stream is found by when reconnecting.
+	// If set, when the port's stream fails, e.g. as its device is unplugged,
+	// the port looks for the device every ReconnectInterval until it is
+	// plugged in again, then reopens the stream and carries on, rather than
+	// stopping. Messages sent to an input port meanwhile are buffered.
+	// PortMidi can't list devices again while any of their streams are open,
+	// so built with it, a port only reconnects once no other port is open.
+	ReconnectInterval time.Duration
+	reopen            func() error // Replaces reopening the stream in tests.
+	stopped           bool         // Set once the port is closed, for good. Guarded by mu.
+	streamClosed      bool         // Set while the stream is closed, until it is reopened. Guarded by the lock closeStream takes.
+	connected         sync.WaitGroup
 }
 
+// Closes the port, waiting for it to stop being connected, without closing
+// its stream. Closing a closed port does nothing.
 func (s *SystemPort) Close() error {
-	if s.isOpen {
-		s.isOpen = false
-		s.disconnect <- true
+	if stopped, _ := s.stop(); stopped {
 		close(s.messages)
 	}
 	return nil
 }
 
+// Closes the port's disconnect channel, ending its Connect, and waits for
+// Connect to return, so that its stream and channel can then be closed
+// without Connect using them. Returns false if the port was already stopped,
+// and ErrPortNotOpen if it was never opened, so that it is closed only once.
+func (s *SystemPort) stop() (bool, error) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return false, nil
+	}
+	if !s.isOpen {
+		s.mu.Unlock()
+		return false, fmt.Errorf("System port %d: %w", s.id, ErrPortNotOpen)
+	}
+	s.stopped, s.isOpen = true, false
+	close(s.disconnect)
+	s.mu.Unlock()
+	s.connected.Wait()
+	return true, nil
+}
+
+// Registers a Connect for Close to wait for, returning false if the port is
+// already closed, in which case Connect must return.
+func (s *SystemPort) starting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return false
+	}
+	s.connected.Add(1)
+	return true
+}
+
+// What a SystemOutPort does with a message read while its channel is full.
+type OverflowPolicy int
+
+const (
+	OverflowBlocks      OverflowPolicy = iota // Reading waits for room, while the system's buffer may overflow.
+	OverflowDropsNewest                       // The message read is dropped.
+	OverflowDropsOldest                       // The oldest message in the channel is dropped to make room.
+)
+
+// Configures a system port's buffers, when it is opened with OpenWith.
+type PortConfig struct {
+	BufferSize       int            // Messages the port's channel buffers, or 0 to leave it at BufferSize.
+	StreamBufferSize int            // Events the system's stream buffers, or 0 for portmidi.DefaultBufferSize.
+	Overflow         OverflowPolicy // For output ports.
+	// For input ports, added to the times TimedMessages are written at to get
+	// the time the system sends them at, so that bursts of messages are sent
+	// at precise times rather than as they are written. Messages that aren't
+	// timed are sent immediately.
+	Latency time.Duration
+}
+
+// Replaces the port's channel with one buffering c.BufferSize messages,
+// keeping the messages already sent to it that fit.
+func (s *SystemPort) configure(c PortConfig) {
+	if c.BufferSize <= 0 || c.BufferSize == cap(s.messages) {
+		return
+	}
+	messages := make(chan Message, c.BufferSize)
+	for len(s.messages) > 0 && len(messages) < cap(messages) {
+		messages <- <-s.messages
+	}
+	s.messages = messages
+}
+
+// Tries to reopen the port's stream every ReconnectInterval until it succeeds,
+// returning false if the port is closed first.
+func (s *SystemPort) reconnect(reopen func() error) bool {
+	Debug.Printf("System port %d: reconnecting", s.id)
+	if s.reopen != nil {
+		reopen = s.reopen
+	}
+	ticker := time.NewTicker(s.ReconnectInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.disconnect:
+			return false
+		}
+		if err := reopen(); err == nil {
+			Debug.Printf("System port %d: reconnected", s.id)
+			return true
+		}
+	}
+}
+
+// Returns the ID of a closed stream of the port's device in the direction,
+// listing the system's streams again first.
+func (s *SystemPort) findStream(input bool) (int, error) {
+	if err := portmidi.Refresh(); err != nil && !errors.Is(err, portmidi.ErrStreamsOpen) {
+		return 0, err
+	}
+	for id := 0; id < portmidi.NumStreams(); id++ {
+		info := portmidi.NewStreamInfo(id)
+		if info.Name == s.name && !info.IsOpen && (input && info.IsInput || !input && info.IsOutput) {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("System device %q: %w", s.name, ErrNoDevice)
+}
+
+// Returns the time on the clock shared by every system port, which the
+// system's MIDI data is timed by, so times from different ports are comparable.
+func Time() time.Duration {
+	return portmidi.Time()
+}
+
+// A clock to time system MIDI data by in place of the system's, e.g. an
+// application's transport. Its time must not go back.
+type TimeSource = portmidi.TimeSource
+
+// Makes Time, and so the timestamps of messages read by system ports and the
+// times TimedMessages are written at, follow t, or the system's clock again
+// if t is nil. Set it before opening any system port.
+func SetTimeSource(t TimeSource) {
+	portmidi.SetTimeSource(t)
+}
+
+// Returns the time on the port's clock, the same clock for every system port.
+func (s *SystemPort) Time() time.Duration {
+	return Time()
+}
+
+// Stats are a system port's running totals of the MIDI data it moved and
+// the time spent moving it, to tell whether a slow connection is held up by
+// the system MIDI stream or by the connectors wired to the port, and whether
+// its buffers are large enough.
+type Stats struct {
+	Messages    int           // Messages read from or written to the stream.
+	StreamTime  time.Duration // Time spent reading from or writing to the stream.
+	ChannelTime time.Duration // Time spent waiting to send messages read, or to receive messages to write.
+	Overflows   int           // Times the system's buffer for the stream overflowed, losing MIDI data read.
+	// Messages read that the port's OverflowPolicy dropped, as its channel
+	// was full, or messages to write that were dropped as writing them failed
+	// or as more than MaxPendingMessages were enqueued before opening the port.
+	Dropped int
+	// The most messages the port's channel held at once. Reaching its
+	// capacity, as BufferFill reports, means the channel was full.
+	HighWater int
+}
+
+type portStats struct {
+	mu     sync.Mutex
+	totals Stats
+}
+
+func (p *portStats) addStream(d time.Duration) {
+	p.addStreamMessages(1, d)
+}
+
+func (p *portStats) addStreamMessages(n int, d time.Duration) {
+	p.mu.Lock()
+	p.totals.Messages += n
+	p.totals.StreamTime += d
+	p.mu.Unlock()
+}
+
+func (p *portStats) addChannel(d time.Duration) {
+	p.mu.Lock()
+	p.totals.ChannelTime += d
+	p.mu.Unlock()
+}
+
+func (p *portStats) addOverflow() {
+	p.mu.Lock()
+	p.totals.Overflows++
+	p.mu.Unlock()
+}
+
+func (p *portStats) addDropped(n int) {
+	p.mu.Lock()
+	p.totals.Dropped += n
+	p.mu.Unlock()
+}
+
+// Records how many messages the port's channel held, keeping the most.
+func (p *portStats) addFill(n int) {
+	p.mu.Lock()
+	if n > p.totals.HighWater {
+		p.totals.HighWater = n
+	}
+	p.mu.Unlock()
+}
+
+func (p *portStats) get() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.totals
+}
+
+// How long closing a SystemInPort waits for buffered messages to be written, by default.
+const DefaultFlushTimeout = 100 * time.Millisecond
+
+// The most messages a SystemInPort holds while it isn't open, after which
+// the oldest are dropped, so that a port that is never opened doesn't grow.
+const MaxPendingMessages = 1024
+
 type SystemInPort struct {
 	SystemPort
 	*portmidi.Output
+	FlushTimeout time.Duration // How long Close waits for buffered messages to be written.
+	NoteOffs     NoteOffs      // How note offs are written.
+	enqueuing    sync.Mutex
+	holding      sync.Mutex
+	pending      []Message // Messages enqueued before the port was opened.
+	writing      sync.Mutex
+	write        func(Message) error // Replaces Output.Write in tests.
+	batch        []Message           // Messages taken from the channel to write at once.
+	events       []portmidi.Event    // The short messages of a batch, as written.
+	stats        portStats
 }
 
+// Closes the port, first writing any messages still buffered (within the
+// FlushTimeout.) The stream is closed once the port stops being connected.
+// Closing a closed port does nothing.
 func (s *SystemInPort) Close() error {
-	s.SystemPort.Close()
-	return s.Output.Close()
+	if stopped, err := s.stop(); !stopped {
+		return err
+	}
+	s.flush()
+	// The channel is left open, as connectors may still be sending to it.
+	return s.closeStream()
+}
+
+// Writes buffered messages until there are none left or the FlushTimeout passes.
+// Both a connected port stopping and Close flush, in case the port was never connected.
+func (s *SystemInPort) flush() {
+	timeout := s.FlushTimeout
+	if timeout == 0 {
+		timeout = DefaultFlushTimeout
+	}
+	deadline := time.After(timeout)
+	if err := s.writePending(); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-deadline:
+			return
+		default:
+		}
+		select {
+		case m := <-s.messages:
+			if err := s.writeMessage(m); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Writes a message, at its time on the clock returned by Time if it is a TimedMessage.
+func (s *SystemInPort) writeMessage(m Message) error {
+	var at time.Duration
+	if t, ok := m.(TimedMessage); ok {
+		m, at = t.Message, t.Time
+	}
+	m = s.NoteOffs.convert(m)
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	defer func(start time.Time) { s.stats.addStream(time.Since(start)) }(time.Now())
+	if s.write != nil {
+		return s.write(m)
+	}
+	if s.streamClosed {
+		return ErrPortNotOpen // The stream failed and couldn't be reopened.
+	}
+	switch n := m.(type) {
+	case SysEx:
+		return s.Output.WriteSysExAt(n.Data, at)
+	case compound:
+		for _, m := range n.Messages() {
+			if err := s.Output.WriteAt(m, at); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.Output.WriteAt(m, at)
+}
+
+// Sends messages to be written by the port in the order given, with no
+// messages enqueued by other calls to Enqueue written in between them. Any
+// mix of SysEx and channel messages is written exactly in the order sent.
+// Messages sent to the port's channel (a device's In wire) directly may be
+// written in between, so send every message through Enqueue where that matters.
+// Messages enqueued before the port is opened are held until it is connected,
+// up to MaxPendingMessages, after which the oldest are dropped.
+func (s *SystemInPort) Enqueue(messages ...Message) {
+	s.enqueuing.Lock()
+	defer s.enqueuing.Unlock()
+	if !s.IsOpen() {
+		s.holding.Lock()
+		s.pending = append(s.pending, messages...)
+		if over := len(s.pending) - MaxPendingMessages; over > 0 {
+			s.pending = append(s.pending[:0], s.pending[over:]...)
+			s.stats.addDropped(over)
+		}
+		s.holding.Unlock()
+		return
+	}
+	for _, m := range messages {
+		select {
+		case s.messages <- m:
+		case <-s.disconnect:
+			return // The port was closed meanwhile, so the rest can't be written.
+		}
+	}
 }
 
 func (s *SystemInPort) Open() error {
+	if s.Output == nil {
+		return fmt.Errorf("System port %d: %w", s.id, ErrNoStream)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.isOpen {
 		return nil
 	}
+	if s.stopped {
+		return fmt.Errorf("System port %d: %w", s.id, ErrPortClosed)
+	}
 	err := s.Output.Open()
 	if err == nil {
 		s.isOpen = true
@@ -80,65 +494,621 @@ func (s *SystemInPort) Open() error {
 	return err
 }
 
-func (s SystemInPort) Connect() {
+// Opens the stream of the port's device again, for reconnecting.
+func (s *SystemInPort) reopenStream() error {
+	id, err := s.findStream(false)
+	if err != nil {
+		return err
+	}
+	out := portmidi.NewOutput(id)
+	out.BufferSize, out.Latency = s.Output.BufferSize, s.Output.Latency
+	if err := out.Open(); err != nil {
+		return err
+	}
+	s.writing.Lock()
+	s.Output, s.id, s.streamClosed = out, id, false
+	s.writing.Unlock()
+	return nil
+}
+
+// Closes the port's stream, once any write to it ends, unless it is closed
+// already, as a failed stream is to reconnect it.
+func (s *SystemInPort) closeStream() error {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	if s.streamClosed {
+		return nil
+	}
+	s.streamClosed = true
+	return s.Output.Close()
+}
+
+// Opens the port with its buffers configured by c. The port's channel is
+// replaced, so a device must be wired to the port after it is opened, as
+// SystemDevice.OpenWith does.
+func (s *SystemInPort) OpenWith(c PortConfig) error {
+	if s.IsOpen() {
+		return fmt.Errorf("System port %d: %w", s.id, ErrAlreadyOpen)
+	}
+	s.configure(c)
+	if s.Output != nil {
+		s.Output.BufferSize, s.Output.Latency = c.StreamBufferSize, c.Latency
+	}
+	return s.Open()
+}
+
+// The most messages a SystemInPort takes from its channel to write at once,
+// so that dense sequencer output takes few writes to the stream.
+const writeBatchSize = 64
+
+// Appends to batch the messages already waiting in the channel, up to
+// writeBatchSize, without waiting for more.
+func (s *SystemInPort) takeQueued(batch []Message) []Message {
+	for len(batch) < writeBatchSize {
+		select {
+		case m := <-s.messages:
+			batch = append(batch, m)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// Writes messages in order, the short messages among them in as few writes
+// to the stream as the SysEx messages between them allow, returning how many
+// were written, or dropped if writing failed. In tests, only the first is.
+func (s *SystemInPort) writeMessages(messages []Message) (int, error) {
+	if s.write != nil || len(messages) == 1 {
+		return 1, s.writeMessage(messages[0])
+	}
+	return len(messages), s.writeEvents(messages)
+}
+
+func (s *SystemInPort) writeEvents(messages []Message) error {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	if s.streamClosed {
+		return ErrPortNotOpen
+	}
+	defer func(start time.Time) { s.stats.addStreamMessages(len(messages), time.Since(start)) }(time.Now())
+	events := s.events[:0]
+	defer func() { s.events = events[:0] }()
+	for _, m := range messages {
+		var at time.Duration
+		if t, ok := m.(TimedMessage); ok {
+			m, at = t.Message, t.Time
+		}
+		switch n := s.NoteOffs.convert(m).(type) {
+		case SysEx:
+			if err := s.Output.WriteEvents(events); err != nil {
+				return err
+			}
+			events = events[:0]
+			if err := s.Output.WriteSysExAt(n.Data, at); err != nil {
+				return err
+			}
+		case compound:
+			for _, m := range n.Messages() {
+				events = append(events, portmidi.Event{Message: m.Uint32(), Timestamp: at})
+			}
+		default:
+			events = append(events, portmidi.Event{Message: n.Uint32(), Timestamp: at})
+		}
+	}
+	return s.Output.WriteEvents(events)
+}
+
+// Writes the messages enqueued before the port was opened.
+func (s *SystemInPort) writePending() error {
+	s.holding.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.holding.Unlock()
+	for _, m := range pending {
+		if err := s.writeMessage(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SystemInPort) Connect() {
+	if !s.starting() {
+		return
+	}
+	defer s.connected.Done()
+	if err := s.writePending(); err != nil {
+		s.report(fmt.Errorf("System port %d: %w", s.id, err))
+	}
 	for {
+		waiting := time.Now()
 		select {
 		case m := <-s.messages:
-			if err := s.Output.Write(m); err != nil {
-				panic(err)
+			s.stats.addChannel(time.Since(waiting))
+			s.stats.addFill(len(s.messages) + 1)
+			s.batch = s.takeQueued(append(s.batch[:0], m))
+			for batch := s.batch; len(batch) > 0; {
+				n, err := s.writeMessages(batch)
+				if err != nil {
+					// The messages are dropped, and the port carries on.
+					s.stats.addDropped(n)
+					s.report(fmt.Errorf("System port %d: writing %v: %w", s.id, batch[:n], err))
+				}
+				batch = batch[n:]
+				if err != nil && s.ReconnectInterval != 0 {
+					s.closeStream()
+					if !s.reconnect(s.reopenStream) {
+						return
+					}
+				}
 			}
 		case <-s.disconnect:
+			// Write what is still queued so the tail of a sequence isn't lost.
+			s.flush()
 			return
 		}
 	}
 }
 
+// Message types that a SystemOutPort can be set to drop in the system's MIDI driver.
+const (
+	FILTER_ACTIVE_SENSING = portmidi.FilterActiveSensing
+	FILTER_SYSEX          = portmidi.FilterSysEx
+	FILTER_CLOCK          = portmidi.FilterClock
+	FILTER_PLAY           = portmidi.FilterPlay // Start, Continue and Stop.
+	FILTER_REALTIME       = portmidi.FilterRealTime
+	FILTER_NOTE           = portmidi.FilterNote
+	FILTER_AFTERTOUCH     = portmidi.FilterAftertouch
+	FILTER_PROGRAM        = portmidi.FilterProgram
+	FILTER_CONTROL        = portmidi.FilterControl
+	FILTER_PITCH_BEND     = portmidi.FilterPitchBend
+	FILTER_SYSTEM_COMMON  = portmidi.FilterSystemCommon
+)
+
+// A FilterConfig is the messages a SystemOutPort drops in the system's MIDI
+// driver, before they reach Go, as an alternative to the FILTER_ constants
+// and a channel mask.
+type FilterConfig struct {
+	ActiveSensing bool
+	SysEx         bool
+	Clock         bool
+	Play          bool // Start, Continue and Stop.
+	RealTime      bool // All system real-time messages.
+	Note          bool
+	Aftertouch    bool
+	Program       bool
+	Control       bool
+	PitchBend     bool
+	SystemCommon  bool
+	Channels      []int // The channels channel messages are kept on, or nil for all of them.
+}
+
+// Returns the FILTER_ constants for the message types dropped.
+func (f FilterConfig) filters() int {
+	var filters int
+	for _, flag := range []struct {
+		set    bool
+		filter int
+	}{
+		{f.ActiveSensing, FILTER_ACTIVE_SENSING},
+		{f.SysEx, FILTER_SYSEX},
+		{f.Clock, FILTER_CLOCK},
+		{f.Play, FILTER_PLAY},
+		{f.RealTime, FILTER_REALTIME},
+		{f.Note, FILTER_NOTE},
+		{f.Aftertouch, FILTER_AFTERTOUCH},
+		{f.Program, FILTER_PROGRAM},
+		{f.Control, FILTER_CONTROL},
+		{f.PitchBend, FILTER_PITCH_BEND},
+		{f.SystemCommon, FILTER_SYSTEM_COMMON},
+	} {
+		if flag.set {
+			filters |= flag.filter
+		}
+	}
+	return filters
+}
+
+// Returns the channels kept, every channel if none are set.
+func (f FilterConfig) channels() []int {
+	if f.Channels != nil {
+		return f.Channels
+	}
+	all := make([]int, 16)
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// How a system port sends note offs, which MIDI allows to be either note off
+// messages or note ons with a velocity of 0.
+type NoteOffs int
+
+const (
+	NoteOffsAsSent     NoteOffs = iota // Note offs are left as they are.
+	NoteOffsAsNoteOffs                 // Note ons with a velocity of 0 are sent as NoteOffs.
+	NoteOffsAsNoteOns                  // NoteOffs are sent as note ons with a velocity of 0, dropping their release velocity.
+)
+
+// Returns a note off in the form chosen, and any other message as is.
+func (n NoteOffs) convert(m Message) Message {
+	switch o := m.(type) {
+	case NoteOn:
+		if n == NoteOffsAsNoteOffs && o.Velocity == 0 {
+			return NoteOff{o.Channel, o.Key, 0}
+		}
+	case NoteOff:
+		if n == NoteOffsAsNoteOns {
+			return NoteOn{o.Channel, o.Key, 0}
+		}
+	}
+	return m
+}
+
+// How a SystemOutPort times the messages it reads.
+type Timestamps int
+
+const (
+	NoTimestamps       Timestamps = iota // Messages are sent as they are read.
+	AbsoluteTimestamps                   // Messages are sent as TimedMessages, timed by the clock returned by Time.
+	RelativeTimestamps                   // Messages are sent as TimedMessages, timed from when the port was opened.
+)
+
 type SystemOutPort struct {
 	SystemPort
 	*portmidi.Input
+	ControlChangeNames map[int]string // Overrides the package's ControlChangeNames for this port.
+	Timestamps         Timestamps
+	NoteOffs           NoteOffs       // How note offs read are sent.
+	Overflow           OverflowPolicy // What is done with a message read while the port's channel is full.
+	opened             time.Duration  // The time on the shared clock when the port was opened.
+	stats              portStats
+	filters            int                                         // Set by SetFilter, to set again when reconnecting.
+	channels           []int                                       // Set by SetChannelMask, to set again when reconnecting.
+	parsing            sync.Mutex                                  // Guards status and sysEx, which Reset discards from other goroutines.
+	status             byte                                        // The status of the last channel message read, for running status.
+	sysEx              []byte                                      // A SysEx message being read, which spans several reads.
+	batch              []portmidi.Event                            // Events are read into, many at a time.
+	pending            []portmidi.Event                            // Events read into batch and not yet sent.
+	read               func() (uint32, time.Duration, bool, error) // Replaces Input.Poll and ReadEvent in tests.
+}
+
+// Closes the port, closing its stream once the port stops being connected.
+// Closing a closed port does nothing.
+func (s *SystemOutPort) Close() error {
+	if stopped, err := s.stop(); !stopped {
+		return err
+	}
+	close(s.messages)
+	return s.closeStream()
+}
+
+// Drops the message types in filters, an OR of the FILTER_ constants, before they
+// are read, which is cheaper than filtering them with a connector.
+func (s *SystemOutPort) SetFilter(filters int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isOpen {
+		return fmt.Errorf("System port %d: %w", s.id, ErrPortNotOpen)
+	}
+	s.filters = filters
+	if s.streamClosed {
+		return nil // Set once the stream is reopened.
+	}
+	return s.Input.SetFilter(filters)
+}
+
+// Drops channel messages on channels other than those given before they are read.
+func (s *SystemOutPort) SetChannelMask(channels ...int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isOpen {
+		return fmt.Errorf("System port %d: %w", s.id, ErrPortNotOpen)
+	}
+	mask, err := channelMask(channels)
+	if err != nil {
+		return fmt.Errorf("System port %d: %w", s.id, err)
+	}
+	s.channels = channels
+	if s.streamClosed {
+		return nil // Set once the stream is reopened.
+	}
+	return s.Input.SetChannelMask(mask)
+}
+
+// Drops the messages the config describes before they are read, replacing
+// any filters and channel mask set before.
+func (s *SystemOutPort) SetFilterConfig(c FilterConfig) error {
+	if _, err := channelMask(c.Channels); err != nil {
+		return fmt.Errorf("System port %d: %w", s.id, err)
+	}
+	if err := s.SetFilter(c.filters()); err != nil {
+		return err
+	}
+	return s.SetChannelMask(c.channels()...)
+}
+
+// Returns a mask with the bit for each channel set.
+func channelMask(channels []int) (mask int, err error) {
+	for _, channel := range channels {
+		if channel < 0 || channel > 15 {
+			return 0, fmt.Errorf("Invalid channel %d", channel)
+		}
+		mask |= 1 << uint(channel)
+	}
+	return mask, nil
 }
 
 func (s *SystemOutPort) Open() error {
+	if s.Input == nil {
+		return fmt.Errorf("System port %d: %w", s.id, ErrNoStream)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.isOpen {
 		return nil
 	}
+	if s.stopped {
+		return fmt.Errorf("System port %d: %w", s.id, ErrPortClosed)
+	}
 	err := s.Input.Open()
 	if err == nil {
 		s.isOpen = true
+		s.opened = Time()
 	}
 	return err
 }
 
-func (s SystemOutPort) Connect() {
+// Opens the port with its buffers configured by c. The port's channel is
+// replaced, so a device must be wired to the port after it is opened, as
+// SystemDevice.OpenWith does.
+func (s *SystemOutPort) OpenWith(c PortConfig) error {
+	if s.IsOpen() {
+		return fmt.Errorf("System port %d: %w", s.id, ErrAlreadyOpen)
+	}
+	s.configure(c)
+	s.Overflow = c.Overflow
+	if s.Input != nil {
+		s.Input.BufferSize = c.StreamBufferSize
+	}
+	return s.Open()
+}
+
+// Returns a message read at a time on the shared clock, timed as set by Timestamps.
+func (s *SystemOutPort) timestamp(m Message, at time.Duration) Message {
+	switch s.Timestamps {
+	case AbsoluteTimestamps:
+		return TimedMessage{at, m}
+	case RelativeTimestamps:
+		return TimedMessage{at - s.opened, m}
+	}
+	return m
+}
+
+// Returns the messages written and dropped, the time spent writing them and
+// waiting for them, and how full the port's channel has been.
+func (s *SystemInPort) Stats() Stats {
+	return s.stats.get()
+}
+
+// Returns the messages read and dropped, the time spent reading them and
+// waiting to send them, and how full the port's channel has been.
+func (s *SystemOutPort) Stats() Stats {
+	return s.stats.get()
+}
+
+func (s *SystemOutPort) Connect() {
+	if !s.starting() {
+		return
+	}
+	defer s.connected.Done()
 	for {
 		select {
 		case <-s.disconnect:
 			return
 		default:
-			dataAvailable, err := s.Input.Poll()
+			reading := time.Now()
+			u, at, ok, err := s.readEvent()
+			if errors.Is(err, portmidi.ErrBufferOverflow) {
+				s.stats.addOverflow()
+				s.parsing.Lock()
+				s.sysEx = nil
+				s.parsing.Unlock()
+				s.report(fmt.Errorf("System port %d: %w", s.id, err))
+				continue
+			}
 			if err != nil {
-				panic(err)
+				s.report(fmt.Errorf("System port %d: %w", s.id, err))
 			}
-			if !dataAvailable {
-				time.Sleep(1 * time.Millisecond)
+			if err != nil && s.ReconnectInterval == 0 {
+				return // The stream failed, so nothing more can be read.
+			}
+			if err != nil {
+				s.closeStream()
+				s.resetParser()
+				if !s.reconnect(s.reopenStream) {
+					return
+				}
 				continue
 			}
-			m := newMessage(s.Input.Read())
-			switch m.Command {
-			case NOTE_ON:
-				s.messages <- NoteOn{m.Channel, m.Data1, m.Data2}
-			case NOTE_OFF:
-				// A NoteOn with velocity 0 (Data2) is arguably a Note Off.
-				s.messages <- NoteOff{m.Channel, m.Data1, 0}
-			case CONTROL_CHANGE:
-				name, ok := ControlChangeNames[m.Data1]
-				if !ok {
-					name = "Unknown"
+			if !ok {
+				// Wait for the stream, which with PortMidi is polled less often while idle.
+				select {
+				case <-s.Input.Ready():
+				case <-s.disconnect:
+					return
 				}
-				s.messages <- ControlChange{m.Channel, m.Data1, m.Data2, name}
+				continue
+			}
+			m, ok := s.message(u)
+			if !ok {
+				continue
+			}
+			s.stats.addStream(time.Since(reading))
+			m = s.NoteOffs.convert(m)
+			sending := time.Now()
+			if !s.send(s.timestamp(m, at)) {
+				return
+			}
+			s.stats.addChannel(time.Since(sending))
+			s.stats.addFill(len(s.messages))
+		}
+	}
+}
+
+// Sends a message read, dropping one if the channel is full as the Overflow
+// policy says. Returns false if the port is closed while waiting for room.
+func (s *SystemOutPort) send(m Message) bool {
+	switch s.Overflow {
+	case OverflowDropsNewest:
+		select {
+		case s.messages <- m:
+		default:
+			s.stats.addDropped(1)
+		}
+		return true
+	case OverflowDropsOldest:
+		for {
+			select {
+			case s.messages <- m:
+				return true
+			default:
+			}
+			select {
+			case <-s.messages:
+				s.stats.addDropped(1)
 			default:
-				fmt.Printf("Unknown message type received and ignored: %+v", m)
 			}
 		}
 	}
+	select {
+	case s.messages <- m:
+		return true
+	case <-s.disconnect:
+		return false
+	}
+}
+
+// Closes the port's stream, once SetFilter and SetChannelMask, which use it
+// from other goroutines, are done with it, unless it is closed already, as a
+// failed stream is to reconnect it.
+func (s *SystemOutPort) closeStream() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streamClosed {
+		return nil
+	}
+	s.streamClosed = true
+	return s.Input.Close()
+}
+
+// Opens the stream of the port's device again, for reconnecting, with the
+// filters and channel mask that were set.
+func (s *SystemOutPort) reopenStream() error {
+	id, err := s.findStream(true)
+	if err != nil {
+		return err
+	}
+	in := portmidi.NewInput(id)
+	in.BufferSize = s.Input.BufferSize
+	if err := in.Open(); err != nil {
+		return err
+	}
+	s.mu.Lock() // SetFilter and SetChannelMask use the stream from other goroutines.
+	defer s.mu.Unlock()
+	if s.filters != 0 {
+		in.SetFilter(s.filters)
+	}
+	if s.channels != nil {
+		mask, _ := channelMask(s.channels)
+		in.SetChannelMask(mask)
+	}
+	s.Input, s.id, s.streamClosed = in, id, false
+	return nil
+}
+
+// The most events a SystemOutPort reads from its stream at once, so that a
+// burst of messages, like a SysEx dump, takes few reads.
+const readBatchSize = 64
+
+// Reads a message from the stream if one is available, reading the events
+// available in a batch once those read before are sent.
+// Messages lost to a buffer overflow are reported with portmidi.ErrBufferOverflow.
+func (s *SystemOutPort) readEvent() (uint32, time.Duration, bool, error) {
+	if s.read != nil {
+		return s.read()
+	}
+	if len(s.pending) == 0 {
+		if s.batch == nil {
+			s.batch = make([]portmidi.Event, readBatchSize)
+		}
+		n, err := s.Input.ReadEvents(s.batch)
+		if err != nil || n == 0 {
+			return 0, 0, false, err
+		}
+		s.pending = s.batch[:n]
+	}
+	e := s.pending[0]
+	s.pending = s.pending[1:]
+	return e.Message, e.Timestamp, true, nil
+}
+
+// Returns the message for a message read, or false while a SysEx message is
+// incomplete. PortMidi reads SysEx messages four bytes at a time, reading any
+// realtime messages sent during them in between.
+func (s *SystemOutPort) message(u uint32) (Message, bool) {
+	s.parsing.Lock()
+	defer s.parsing.Unlock()
+	status := int(u & 0xFF)
+	if status >= CLOCK {
+		return newMessage(u).typed(s.ControlChangeNames), true
+	}
+	if s.sysEx != nil && status&0x80 != 0 && status != 0xF7 {
+		Debug.Printf("System port %d: dropped a SysEx message ended by 0x%02X instead of 0xF7", s.id, status)
+		s.sysEx = nil
+	}
+	if s.sysEx == nil && status != SYSEX {
+		return s.runningStatus(u).typed(s.ControlChangeNames), true
+	}
+	s.status = 0
+	for i := uint(0); i < 4; i++ {
+		c := byte(u >> (8 * i))
+		s.sysEx = append(s.sysEx, c)
+		if c == 0xF7 {
+			m := SysEx{s.sysEx}
+			s.sysEx = nil
+			return m, true
+		}
+	}
+	if len(s.sysEx) > maxSysExLength {
+		Debug.Printf("System port %d: dropped a SysEx message longer than %d bytes", s.id, maxSysExLength)
+		s.sysEx = nil
+	}
+	return nil, false
+}
+
+// Discards the running status and any incomplete SysEx message read.
+func (s *SystemOutPort) resetParser() {
+	s.parsing.Lock()
+	s.status, s.sysEx = 0, nil
+	s.parsing.Unlock()
+	s.Port.resetParser()
+}
+
+// Returns the message for a message read, with the status of the last channel
+// message read if it was sent with running status (leaving its status out.)
+func (s *SystemOutPort) runningStatus(u uint32) *message {
+	switch status := byte(u); {
+	case status < 0x80:
+		if s.status != 0 {
+			u = u<<8 | uint32(s.status)
+		}
+	case status < 0xF0:
+		s.status = status
+	default:
+		s.status = 0
+	}
+	return newMessage(u)
 }
@@ -0,0 +1,765 @@
+package midi
+
+import (
+	"errors"
+	"github.com/aoeu/audio/midi/portmidi"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSystemPortErrors(t *testing.T) {
+	var in SystemInPort
+	if err := in.Open(); !errors.Is(err, ErrNoStream) {
+		t.Errorf("Received %v from opening a port without a stream instead of %v",
+			err, ErrNoStream)
+	}
+	if err := in.Close(); !errors.Is(err, ErrPortNotOpen) {
+		t.Errorf("Received %v from closing an unopened port instead of %v",
+			err, ErrPortNotOpen)
+	}
+	var out SystemOutPort
+	if err := out.Close(); !errors.Is(err, ErrPortNotOpen) {
+		t.Errorf("Received %v from closing an unopened port instead of %v",
+			err, ErrPortNotOpen)
+	}
+	in.Output = portmidi.NewOutput(0)
+	in.isOpen = true
+	if err := in.Open(); err != nil {
+		t.Errorf("Received %v from opening an open port", err)
+	}
+	if err := in.OpenWith(PortConfig{}); !errors.Is(err, ErrAlreadyOpen) {
+		t.Errorf("Received %v from opening an open port with a config instead of %v",
+			err, ErrAlreadyOpen)
+	}
+	if err := out.SetFilter(FILTER_CLOCK); !errors.Is(err, ErrPortNotOpen) {
+		t.Errorf("Received %v from filtering an unopened port instead of %v",
+			err, ErrPortNotOpen)
+	}
+	var d SystemDevice
+	if err := d.Open(); !errors.Is(err, ErrNoStream) {
+		t.Errorf("Received %v from opening a device without ports instead of %v",
+			err, ErrNoStream)
+	}
+}
+
+func TestSystemDeviceInterface(t *testing.T) {
+	devices, err := GetDevices()
+	if err != nil {
+		t.Fatalf("Could not get devices: %v", err)
+	}
+	for name, d := range devices {
+		if d.Interface == "" {
+			t.Errorf("No interface was reported for device %q", name)
+		}
+	}
+}
+
+func TestVirtualDeviceUnsupported(t *testing.T) {
+	// The PortMidi backend, built by default, can't create virtual ports.
+	d := NewVirtualDevice("Test")
+	if err := d.Open(); !errors.Is(err, ErrNoVirtualPorts) {
+		t.Errorf("Received %v from opening a virtual device instead of %v",
+			err, ErrNoVirtualPorts)
+	}
+}
+
+func TestSystemInPortFlush(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Output:     portmidi.NewOutput(0),
+	}
+	in.messages = make(chan Message, 4)
+	var written []Message
+	in.write = func(m Message) error {
+		written = append(written, m)
+		return nil
+	}
+	expected := []Message{NoteOn{0, 60, 100}, NoteOff{0, 60, 0}, ControlChange{0, 1, 2, ""}}
+	for _, m := range expected {
+		in.messages <- m
+	}
+	in.Close()
+	if len(written) != len(expected) {
+		t.Fatalf("Wrote %v when closing the port instead of %v", written, expected)
+	}
+	for i := range expected {
+		if written[i] != expected[i] {
+			t.Errorf("Wrote %v when closing the port instead of %v", written, expected)
+		}
+	}
+}
+
+func TestSystemInPortEnqueue(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Output:     portmidi.NewOutput(0),
+	}
+	var written []Message
+	in.write = func(m Message) error {
+		written = append(written, m)
+		return nil
+	}
+	go in.Connect()
+	expected := []Message{
+		NoteOn{2, 64, 0},
+		SysEx{[]byte{0xF0, 0x00, 0x20, 0x29, 0x02, 0xF7}},
+		ControlChange{2, 0, 1, ""},
+		SysEx{[]byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+		NoteOff{2, 64, 0},
+	}
+	in.Enqueue(expected...)
+	in.Close()
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Wrote %v instead of %v", written, expected)
+	}
+}
+
+func TestBufferFill(t *testing.T) {
+	s := SystemPort{Port: *NewPort(true)}
+	s.messages = make(chan Message, 8)
+	for i := 0; i < 3; i++ {
+		s.messages <- NoteOn{0, 60 + i, 100}
+	}
+	if used, capacity := s.BufferFill(); used != 3 || capacity != 8 {
+		t.Errorf("Received a buffer fill of %d/%d instead of 3/8", used, capacity)
+	}
+}
+
+func TestSystemInPortStopFlush(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Output:     portmidi.NewOutput(0),
+	}
+	in.messages = make(chan Message, 4)
+	writing, release := make(chan bool), make(chan bool)
+	var written []Message
+	in.write = func(m Message) error {
+		if len(written) == 0 {
+			writing <- true
+			<-release
+		}
+		written = append(written, m)
+		return nil
+	}
+	stopped := make(chan bool)
+	go func() {
+		in.Connect()
+		close(stopped)
+	}()
+	expected := []Message{NoteOn{0, 60, 100}, NoteOn{0, 64, 100}, NoteOff{0, 60, 0}, NoteOff{0, 64, 0}}
+	in.messages <- expected[0]
+	<-writing
+	for _, m := range expected[1:] {
+		in.messages <- m
+	}
+	// Stop while the first message is being written, with the rest queued.
+	close(in.disconnect)
+	close(release)
+	<-stopped
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Wrote %v when stopping instead of %v", written, expected)
+	}
+}
+
+func TestSystemInPortStats(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Output:     portmidi.NewOutput(0),
+	}
+	in.write = func(m Message) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+	go in.Connect()
+	in.Enqueue(NoteOn{0, 60, 100}, NoteOff{0, 60, 0})
+	in.Close()
+	stats := in.Stats()
+	if stats.Messages != 2 {
+		t.Errorf("Counted %d messages written instead of 2", stats.Messages)
+	}
+	if stats.StreamTime < 40*time.Millisecond {
+		t.Errorf("Counted %v spent writing instead of at least %v", stats.StreamTime, 40*time.Millisecond)
+	}
+}
+
+func TestChannelMask(t *testing.T) {
+	tests := []struct {
+		channels []int
+		mask     int
+	}{
+		{nil, 0},
+		{[]int{0}, 0x0001},
+		{[]int{0, 9, 15}, 0x8201},
+		{[]int{1, 1, 2}, 0x0006},
+	}
+	for _, test := range tests {
+		if mask, err := channelMask(test.channels); err != nil || mask != test.mask {
+			t.Errorf("Received mask 0x%04X (%v) for channels %v instead of 0x%04X",
+				mask, err, test.channels, test.mask)
+		}
+	}
+	if _, err := channelMask([]int{16}); err == nil {
+		t.Error("Received no error from masking channel 16")
+	}
+}
+
+func TestFilterConfig(t *testing.T) {
+	f := FilterConfig{ActiveSensing: true, Clock: true}
+	if filters := f.filters(); filters != FILTER_ACTIVE_SENSING|FILTER_CLOCK {
+		t.Errorf("Received filters 0x%X instead of 0x%X", filters, FILTER_ACTIVE_SENSING|FILTER_CLOCK)
+	}
+	if mask, _ := channelMask(f.channels()); mask != 0xFFFF {
+		t.Errorf("Received mask 0x%04X for no channels set instead of every channel", mask)
+	}
+	f.Channels = []int{9}
+	if mask, _ := channelMask(f.channels()); mask != 0x0200 {
+		t.Errorf("Received mask 0x%04X for channel 9 instead of 0x0200", mask)
+	}
+	var out SystemOutPort
+	if err := out.SetFilterConfig(FilterConfig{Channels: []int{16}}); err == nil {
+		t.Error("Received no error from keeping channel 16")
+	}
+	if err := out.SetFilterConfig(f); !errors.Is(err, ErrPortNotOpen) {
+		t.Errorf("Received %v from filtering an unopened port instead of %v", err, ErrPortNotOpen)
+	}
+}
+
+func TestSystemPortsShareClock(t *testing.T) {
+	in := SystemInPort{SystemPort: SystemPort{id: 0}}
+	out := SystemOutPort{SystemPort: SystemPort{id: 1}}
+	times := []time.Duration{in.Time(), out.Time(), in.Time(), Time()}
+	for i := 1; i < len(times); i++ {
+		if times[i] < times[i-1] {
+			t.Errorf("Read times %v from two ports, which aren't on the same timeline", times)
+		}
+	}
+}
+
+func TestSystemOutPortTimestamps(t *testing.T) {
+	out := SystemOutPort{opened: Time()} // As when opened.
+	m := NoteOn{0, 60, 100}
+	if actual := out.timestamp(m, Time()); actual != m {
+		t.Errorf("Received %v without timestamps instead of %v", actual, m)
+	}
+	out.Timestamps = RelativeTimestamps
+	timed, ok := out.timestamp(m, Time()).(TimedMessage)
+	if !ok || timed.Message != m || timed.Time < 0 || timed.Time > 10*time.Millisecond {
+		t.Errorf("Received %v with relative timestamps instead of %v near zero", timed, m)
+	}
+	out.Timestamps = AbsoluteTimestamps
+	at := out.opened + time.Second
+	if actual := out.timestamp(m, at); actual != (TimedMessage{at, m}) {
+		t.Errorf("Received %v with absolute timestamps instead of %v", actual, TimedMessage{at, m})
+	}
+}
+
+func TestSystemInPortSendBeforeOpen(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(false)},
+		Output:     portmidi.NewOutput(0),
+	}
+	var written []Message
+	in.write = func(m Message) error {
+		written = append(written, m)
+		return nil
+	}
+	expected := []Message{NoteOn{0, 60, 100}, NoteOff{0, 60, 0}}
+	sent := make(chan bool)
+	go func() {
+		in.Enqueue(expected...)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Sending to a port before opening it blocked")
+	}
+	in.isOpen = true // As when opened.
+	go in.Connect()
+	in.Close()
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Wrote %v instead of the messages sent before opening, %v", written, expected)
+	}
+}
+
+func TestSystemInPortPendingLimit(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(false)},
+		Output:     portmidi.NewOutput(0),
+	}
+	for i := 0; i < MaxPendingMessages+10; i++ {
+		in.Enqueue(NoteOn{0, i % 128, 100})
+	}
+	if n := len(in.pending); n != MaxPendingMessages {
+		t.Errorf("Held %d messages before opening instead of %d", n, MaxPendingMessages)
+	}
+	if first := in.pending[0]; first != (NoteOn{0, 10, 100}) {
+		t.Errorf("Held %v first instead of dropping the oldest messages", first)
+	}
+	if dropped := in.Stats().Dropped; dropped != 10 {
+		t.Errorf("Counted %d messages dropped instead of 10", dropped)
+	}
+}
+
+func TestSystemOutPortOverflow(t *testing.T) {
+	out := &SystemOutPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Input:      portmidi.NewInput(0),
+	}
+	reads := []error{portmidi.ErrBufferOverflow, nil}
+	out.read = func() (uint32, time.Duration, bool, error) {
+		if len(reads) == 0 {
+			return 0, 0, false, nil
+		}
+		err := reads[0]
+		reads = reads[1:]
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return NoteOn{0, 60, 100}.Uint32(), 0, true, nil
+	}
+	go out.Connect()
+	if m := <-out.messages; m != (NoteOn{0, 60, 100}) {
+		t.Errorf("Received %v after an overflow instead of the next message", m)
+	}
+	close(out.disconnect)
+	if stats := out.Stats(); stats.Overflows != 1 || stats.Messages != 1 {
+		t.Errorf("Received stats %+v instead of 1 overflow and 1 message", stats)
+	}
+}
+
+func TestSystemOutPortReconnect(t *testing.T) {
+	out := &SystemOutPort{
+		SystemPort: SystemPort{Port: *NewPort(true), ReconnectInterval: time.Millisecond},
+		Input:      portmidi.NewInput(0),
+	}
+	unplugged := errors.New("Device unplugged")
+	reads := []error{unplugged, nil}
+	out.read = func() (uint32, time.Duration, bool, error) {
+		if len(reads) == 0 {
+			return 0, 0, false, nil
+		}
+		err := reads[0]
+		reads = reads[1:]
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return NoteOn{0, 60, 100}.Uint32(), 0, true, nil
+	}
+	reopens := make(chan bool, 3)
+	out.reopen = func() error {
+		reopens <- true
+		if len(reopens) < 2 {
+			return unplugged // Not plugged in again yet.
+		}
+		return nil
+	}
+	go out.Connect()
+	defer close(out.disconnect)
+	select {
+	case m := <-out.messages:
+		if m != (NoteOn{0, 60, 100}) {
+			t.Errorf("Received %v after reconnecting instead of the next message", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Received nothing after the stream failed")
+	}
+	if len(reopens) != 2 {
+		t.Errorf("Reopened the stream %d times instead of until it was plugged in again", len(reopens))
+	}
+}
+
+func TestSystemInPortReconnect(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(true), ReconnectInterval: time.Millisecond},
+		Output:     portmidi.NewOutput(0),
+	}
+	plugged := make(chan bool, 1)
+	written := make(chan Message, 2)
+	in.write = func(m Message) error {
+		select {
+		case <-plugged:
+			written <- m
+			plugged <- true
+			return nil
+		default:
+			return errors.New("Device unplugged")
+		}
+	}
+	in.reopen = func() error {
+		plugged <- true
+		return nil
+	}
+	go in.Connect()
+	defer in.Close()
+	in.messages <- NoteOn{0, 60, 100} // Lost as the device is unplugged.
+	in.messages <- NoteOff{0, 60, 0}
+	select {
+	case m := <-written:
+		if m != (NoteOff{0, 60, 0}) {
+			t.Errorf("Wrote %v after reconnecting instead of the next message", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wrote nothing after the stream failed")
+	}
+}
+
+func TestSystemOutPortCloseReconnecting(t *testing.T) {
+	out := &SystemOutPort{
+		SystemPort: SystemPort{Port: *NewPort(true), ReconnectInterval: time.Millisecond},
+		Input:      portmidi.NewInput(0),
+	}
+	out.isOpen = true
+	out.read = func() (uint32, time.Duration, bool, error) {
+		return 0, 0, false, errors.New("Device unplugged")
+	}
+	reopening := make(chan bool, 1)
+	out.reopen = func() error {
+		select {
+		case reopening <- true:
+		default:
+		}
+		return errors.New("Device unplugged")
+	}
+	go out.Connect()
+	<-reopening
+	// The failed stream was closed to reconnect, so isn't closed again.
+	if err := out.Close(); err != nil {
+		t.Errorf("Received %v from closing a port while it reconnected", err)
+	}
+}
+
+// Returns an open SystemOutPort that reads the messages, as PortMidi returns them.
+func newReadingPort(reads ...uint32) *SystemOutPort {
+	out := &SystemOutPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Input:      portmidi.NewInput(0),
+	}
+	out.read = func() (uint32, time.Duration, bool, error) {
+		if len(reads) == 0 {
+			return 0, 0, false, nil
+		}
+		u := reads[0]
+		reads = reads[1:]
+		return u, 0, true, nil
+	}
+	return out
+}
+
+func TestSystemOutPortReadsTypedMessages(t *testing.T) {
+	messages := []Message{
+		PolyAftertouch{1, 60, 80},
+		ProgramChange{2, 42},
+		ChannelPressure{3, 90},
+		PitchBend{4, 12000},
+		Start{},
+		Clock{},
+		Clock{},
+		Stop{},
+		Continue{},
+		Clock{},
+	}
+	var reads []uint32
+	for _, m := range messages {
+		reads = append(reads, m.Uint32())
+	}
+	out := newReadingPort(reads...)
+	go out.Connect()
+	defer close(out.disconnect)
+	for _, expected := range messages {
+		if m := <-out.messages; !reflect.DeepEqual(m, expected) {
+			t.Errorf("Read %v instead of %v", m, expected)
+		}
+	}
+}
+
+func TestSystemOutPortReadsSysEx(t *testing.T) {
+	// As PortMidi reads them: four bytes at a time, with realtime messages in between.
+	reads := []uint32{
+		0x067F7EF0, uint32(CLOCK), 0xF701,
+		0x000000F0, 0x00000000, 0xF7,
+		0x017F7EF0, NoteOn{0, 60, 100}.Uint32(),
+	}
+	expected := []Message{
+		Clock{},
+		SysEx{[]byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+		SysEx{[]byte{0xF0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF7}},
+		NoteOn{0, 60, 100},
+	}
+	out := newReadingPort(reads...)
+	go out.Connect()
+	defer close(out.disconnect)
+	for _, m := range expected {
+		if actual := <-out.messages; !reflect.DeepEqual(actual, m) {
+			t.Errorf("Read %v instead of %v", actual, m)
+		}
+	}
+}
+
+func TestSystemOutPortRunningStatus(t *testing.T) {
+	reads := []uint32{0x643C90, 0x6440, uint32(CLOCK), 0x3C, 0x05C1, 0x06, 0x02F3, 0x07}
+	expected := []Message{
+		NoteOn{0, 60, 100},
+		NoteOn{0, 64, 100},
+		Clock{},
+		NoteOn{0, 60, 0},
+		ProgramChange{1, 5},
+		ProgramChange{1, 6},
+		SongSelect{2},
+		*newMessage(0x07), // No running status after a system message.
+	}
+	out := newReadingPort(reads...)
+	go out.Connect()
+	defer close(out.disconnect)
+	for _, m := range expected {
+		if actual := <-out.messages; !reflect.DeepEqual(actual, m) {
+			t.Errorf("Read %v instead of %v", actual, m)
+		}
+	}
+}
+
+func TestNoteOffs(t *testing.T) {
+	out := newReadingPort(NoteOn{0, 60, 0}.Uint32(), NoteOff{0, 61, 64}.Uint32(), NoteOn{0, 62, 100}.Uint32())
+	out.NoteOffs = NoteOffsAsNoteOffs
+	go out.Connect()
+	defer close(out.disconnect)
+	for _, expected := range []Message{NoteOff{0, 60, 0}, NoteOff{0, 61, 64}, NoteOn{0, 62, 100}} {
+		if m := <-out.messages; m != expected {
+			t.Errorf("Read %v instead of %v", m, expected)
+		}
+	}
+
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Output:     portmidi.NewOutput(0),
+		NoteOffs:   NoteOffsAsNoteOns,
+	}
+	var written []Message
+	in.write = func(m Message) error {
+		written = append(written, m)
+		return nil
+	}
+	for _, m := range []Message{NoteOff{1, 60, 64}, NoteOn{1, 60, 0}, NoteOn{1, 62, 100}} {
+		in.writeMessage(m)
+	}
+	expected := []Message{NoteOn{1, 60, 0}, NoteOn{1, 60, 0}, NoteOn{1, 62, 100}}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Wrote %v instead of %v", written, expected)
+	}
+}
+
+func TestSystemInPortWritesTimedMessages(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Output:     portmidi.NewOutput(0),
+	}
+	var written []Message
+	in.write = func(m Message) error {
+		written = append(written, m)
+		return nil
+	}
+	sysEx := SysEx{[]byte{0xF0, 0x7E, 0xF7}}
+	in.writeMessage(TimedMessage{time.Second, sysEx})
+	if len(written) != 1 || !reflect.DeepEqual(written[0], sysEx) {
+		t.Errorf("Wrote %v instead of %v", written, sysEx)
+	}
+}
+
+func TestSystemOutPortOverflowPolicy(t *testing.T) {
+	tests := map[OverflowPolicy][]Message{
+		OverflowDropsNewest: {NoteOn{0, 60, 100}, NoteOn{0, 61, 100}},
+		OverflowDropsOldest: {NoteOn{0, 61, 100}, NoteOn{0, 62, 100}},
+	}
+	for policy, expected := range tests {
+		out := &SystemOutPort{SystemPort: SystemPort{Port: *NewPort(false)}, Overflow: policy}
+		out.configure(PortConfig{BufferSize: 2})
+		for key := 60; key < 63; key++ {
+			out.send(NoteOn{0, key, 100})
+		}
+		if stats := out.Stats(); stats.Dropped != 1 {
+			t.Errorf("Dropped %d messages with policy %d instead of 1", stats.Dropped, policy)
+		}
+		for _, e := range expected {
+			if m := <-out.messages; m != e {
+				t.Errorf("Received %v with policy %d instead of %v", m, policy, e)
+			}
+		}
+	}
+}
+
+func TestSystemDeviceOpenWith(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(false)},
+		Output:     portmidi.NewOutput(-1), // Not a stream, so opening fails.
+	}
+	in.messages <- NoteOn{0, 60, 100} // Sent before opening.
+	d := SystemDevice{in: in, Wires: Wires{In: in.messages}}
+	c := PortConfig{BufferSize: 8, StreamBufferSize: 1024, Latency: 10 * time.Millisecond}
+	if err := d.OpenWith(c); err == nil {
+		t.Error("Opened a device without a stream")
+	}
+	if d.Wires.In != in.messages || cap(d.Wires.In) != 8 {
+		t.Errorf("The device is wired to a channel buffering %d messages instead of its port's, of 8", cap(d.Wires.In))
+	}
+	if m := <-d.Wires.In; m != (NoteOn{0, 60, 100}) {
+		t.Errorf("Received %v instead of the message sent before opening", m)
+	}
+	if in.Output.BufferSize != 1024 || in.Output.Latency != 10*time.Millisecond {
+		t.Errorf("Configured the stream with a buffer of %d events and a latency of %v instead of %+v",
+			in.Output.BufferSize, in.Output.Latency, c)
+	}
+}
+
+func TestSystemPortErrorsReported(t *testing.T) {
+	out := &SystemOutPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Input:      portmidi.NewInput(0),
+	}
+	failed := errors.New("Device unplugged")
+	out.read = func() (uint32, time.Duration, bool, error) { return 0, 0, false, failed }
+	stopped := make(chan bool)
+	go func() {
+		out.Connect()
+		close(stopped)
+	}()
+	if err := <-out.Errors(); !errors.Is(err, failed) {
+		t.Errorf("Received %v from a failed stream instead of %v", err, failed)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Kept reading from a failed stream")
+	}
+
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Output:     portmidi.NewOutput(0),
+	}
+	written := make(chan Message, 1)
+	in.write = func(m Message) error {
+		if m == (NoteOn{0, 60, 100}) {
+			return failed
+		}
+		written <- m
+		return nil
+	}
+	pipe := NewPipe(NewDevice(), NewDeviceFromPorts(in, nil))
+	errs := pipe.Errors()
+	go in.Connect()
+	defer in.Close()
+	in.messages <- NoteOn{0, 60, 100}
+	in.messages <- NoteOff{0, 60, 0}
+	if err := <-errs; !errors.Is(err, failed) {
+		t.Errorf("Received %v from the pipe for a failed write instead of %v", err, failed)
+	}
+	if m := <-written; m != (NoteOff{0, 60, 0}) {
+		t.Errorf("Wrote %v after a failed write instead of the next message", m)
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	in, out := NewLoopback()
+	sending := NewDeviceFromPorts(in, nil)
+	if err := sending.Open(); err != nil {
+		t.Fatal(err)
+	}
+	to := NewMemDevice()
+	pipe := NewPipe(NewDeviceFromPorts(nil, out), to.Device)
+	if err := pipe.Open(); err != nil {
+		t.Fatal(err)
+	}
+	go pipe.Connect()
+	expected := []Message{NoteOn{0, 60, 100}, NoteOff{0, 60, 0}}
+	for _, m := range expected {
+		sending.In <- m
+	}
+	if actual := waitForReceived(to, len(expected)); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Received %v through the loopback instead of %v", actual, expected)
+	}
+	if err := pipe.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := sending.Close(); err != nil {
+		t.Errorf("Received %v from closing the sending side after the receiving side", err)
+	}
+}
+
+func TestSystemPortCloseWaitsForConnect(t *testing.T) {
+	out := &SystemOutPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Input:      portmidi.NewInput(0),
+	}
+	reading, release := make(chan bool), make(chan bool)
+	out.read = func() (uint32, time.Duration, bool, error) {
+		reading <- true
+		<-release
+		return 0, 0, false, nil
+	}
+	go out.Connect()
+	<-reading
+	closed := make(chan error)
+	go func() { closed <- out.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Closed the port's stream while it was being read")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-closed // Closing the stream fails, as it was never opened.
+	if err := out.Close(); err != nil {
+		t.Errorf("Received %v from closing a closed port instead of nothing", err)
+	}
+	if err := out.Open(); !errors.Is(err, ErrPortClosed) {
+		t.Errorf("Received %v from opening a closed port instead of %v", err, ErrPortClosed)
+	}
+}
+
+func TestPortConcurrentClose(t *testing.T) {
+	p := NewPort(true)
+	d := NewDeviceFromPorts(p, nil)
+	done := make(chan bool)
+	for i := 0; i < 8; i++ {
+		go func() {
+			d.Connect()
+			p.Close() // Closes the port once, however many close it at once.
+			done <- true
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+	if p.IsOpen() {
+		t.Error("The port is open after closing it")
+	}
+}
+
+func TestSystemInPortDroppedAndHighWater(t *testing.T) {
+	in := &SystemInPort{
+		SystemPort: SystemPort{Port: *NewPort(true)},
+		Output:     portmidi.NewOutput(0),
+	}
+	in.messages = make(chan Message, 4)
+	written := make(chan Message, 2)
+	in.write = func(m Message) error {
+		if m == (NoteOn{0, 60, 100}) {
+			return errors.New("Device unplugged")
+		}
+		written <- m
+		return nil
+	}
+	for _, m := range []Message{NoteOn{0, 60, 100}, NoteOff{0, 60, 0}, NoteOn{0, 62, 100}} {
+		in.messages <- m
+	}
+	go in.Connect()
+	<-written
+	<-written
+	in.Close()
+	stats := in.Stats()
+	if stats.Dropped != 1 {
+		t.Errorf("Counted %d messages dropped instead of the 1 that failed to be written", stats.Dropped)
+	}
+	if stats.HighWater != 3 {
+		t.Errorf("Counted a high-water mark of %d instead of the 3 messages queued", stats.HighWater)
+	}
+}
@@ -0,0 +1,126 @@
+package midi
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A TimedMessage is a Message with the time it was sent, relative to some start time.
+type TimedMessage struct {
+	Time time.Duration
+	Message
+}
+
+// A Track is a sequence of timed messages, in time order.
+type Track []TimedMessage
+
+// Sorts events by time, breaking ties by Priority.
+func sortTimed(events []TimedMessage) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Time != events[j].Time {
+			return events[i].Time < events[j].Time
+		}
+		return Priority(events[i].Message) < Priority(events[j].Message)
+	})
+}
+
+// A Recorder transmits MIDI data from one device to another, recording it
+// along with when it was sent (relative to when the Recorder was connected.)
+// Implements Connector, one to one.
+type Recorder struct {
+	Name       string // Identifies the connector in logs.
+	From       *Device
+	To         *Device
+	mu         sync.Mutex
+	start      time.Time
+	recorded   Track
+	disconnect chan bool
+}
+
+// Creates a new Recorder between the devices sent as parameters.
+func NewRecorder(from, to *Device) *Recorder {
+	return &Recorder{
+		From:       from,
+		To:         to,
+		disconnect: make(chan bool, 1),
+	}
+}
+
+func (r *Recorder) Open() error {
+	if err := r.From.Open(); err != nil {
+		return err
+	}
+	return r.To.Open()
+}
+
+// Ends transmission of MIDI data and closes the connected MIDI devices.
+func (r *Recorder) Close() error {
+	Debug.Printf("%v closed", r)
+	r.disconnect <- true
+	if err := r.From.Close(); err != nil {
+		return err
+	}
+	return r.To.Close()
+}
+
+// Begins transmission and recording of MIDI data between the connected MIDI devices.
+func (r *Recorder) Connect() {
+	Debug.Printf("%v connected", r)
+	go r.From.Connect()
+	go r.To.Connect()
+	r.mu.Lock()
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+	r.mu.Unlock()
+	for {
+		select {
+		case m := <-r.From.Out:
+			r.mu.Lock()
+			r.recorded = append(r.recorded, TimedMessage{time.Since(r.start), m})
+			r.mu.Unlock()
+			if !r.To.sendFrom(m, r.disconnect) {
+				return
+			}
+		case <-r.disconnect:
+			return
+		}
+	}
+}
+
+// Returns everything recorded so far as one track.
+func (r *Recorder) Track() Track {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(Track(nil), r.recorded...)
+}
+
+// Returns everything recorded so far split into a track per channel, in
+// channel order, as in a format 1 Standard MIDI File. Messages that
+// aren't sent on a channel are put in a leading track of their own.
+func (r *Recorder) Tracks() []Track {
+	var common Track
+	channels := make(map[int]Track)
+	for _, m := range r.Track() {
+		if channel, ok := channelOf(m.Message); ok {
+			channels[channel] = append(channels[channel], m)
+		} else {
+			common = append(common, m)
+		}
+	}
+	var tracks []Track
+	if len(common) > 0 {
+		tracks = append(tracks, common)
+	}
+	for channel := 0; channel < 16; channel++ {
+		if t, ok := channels[channel]; ok {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+func (r *Recorder) String() string {
+	return connectorName("Recorder", r.Name)
+}
@@ -0,0 +1,68 @@
+package midi
+
+import (
+	"testing"
+)
+
+func TestRecorderTracks(t *testing.T) {
+	recorder := NewRecorder(NewDevice(), NewDevice())
+	recorder.Open()
+	go recorder.Connect()
+	defer recorder.Close()
+	in := []Message{
+		NoteOn{0, 60, 100},
+		NoteOn{1, 48, 90},
+		NoteOff{0, 60, 0},
+		NoteOff{1, 48, 0},
+		NoteOn{0, 62, 100},
+	}
+	for _, m := range in {
+		recorder.From.Out <- m
+		<-recorder.To.In
+	}
+	expected := [][]Message{
+		{in[0], in[2], in[4]},
+		{in[1], in[3]},
+	}
+	tracks := recorder.Tracks()
+	if len(tracks) != len(expected) {
+		t.Fatalf("Received %d tracks instead of %d", len(tracks), len(expected))
+	}
+	for i, track := range tracks {
+		if len(track) != len(expected[i]) {
+			t.Errorf("Received %v in track %d instead of %v", track, i, expected[i])
+			continue
+		}
+		for j, m := range track {
+			if m.Message != expected[i][j] {
+				t.Errorf("Received %v at %d in track %d instead of %v", m.Message, j, i, expected[i][j])
+			}
+			if j > 0 && m.Time < track[j-1].Time {
+				t.Errorf("Received %v at %v, before the previous message in track %d", m, m.Time, i)
+			}
+		}
+	}
+}
+
+func TestSortTimed(t *testing.T) {
+	events := Track{
+		{10, NoteOn{0, 60, 100}},
+		{10, ControlChange{0, 64, 127, ""}},
+		{10, NoteOff{0, 60, 0}},
+		{0, NoteOn{0, 62, 100}},
+		{10, NoteOn{0, 62, 0}},
+	}
+	expected := []Message{
+		NoteOn{0, 62, 100},
+		NoteOff{0, 60, 0},
+		NoteOn{0, 62, 0},
+		ControlChange{0, 64, 127, ""},
+		NoteOn{0, 60, 100},
+	}
+	sortTimed(events)
+	for i, e := range events {
+		if e.Message != expected[i] {
+			t.Errorf("Received %v at position %d instead of %v", e.Message, i, expected[i])
+		}
+	}
+}
@@ -0,0 +1,415 @@
+/*
+Package rtpmidi joins AppleMIDI network sessions, as shared by the "Network"
+MIDI driver of macOS and iOS, so their MIDI data can be used with the
+connectors of package midi.
+
+An AppleMIDI peer listens on a pair of consecutive UDP ports, a control port
+and a data port. A session is started by inviting the peer on both ports,
+after which the clocks of the two are synchronized and MIDI data is exchanged
+as RTP packets (RFC 6295) on the data port.
+*/
+package rtpmidi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+const (
+	signature       = 0xFFFF
+	protocolVersion = 2
+	payloadType     = 0x61
+)
+
+// Session commands, sent on either port.
+const (
+	invitation         = "IN"
+	invitationAccepted = "OK"
+	invitationRejected = "NO"
+	endSession         = "BY"
+	clockSync          = "CK"
+	receiverFeedback   = "RS"
+)
+
+var ErrRejected = errors.New("Invitation rejected.")
+
+// How long to wait for a reply to an invitation, and how often to ask.
+var (
+	InvitationTimeout = time.Second
+	InvitationRetries = 3
+)
+
+// How often the clocks of a session are synchronized after it starts.
+var SyncInterval = 10 * time.Second
+
+// A Session is a Device for MIDI data sent to and from an AppleMIDI peer.
+type Session struct {
+	*midi.Device
+	Name     string // Identifies the session to the peer.
+	PeerName string // The name the peer gave when accepting the invitation.
+	ssrc     uint32
+	token    uint32
+	start    time.Time
+	control  *net.UDPConn
+	data     *net.UDPConn
+	sequence uint16
+	mu       sync.Mutex
+	offset   int64 // Peer clock minus session clock, in 100µs units.
+
+	disconnect chan bool
+}
+
+// Starts a session with the peer whose control port is at the address,
+// e.g. "192.168.1.2:5004".
+func Dial(name, address string) (*Session, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	controlAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	dataAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(p+1)))
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		Device:     midi.NewDevice(),
+		Name:       name,
+		ssrc:       rand.Uint32(),
+		token:      rand.Uint32(),
+		start:      time.Now(),
+		disconnect: make(chan bool),
+	}
+	if s.control, err = net.DialUDP("udp", nil, controlAddr); err != nil {
+		return nil, err
+	}
+	if s.data, err = net.DialUDP("udp", nil, dataAddr); err != nil {
+		s.control.Close()
+		return nil, err
+	}
+	for _, conn := range []*net.UDPConn{s.control, s.data} {
+		if err = s.invite(conn); err != nil {
+			s.control.Close()
+			s.data.Close()
+			return nil, fmt.Errorf("Session with %v: %w", address, err)
+		}
+	}
+	go s.readControl()
+	go s.readData()
+	go s.write()
+	go s.sync()
+	return s, nil
+}
+
+// Returns the time since the session started, in the 100µs units of AppleMIDI timestamps.
+func (s *Session) now() uint64 {
+	return uint64(time.Since(s.start) / (100 * time.Microsecond))
+}
+
+// Returns the difference between the peer's clock and the session's, as of
+// the last synchronization.
+func (s *Session) Offset() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.offset) * 100 * time.Microsecond
+}
+
+func (s *Session) command(name string) *bytes.Buffer {
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.BigEndian, uint16(signature))
+	b.WriteString(name)
+	return b
+}
+
+// Invites the peer on the port of a connection, waiting for it to accept.
+func (s *Session) invite(conn *net.UDPConn) error {
+	b := s.command(invitation)
+	binary.Write(b, binary.BigEndian, []uint32{protocolVersion, s.token, s.ssrc})
+	b.WriteString(s.Name)
+	b.WriteByte(0)
+	reply := make([]byte, 1500)
+	for i := 0; i < InvitationRetries; i++ {
+		if _, err := conn.Write(b.Bytes()); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(InvitationTimeout))
+		n, err := conn.Read(reply)
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			continue
+		} else if err != nil {
+			return err
+		}
+		name, p := parseCommand(reply[:n])
+		if len(p) < 12 || binary.BigEndian.Uint32(p[4:]) != s.token {
+			continue
+		}
+		conn.SetReadDeadline(time.Time{})
+		switch name {
+		case invitationAccepted:
+			if i := bytes.IndexByte(p[12:], 0); i >= 0 {
+				s.PeerName = string(p[12 : 12+i])
+			}
+			return nil
+		case invitationRejected:
+			return ErrRejected
+		}
+	}
+	return errors.New("No reply to invitation.")
+}
+
+// Returns the name and payload of a session command, or an empty name if
+// the packet isn't one.
+func parseCommand(p []byte) (string, []byte) {
+	if len(p) < 4 || binary.BigEndian.Uint16(p) != signature {
+		return "", nil
+	}
+	return string(p[2:4]), p[4:]
+}
+
+// Sends a clock synchronization message with the given count and timestamps.
+func (s *Session) sendClockSync(count byte, timestamps [3]uint64) error {
+	b := s.command(clockSync)
+	binary.Write(b, binary.BigEndian, s.ssrc)
+	b.Write([]byte{count, 0, 0, 0})
+	binary.Write(b, binary.BigEndian, timestamps)
+	_, err := s.data.Write(b.Bytes())
+	return err
+}
+
+// Answers a clock synchronization message from the peer.
+func (s *Session) clockSync(p []byte) {
+	if len(p) < 32 {
+		return
+	}
+	var ts [3]uint64
+	binary.Read(bytes.NewReader(p[8:]), binary.BigEndian, &ts)
+	switch p[4] {
+	case 0:
+		ts[1] = s.now()
+		s.sendClockSync(1, ts)
+	case 1:
+		ts[2] = s.now()
+		s.mu.Lock()
+		s.offset = int64(ts[1]) - int64(ts[0]+ts[2])/2
+		s.mu.Unlock()
+		s.sendClockSync(2, ts)
+	}
+}
+
+// Starts clock synchronization, and repeats it until the session ends.
+func (s *Session) sync() {
+	for {
+		s.sendClockSync(0, [3]uint64{s.now()})
+		select {
+		case <-time.After(SyncInterval):
+		case <-s.disconnect:
+			return
+		}
+	}
+}
+
+// Ends the session when the peer does.
+func (s *Session) readControl() {
+	p := make([]byte, 1500)
+	for {
+		n, err := s.control.Read(p)
+		if err != nil {
+			return
+		}
+		if name, _ := parseCommand(p[:n]); name == endSession {
+			midi.Debug.Printf("%v ended by %v", s, s.PeerName)
+			s.close(false)
+			return
+		}
+	}
+}
+
+// Reads MIDI data from the peer to the session's Out wire.
+func (s *Session) readData() {
+	p := make([]byte, 1500)
+	for {
+		n, err := s.data.Read(p)
+		if err != nil {
+			return
+		}
+		if name, payload := parseCommand(p[:n]); name == clockSync {
+			s.clockSync(payload)
+			continue
+		} else if name != "" {
+			continue
+		}
+		messages, err := parsePacket(p[:n])
+		if err != nil {
+			midi.Debug.Printf("%v: %v", s, err)
+		}
+		for _, m := range messages {
+			select {
+			case s.Out <- m:
+			case <-s.disconnect:
+				return
+			}
+		}
+	}
+}
+
+// Writes MIDI data sent to the session to the peer.
+func (s *Session) write() {
+	for {
+		select {
+		case m := <-s.In:
+			s.sequence++
+			if _, err := s.data.Write(s.packet(s.sequence, uint32(s.now()), m)); err != nil {
+				midi.Debug.Printf("%v: %v", s, err)
+			}
+		case <-s.disconnect:
+			return
+		}
+	}
+}
+
+// Returns an RTP packet for a message, without a recovery journal.
+func (s *Session) packet(sequence uint16, timestamp uint32, m midi.Message) []byte {
+	b := new(bytes.Buffer)
+	b.Write([]byte{0x80, payloadType})
+	binary.Write(b, binary.BigEndian, sequence)
+	binary.Write(b, binary.BigEndian, timestamp)
+	binary.Write(b, binary.BigEndian, s.ssrc)
+	var data []byte
+	for i, c := range commands(m) {
+		if i > 0 {
+			data = append(data, 0) // The delta time of each command after the first.
+		}
+		data = append(data, midi.MessageBytes(c)...)
+	}
+	if len(data) < 0x10 {
+		b.WriteByte(byte(len(data)))
+	} else {
+		b.Write([]byte{0x80 | byte(len(data)>>8)&0x0F, byte(len(data))})
+	}
+	b.Write(data)
+	return b.Bytes()
+}
+
+// Returns the messages a message is sent as, each a MIDI command of its own,
+// e.g. the control changes of a midi.ParameterChange.
+func commands(m midi.Message) []midi.Message {
+	if c, ok := m.(interface{ Messages() []midi.Message }); ok {
+		return c.Messages()
+	}
+	return []midi.Message{m}
+}
+
+// Returns the messages in the MIDI command section of an RTP packet.
+func parsePacket(p []byte) ([]midi.Message, error) {
+	if len(p) < 13 || p[0]>>6 != 2 || p[1]&0x7F != payloadType {
+		return nil, fmt.Errorf("Invalid RTP-MIDI packet: % X", p)
+	}
+	header := 12 + 4*int(p[0]&0x0F) // Including the CSRC identifiers counted in the first byte.
+	if len(p) < header {
+		return nil, fmt.Errorf("Truncated RTP-MIDI packet")
+	}
+	p = p[header:]
+	if len(p) == 0 {
+		return nil, nil
+	}
+	flags, length := p[0], int(p[0]&0x0F)
+	p = p[1:]
+	if flags&0x80 != 0 && len(p) > 0 {
+		length = length<<8 | int(p[0])
+		p = p[1:]
+	}
+	if length > len(p) {
+		return nil, fmt.Errorf("Truncated RTP-MIDI packet")
+	}
+	p = p[:length]
+	var messages []midi.Message
+	var status byte
+	first := flags&0x20 == 0 // Without the Z flag the first command has no delta time.
+	for len(p) > 0 {
+		if !first {
+			p = skipDeltaTime(p)
+		}
+		first = false
+		if len(p) == 0 {
+			break
+		}
+		if p[0]&0x80 != 0 {
+			status = p[0]
+		} else if status == 0 {
+			return messages, fmt.Errorf("Running status without a status byte")
+		} else {
+			p = append([]byte{status}, p...) // Running status.
+		}
+		n := midi.MessageLength(int(status))
+		if int(status) == midi.SYSEX {
+			n = bytes.IndexByte(p, 0xF7) + 1
+		}
+		if n <= 0 || n > len(p) {
+			return messages, fmt.Errorf("Truncated MIDI command % X", p)
+		}
+		m, err := midi.ParseMessage(p[:n])
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, m)
+		p = p[n:]
+		if status >= 0xF0 {
+			status = 0 // Only channel messages set the running status.
+		}
+	}
+	return messages, nil
+}
+
+// Returns the bytes following a delta time.
+func skipDeltaTime(p []byte) []byte {
+	for i := 0; i < len(p) && i < 4; i++ {
+		if p[i]&0x80 == 0 {
+			return p[i+1:]
+		}
+	}
+	return nil
+}
+
+func (s *Session) String() string {
+	return fmt.Sprintf("RTP-MIDI session %q", s.Name)
+}
+
+func (s *Session) close(notify bool) error {
+	s.mu.Lock()
+	select {
+	case <-s.disconnect:
+		s.mu.Unlock()
+		return nil
+	default:
+		close(s.disconnect)
+	}
+	s.mu.Unlock()
+	if notify {
+		b := s.command(endSession)
+		binary.Write(b, binary.BigEndian, []uint32{protocolVersion, s.token, s.ssrc})
+		s.control.Write(b.Bytes())
+	}
+	s.control.Close()
+	s.data.Close()
+	return s.Device.Close()
+}
+
+// Ends the session and closes the device.
+func (s *Session) Close() error {
+	return s.close(true)
+}
@@ -0,0 +1,167 @@
+package rtpmidi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// A peer listening on consecutive control and data ports.
+type mockPeer struct {
+	control, data *net.UDPConn
+}
+
+func newMockPeer(t *testing.T) *mockPeer {
+	for i := 0; i < 100; i++ {
+		control, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := control.LocalAddr().(*net.UDPAddr).Port
+		data, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port + 1})
+		if err == nil {
+			return &mockPeer{control, data}
+		}
+		control.Close()
+	}
+	t.Fatal("Could not find consecutive ports")
+	return nil
+}
+
+func (p *mockPeer) close() {
+	p.control.Close()
+	p.data.Close()
+}
+
+// Reads a session command from a connection.
+func (p *mockPeer) expect(t *testing.T, conn *net.UDPConn, name string) ([]byte, *net.UDPAddr) {
+	b := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, addr, err := conn.ReadFromUDP(b)
+	if err != nil {
+		t.Fatalf("Did not receive %v: %v", name, err)
+	}
+	actual, payload := parseCommand(b[:n])
+	if actual != name {
+		t.Fatalf("Received %q instead of %v", actual, name)
+	}
+	return payload, addr
+}
+
+// Accepts an invitation on a connection.
+func (p *mockPeer) accept(t *testing.T, conn *net.UDPConn) {
+	payload, addr := p.expect(t, conn, invitation)
+	if v := binary.BigEndian.Uint32(payload); v != protocolVersion {
+		t.Errorf("Invited with protocol version %d", v)
+	}
+	if name := string(payload[12 : len(payload)-1]); name != "test" {
+		t.Errorf("Invited by %q instead of %q", name, "test")
+	}
+	reply := append([]byte{0xFF, 0xFF, 'O', 'K'}, payload[:8]...)
+	reply = append(reply, 0, 0, 0, 1)
+	reply = append(reply, "peer\x00"...)
+	conn.WriteToUDP(reply, addr)
+}
+
+func TestHandshake(t *testing.T) {
+	peer := newMockPeer(t)
+	defer peer.close()
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		peer.accept(t, peer.control)
+		peer.accept(t, peer.data)
+		payload, addr := peer.expect(t, peer.data, clockSync)
+		if payload[4] != 0 {
+			t.Errorf("Clock sync started with count %d", payload[4])
+		}
+		reply := append([]byte{0xFF, 0xFF, 'C', 'K', 0, 0, 0, 1, 1, 0, 0, 0}, payload[8:32]...)
+		binary.BigEndian.PutUint64(reply[20:], 1000)
+		peer.data.WriteToUDP(reply, addr)
+		payload, _ = peer.expect(t, peer.data, clockSync)
+		if payload[4] != 2 {
+			t.Errorf("Clock sync ended with count %d", payload[4])
+		}
+	}()
+
+	s, err := Dial("test", peer.control.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Could not start session: %v", err)
+	}
+	defer s.Close()
+	if s.PeerName != "peer" {
+		t.Errorf("Peer is named %q instead of %q", s.PeerName, "peer")
+	}
+	<-done
+	if s.Offset() <= 0 {
+		t.Errorf("Clock offset is %v after synchronizing", s.Offset())
+	}
+
+	// MIDI data from the peer, with a delta time and running status.
+	sessionAddr := s.data.LocalAddr().(*net.UDPAddr)
+	packet := []byte{0x80, payloadType, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 6, 0x90, 64, 127, 0, 64, 0}
+	peer.data.WriteToUDP(packet, sessionAddr)
+	for _, expected := range []midi.Message{midi.NoteOn{Key: 64, Velocity: 127}, midi.NoteOn{Key: 64}} {
+		select {
+		case actual := <-s.Out:
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Received %v instead of %v", actual, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not receive %v", expected)
+		}
+	}
+
+	// MIDI data to the peer.
+	s.In <- midi.ControlChange{Channel: 1, ID: 7, Value: 100}
+	b := make([]byte, 1500)
+	peer.data.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := peer.data.Read(b)
+	if err != nil {
+		t.Fatalf("Peer did not receive MIDI data: %v", err)
+	}
+	if !bytes.Equal(b[12:n], []byte{3, 0xB1, 7, 100}) {
+		t.Errorf("Peer received % X", b[:n])
+	}
+
+	s.Close()
+	peer.expect(t, peer.control, endSession)
+}
+
+func TestRejected(t *testing.T) {
+	peer := newMockPeer(t)
+	defer peer.close()
+	go func() {
+		payload, addr := peer.expect(t, peer.control, invitation)
+		reply := append([]byte{0xFF, 0xFF, 'N', 'O'}, payload[:12]...)
+		peer.control.WriteToUDP(reply, addr)
+	}()
+	if _, err := Dial("test", peer.control.LocalAddr().String()); err == nil {
+		t.Error("Session started after invitation was rejected")
+	}
+}
+
+func TestPacketCompound(t *testing.T) {
+	m := midi.ParameterChange{Channel: 2, Parameter: 0x1234, Value: 0x0567}
+	s := &Session{}
+	actual, err := parsePacket(s.packet(1, 0, m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := m.Messages(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Parsed %v from the packet of %v instead of %v", actual, m, expected)
+	}
+}
+
+func TestParseMalformedPacket(t *testing.T) {
+	p := make([]byte, 13)
+	p[0], p[1] = 0x8F, payloadType // 15 CSRC identifiers, which the packet is too short for.
+	if _, err := parsePacket(p); err == nil {
+		t.Error("Parsed a packet shorter than its header")
+	}
+}
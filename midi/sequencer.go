@@ -0,0 +1,114 @@
+package midi
+
+import "sync"
+
+// MIDI clocks per MIDI beat (a 16th note), as counted by SongPosition.
+const ClocksPerBeat = 6
+
+// A StepSequencer transmits MIDI data from one device to another and plays a
+// repeating pattern of steps to the destination device, one step per 16th note,
+// following the clock and transport (start, stop, continue and song position)
+// messages received from the source device.
+// Implements Connector, one to one.
+type StepSequencer struct {
+	Name       string // Identifies the connector in logs.
+	From       *Device
+	To         *Device
+	Steps      [][]Message // The messages played on each step.
+	mu         sync.Mutex
+	position   int // In MIDI beats from the start of the song.
+	clocks     int // Clocks received since the current beat began.
+	playing    bool
+	disconnect chan bool
+}
+
+// Creates a new StepSequencer that plays steps as clocked by the source device.
+func NewStepSequencer(from, to *Device, steps [][]Message) *StepSequencer {
+	return &StepSequencer{
+		From:       from,
+		To:         to,
+		Steps:      steps,
+		disconnect: make(chan bool, 1),
+	}
+}
+
+func (s *StepSequencer) Open() error {
+	if err := s.From.Open(); err != nil {
+		return err
+	}
+	return s.To.Open()
+}
+
+// Ends transmission of MIDI data and closes the connected MIDI devices.
+func (s *StepSequencer) Close() error {
+	Debug.Printf("%v closed", s)
+	s.disconnect <- true
+	if err := s.From.Close(); err != nil {
+		return err
+	}
+	return s.To.Close()
+}
+
+// Begins transmission of MIDI data between the connected MIDI devices.
+func (s *StepSequencer) Connect() {
+	Debug.Printf("%v connected", s)
+	go s.From.Connect()
+	go s.To.Connect()
+	for {
+		select {
+		case m := <-s.From.Out:
+			step := s.follow(m)
+			for _, m := range append([]Message{m}, step...) {
+				if !s.To.sendFrom(m, s.disconnect) {
+					return
+				}
+			}
+		case <-s.disconnect:
+			return
+		}
+	}
+}
+
+// Updates the sequencer's position from a clock or transport message,
+// returning the messages of any step that is due to be played.
+func (s *StepSequencer) follow(m Message) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch n := m.(type) {
+	case Start:
+		s.position, s.clocks, s.playing = 0, 0, true
+	case Continue:
+		s.playing = true
+	case Stop:
+		s.playing = false
+	case SongPosition:
+		// The position is reset to that of an external sequencer (sent while
+		// stopped, ahead of a Continue) so that both play from the same bar.
+		s.position, s.clocks = n.Beats, 0
+	case Clock:
+		if !s.playing {
+			return nil
+		}
+		var step []Message
+		if s.clocks == 0 && len(s.Steps) > 0 {
+			step = s.Steps[s.position%len(s.Steps)]
+		}
+		s.clocks++
+		if s.clocks == ClocksPerBeat {
+			s.position, s.clocks = s.position+1, 0
+		}
+		return step
+	}
+	return nil
+}
+
+// Returns the sequencer's position in MIDI beats (16th notes) from the start of the song.
+func (s *StepSequencer) Position() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.position
+}
+
+func (s *StepSequencer) String() string {
+	return connectorName("StepSequencer", s.Name)
+}
@@ -0,0 +1,42 @@
+package midi
+
+import "testing"
+
+func TestStepSequencerSongPosition(t *testing.T) {
+	steps := make([][]Message, 16)
+	for i := range steps {
+		steps[i] = []Message{NoteOn{9, 36 + i, 100}}
+	}
+	seq := NewStepSequencer(NewDevice(), NewDevice(), steps)
+	seq.Open()
+	go seq.Connect()
+	defer seq.Close()
+	send := func(m Message) {
+		seq.From.Out <- m
+		if actual := <-seq.To.In; actual != m {
+			t.Errorf("Received %v from sequencer instead of %v", actual, m)
+		}
+	}
+
+	send(Start{})
+	send(Clock{})
+	if actual := <-seq.To.In; actual != steps[0][0] {
+		t.Errorf("Received %v from sequencer instead of %v", actual, steps[0][0])
+	}
+	send(Stop{})
+	send(SongPosition{20})
+	if actual := seq.Position(); actual != 20 {
+		t.Errorf("Sequencer is at position %d instead of 20", actual)
+	}
+	send(Continue{})
+	send(Clock{})
+	if actual := <-seq.To.In; actual != steps[4][0] {
+		t.Errorf("Received %v from sequencer instead of %v", actual, steps[4][0])
+	}
+	for i := 1; i < ClocksPerBeat; i++ {
+		send(Clock{})
+	}
+	if actual := seq.Position(); actual != 21 {
+		t.Errorf("Sequencer is at position %d instead of 21", actual)
+	}
+}
@@ -0,0 +1,122 @@
+package midi
+
+// A VelocitySplit transmits MIDI data from one MIDI device to many MIDI devices,
+// sending each note to one device chosen by its velocity, e.g. to layer soft and
+// hard playing on different synths. A note's note off is sent to the same device
+// as its note on. Other MIDI data is sent to every device.
+// Implements Connector, one to many.
+type VelocitySplit struct {
+	Name string // Identifies the connector in logs.
+	From *Device
+	To   []*Device
+	// The lowest velocity sent to each device after the first, in ascending order;
+	// there should be one less threshold than devices.
+	Thresholds []int
+	notes      map[[2]int]*Device // Destinations of sounding notes, by channel and key.
+	disconnect chan bool
+}
+
+// Creates a new VelocitySplit that sends notes with velocities below thresholds[0]
+// to the first device, those from thresholds[0] but below thresholds[1] to the
+// second device, and so on.
+func NewVelocitySplit(from *Device, thresholds []int, to ...*Device) *VelocitySplit {
+	return &VelocitySplit{
+		From:       from,
+		To:         to,
+		Thresholds: thresholds,
+		notes:      make(map[[2]int]*Device),
+		disconnect: make(chan bool, 1),
+	}
+}
+
+func (v *VelocitySplit) Open() error {
+	for _, to := range v.To {
+		if err := to.Open(); err != nil {
+			return err
+		}
+	}
+	return v.From.Open()
+}
+
+// Ends transmission of MIDI data and closes the connected MIDI devices.
+func (v *VelocitySplit) Close() error {
+	Debug.Printf("%v closed", v)
+	v.disconnect <- true
+	if err := v.From.Close(); err != nil {
+		return err
+	}
+	for _, to := range v.To {
+		if err := to.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Begins transmission of MIDI data between the connected MIDI devices.
+func (v *VelocitySplit) Connect() {
+	Debug.Printf("%v connected", v)
+	go v.From.Connect()
+	for _, to := range v.To {
+		go to.Connect()
+	}
+	for {
+		select {
+		case m := <-v.From.Out:
+			switch n := m.(type) {
+			case NoteOn:
+				if n.Velocity == 0 {
+					if !v.release(n.Channel, n.Key, m) {
+						return
+					}
+					continue
+				}
+				to := v.To[v.zone(n.Velocity)]
+				v.notes[[2]int{n.Channel, n.Key}] = to
+				if !to.sendFrom(m, v.disconnect) {
+					return
+				}
+			case NoteOff:
+				if !v.release(n.Channel, n.Key, m) {
+					return
+				}
+			case PolyAftertouch:
+				// Aftertouch goes wherever its note went.
+				if to, ok := v.notes[[2]int{n.Channel, n.Key}]; ok && !to.sendFrom(m, v.disconnect) {
+					return
+				}
+			default:
+				for _, to := range v.To {
+					if !to.sendFrom(m, v.disconnect) {
+						return
+					}
+				}
+			}
+		case <-v.disconnect:
+			return
+		}
+	}
+}
+
+// Sends a note off to wherever its note on was sent. Returns false if the
+// device or the split is closed meanwhile.
+func (v *VelocitySplit) release(channel, key int, m Message) bool {
+	to, ok := v.notes[[2]int{channel, key}]
+	if !ok {
+		to = v.To[0]
+	}
+	delete(v.notes, [2]int{channel, key})
+	return to.sendFrom(m, v.disconnect)
+}
+
+// Returns the index of the device that a velocity is sent to.
+func (v *VelocitySplit) zone(velocity int) (i int) {
+	for i < len(v.Thresholds) && i < len(v.To)-1 && velocity >= v.Thresholds[i] {
+		i++
+	}
+	return i
+}
+
+func (v *VelocitySplit) String() string {
+	return connectorName("VelocitySplit", v.Name)
+}
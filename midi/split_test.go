@@ -0,0 +1,43 @@
+package midi
+
+import "testing"
+
+func TestVelocitySplit(t *testing.T) {
+	soft, hard := NewMemDevice(), NewMemDevice()
+	defer soft.Close()
+	defer hard.Close()
+	src := NewMemDevice()
+	split := NewVelocitySplit(src.Device, []int{80}, soft.Device, hard.Device)
+	split.Open()
+	go split.Connect()
+	defer split.Close()
+	for _, m := range []Message{
+		NoteOn{0, 60, 40},
+		NoteOn{0, 62, 100},
+		NoteOn{0, 64, 80},
+		PolyAftertouch{0, 60, 50},
+		PolyAftertouch{0, 62, 90},
+		NoteOff{0, 60, 0},
+		NoteOn{0, 62, 0},
+		NoteOff{0, 64, 0},
+	} {
+		src.Send(m)
+	}
+	expected := map[*MemDevice][]Message{
+		soft: {NoteOn{0, 60, 40}, PolyAftertouch{0, 60, 50}, NoteOff{0, 60, 0}},
+		hard: {NoteOn{0, 62, 100}, NoteOn{0, 64, 80}, PolyAftertouch{0, 62, 90}, NoteOn{0, 62, 0}, NoteOff{0, 64, 0}},
+	}
+	for dst, name := range map[*MemDevice]string{soft: "soft", hard: "hard"} {
+		actual := waitForReceived(dst, len(expected[dst]))
+		if len(actual) != len(expected[dst]) {
+			t.Errorf("The %v destination received %v instead of %v", name, actual, expected[dst])
+			continue
+		}
+		for i, m := range expected[dst] {
+			if actual[i] != m {
+				t.Errorf("The %v destination received %v instead of %v", name, actual, expected[dst])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package midi
+
+import "time"
+
+// A Swing transmits MIDI data from one device to another, delaying note-ons that
+// fall on off-beat subdivisions so that straight rhythms are played with swing.
+// Note-offs are delayed by the same amount as their note-on to preserve durations.
+// Implements Connector, one to one.
+type Swing struct {
+	Name        string // Identifies the connector in logs.
+	From        *Device
+	To          *Device
+	Subdivision time.Duration // The length of a straight subdivision, e.g. an 8th note.
+	// Where each off-beat lands between its surrounding on-beats, as a percentage:
+	// 50 is straight, 66 is a triplet feel and 75 is a dotted (hard) swing.
+	Percent    float64
+	now        func() time.Time
+	start      time.Time
+	delays     map[[2]int]time.Duration // Delays applied to sounding notes, by channel and key.
+	delayed    *noteTimers              // Stopped when the swing is closed.
+	disconnect chan bool
+}
+
+// Creates a new Swing that swings notes by the given percentage of the subdivision.
+func NewSwing(from, to *Device, subdivision time.Duration, percent float64) *Swing {
+	return &Swing{
+		From:        from,
+		To:          to,
+		Subdivision: subdivision,
+		Percent:     percent,
+		now:         time.Now,
+		delays:      make(map[[2]int]time.Duration),
+		delayed:     newNoteTimers(),
+		disconnect:  make(chan bool, 1),
+	}
+}
+
+func (s *Swing) Open() error {
+	if err := s.From.Open(); err != nil {
+		return err
+	}
+	return s.To.Open()
+}
+
+// Ends transmission of MIDI data and closes the connected MIDI devices.
+func (s *Swing) Close() error {
+	Debug.Printf("%v closed", s)
+	s.disconnect <- true
+	s.delayed.stop()
+	if err := s.From.Close(); err != nil {
+		return err
+	}
+	return s.To.Close()
+}
+
+// Begins transmission of MIDI data between the connected MIDI devices.
+// The clock that subdivisions are measured against starts when Connect is called.
+func (s *Swing) Connect() {
+	go s.From.Connect()
+	go s.To.Connect()
+	Debug.Printf("%v connected", s)
+	s.start = s.now()
+	for {
+		select {
+		case m := <-s.From.Out:
+			s.forward(m)
+		case <-s.disconnect:
+			return
+		}
+	}
+}
+
+func (s *Swing) String() string {
+	return connectorName("Swing", s.Name)
+}
+
+func (s *Swing) forward(m Message) {
+	var d time.Duration
+	switch n := m.(type) {
+	case NoteOn:
+		if n.Velocity == 0 {
+			d = s.release(n.Channel, n.Key)
+			break
+		}
+		d = s.delay(s.now())
+		s.delays[[2]int{n.Channel, n.Key}] = d
+	case NoteOff:
+		d = s.release(n.Channel, n.Key)
+	}
+	if d <= 0 {
+		s.To.send(m)
+		return
+	}
+	s.delayed.add(d, func() { s.To.send(m) })
+}
+
+func (s *Swing) release(channel, key int) time.Duration {
+	d := s.delays[[2]int{channel, key}]
+	delete(s.delays, [2]int{channel, key})
+	return d
+}
+
+// Returns how long a note-on received at time t should be delayed.
+func (s *Swing) delay(t time.Time) time.Duration {
+	if s.Subdivision <= 0 {
+		return 0
+	}
+	elapsed := t.Sub(s.start)
+	n := (elapsed + s.Subdivision/2) / s.Subdivision // The nearest subdivision.
+	if n%2 == 0 {
+		return 0
+	}
+	return time.Duration(float64(2*s.Subdivision) * (s.Percent - 50) / 100)
+}
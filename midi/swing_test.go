@@ -0,0 +1,76 @@
+package midi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSwingDelay(t *testing.T) {
+	s := NewSwing(NewDevice(), NewDevice(), 100*time.Millisecond, 75)
+	s.start = time.Now()
+	for i, expected := range []time.Duration{0, 50, 0, 50, 0} {
+		expected *= time.Millisecond
+		// Straight 8ths, played slightly early or late.
+		at := s.start.Add(time.Duration(i)*s.Subdivision + 3*time.Millisecond)
+		if actual := s.delay(at); actual != expected {
+			t.Errorf("Received a delay of %v for 8th %d instead of %v", actual, i, expected)
+		}
+	}
+}
+
+func TestSwing(t *testing.T) {
+	start := time.Now()
+	now := start
+	swing := NewSwing(NewDevice(), NewDevice(), 100*time.Millisecond, 75)
+	swing.now = func() time.Time { return now }
+	if err := swing.Open(); err != nil {
+		t.Errorf("Could not open swing: %v", err)
+	}
+	go swing.Connect()
+	defer swing.Close()
+
+	expectDelay := func(m Message, min, max time.Duration) {
+		sent := time.Now()
+		swing.From.Out <- m
+		actual := <-swing.To.In
+		elapsed := time.Since(sent)
+		if actual != m {
+			t.Errorf("Received %v from swing instead of %v", actual, m)
+		}
+		if elapsed < min || elapsed > max {
+			t.Errorf("Received %v after %v instead of between %v and %v",
+				actual, elapsed, min, max)
+		}
+	}
+	expectDelay(NoteOn{0, 60, 100}, 0, 25*time.Millisecond)
+	now = start.Add(50 * time.Millisecond)
+	expectDelay(NoteOff{0, 60, 0}, 0, 25*time.Millisecond)
+	now = start.Add(100 * time.Millisecond)
+	expectDelay(NoteOn{0, 62, 100}, 40*time.Millisecond, 150*time.Millisecond)
+	now = start.Add(150 * time.Millisecond)
+	expectDelay(NoteOff{0, 62, 0}, 40*time.Millisecond, 150*time.Millisecond)
+	now = start.Add(200 * time.Millisecond)
+	expectDelay(NoteOn{0, 64, 100}, 0, 25*time.Millisecond)
+}
+
+func TestSwingClose(t *testing.T) {
+	now := time.Now()
+	swing := NewSwing(NewDevice(), NewDevice(), 100*time.Millisecond, 75)
+	swing.now = func() time.Time {
+		defer func() { now = now.Add(100 * time.Millisecond) }()
+		return now // The start, then the next 8th, an off-beat.
+	}
+	swing.To.In = make(chan Message, 1)
+	if err := swing.Open(); err != nil {
+		t.Fatal(err)
+	}
+	go swing.Connect()
+	swing.From.Out <- NoteOn{0, 62, 100} // Delayed by 50ms.
+	if err := swing.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if n := len(swing.To.In); n != 0 {
+		t.Errorf("Sent %d delayed messages after the swing was closed", n)
+	}
+}
@@ -0,0 +1,252 @@
+package midi
+
+/*
+TCPServer and TCPClient are Devices that bridge MIDI data over TCP, so that
+connectors on two machines can be joined by a network. MIDI data sent to
+either device is received from the other, and each server may have many
+clients. Messages are sent in the compact binary encoding of EncodeMessage.
+*/
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long writing a message to a connection may take before the connection
+// is dropped, so that a stalled peer doesn't hold up the others.
+const tcpWriteTimeout = time.Second
+
+// Reads messages from a connection to a device's Out wire until the connection
+// fails or done is closed.
+func readFrames(conn net.Conn, d *Device, done <-chan bool) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		m, err := DecodeMessage(r)
+		if err != nil {
+			Debug.Printf("Stopped reading from %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+		select {
+		case d.Out <- m:
+		case <-done:
+			return
+		}
+	}
+}
+
+// Writes a message to a connection, closing the connection if it fails or
+// takes longer than the tcpWriteTimeout.
+func writeFrame(conn net.Conn, m Message) error {
+	conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	err := EncodeMessage(conn, m)
+	if err != nil {
+		conn.Close()
+	}
+	return err
+}
+
+// A TCPServer is a Device for MIDI data sent to and from TCPClients.
+type TCPServer struct {
+	*Device
+	listener   net.Listener
+	mu         sync.Mutex
+	conns      map[net.Conn]bool
+	disconnect chan bool
+	closed     sync.Once
+}
+
+// Creates a new TCPServer listening on the address, e.g. ":5004".
+func NewTCPServer(address string) (*TCPServer, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	s := &TCPServer{
+		Device:     NewDevice(),
+		listener:   l,
+		conns:      make(map[net.Conn]bool),
+		disconnect: make(chan bool),
+	}
+	go s.accept()
+	go s.write()
+	return s, nil
+}
+
+// Returns the address the server is listening on.
+func (s *TCPServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *TCPServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		select {
+		case <-s.disconnect:
+			s.mu.Unlock()
+			conn.Close() // Accepted as the server closed.
+			return
+		default:
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go func() {
+			readFrames(conn, s.Device, s.disconnect)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Writes MIDI data sent to the server to every client.
+func (s *TCPServer) write() {
+	var conns []net.Conn
+	for {
+		select {
+		case m := <-s.In:
+			s.mu.Lock()
+			conns = conns[:0]
+			for conn := range s.conns {
+				conns = append(conns, conn)
+			}
+			s.mu.Unlock()
+			for _, conn := range conns {
+				writeFrame(conn, m)
+			}
+		case <-s.disconnect:
+			return
+		}
+	}
+}
+
+// Stops listening, disconnects all clients and closes the device. Closing a
+// closed server does nothing.
+func (s *TCPServer) Close() error {
+	first := false
+	s.closed.Do(func() {
+		first = true
+		close(s.disconnect)
+	})
+	if !first {
+		return nil
+	}
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	if closeErr := s.Device.Close(); closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+// A TCPClient is a Device for MIDI data sent to and from a TCPServer.
+// If the connection to the server is lost the client reconnects, holding
+// on to MIDI data sent to it until it has.
+type TCPClient struct {
+	*Device
+	address       string
+	RetryInterval time.Duration // How long to wait between attempts to connect.
+	mu            sync.Mutex
+	conn          net.Conn
+	disconnect    chan bool
+	closed        sync.Once
+}
+
+// The default time between a TCPClient's attempts to connect.
+const DefaultRetryInterval = time.Second
+
+// Creates a new TCPClient, which connects to the server at the address in the background.
+func NewTCPClient(address string) *TCPClient {
+	c := &TCPClient{
+		Device:        NewDevice(),
+		address:       address,
+		RetryInterval: DefaultRetryInterval,
+		disconnect:    make(chan bool),
+	}
+	go c.dial()
+	go c.write()
+	return c
+}
+
+// Connects (and reconnects) to the server until the client is closed.
+func (c *TCPClient) dial() {
+	for {
+		conn, err := net.Dial("tcp", c.address)
+		if err == nil {
+			c.mu.Lock()
+			select {
+			case <-c.disconnect:
+				c.mu.Unlock()
+				conn.Close() // Connected as the client closed.
+				return
+			default:
+			}
+			c.conn = conn
+			c.mu.Unlock()
+			readFrames(conn, c.Device, c.disconnect)
+			c.mu.Lock()
+			c.conn = nil
+			c.mu.Unlock()
+		} else {
+			Debug.Printf("Could not connect to %v: %v", c.address, err)
+		}
+		select {
+		case <-time.After(c.RetryInterval):
+		case <-c.disconnect:
+			return
+		}
+	}
+}
+
+// Writes MIDI data sent to the client to the server.
+func (c *TCPClient) write() {
+	for {
+		select {
+		case m := <-c.In:
+			for {
+				c.mu.Lock()
+				conn := c.conn
+				c.mu.Unlock()
+				if conn != nil && writeFrame(conn, m) == nil {
+					break
+				}
+				select {
+				case <-time.After(10 * time.Millisecond):
+				case <-c.disconnect:
+					return
+				}
+			}
+		case <-c.disconnect:
+			return
+		}
+	}
+}
+
+// Disconnects from the server and closes the device. Closing a closed client
+// does nothing.
+func (c *TCPClient) Close() error {
+	first := false
+	c.closed.Do(func() {
+		first = true
+		close(c.disconnect)
+	})
+	if !first {
+		return nil
+	}
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.mu.Unlock()
+	return c.Device.Close()
+}
@@ -0,0 +1,75 @@
+package midi
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTCP(t *testing.T) {
+	server, err := NewTCPServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	defer server.Close()
+	client := NewTCPClient(server.Addr().String())
+	defer client.Close()
+
+	for _, expected := range []Message{
+		NoteOn{0, 64, 127},
+		SysEx{[]byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+		NoteOff{0, 64, 0},
+	} {
+		client.In <- expected
+		select {
+		case actual := <-server.Out:
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Server received %v instead of %v", actual, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Server did not receive %v", expected)
+		}
+	}
+
+	expected := ControlChange{1, 7, 100, ControlChangeNames[7]}
+	server.In <- expected
+	select {
+	case actual := <-client.Out:
+		if actual != expected {
+			t.Errorf("Client received %v instead of %v", actual, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Client did not receive %v", expected)
+	}
+}
+
+func TestTCPClose(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	server, err := NewTCPServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	client := NewTCPClient(server.Addr().String())
+	client.In <- NoteOn{0, 64, 127}
+	client.In <- NoteOff{0, 64, 0} // Taken once the first is written.
+	// Nothing reads the server's Out wire, so its reader waits to send.
+	for _, c := range []interface{ Close() error }{server, server, client, client} {
+		closed := make(chan bool)
+		go func() {
+			c.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatalf("Closing %T did not return", c)
+		}
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines were left running after closing", runtime.NumGoroutine()-goroutines)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
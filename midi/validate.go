@@ -0,0 +1,130 @@
+package midi
+
+import "fmt"
+
+// Returns an error if the bytes aren't a single MIDI message as sent over the
+// wire: a defined status byte followed by the data bytes (each below 0x80) it
+// calls for, or a SysEx message terminated by 0xF7.
+func ValidateMessage(b []byte) error {
+	if len(b) == 0 {
+		return fmt.Errorf("Empty message: %w", ErrInvalidMessage)
+	}
+	if b[0] < 0x80 {
+		return fmt.Errorf("Message % X has no status byte: %w", b, ErrInvalidMessage)
+	}
+	data := b[1:]
+	if int(b[0]) == SYSEX {
+		if len(b) < 2 || b[len(b)-1] != 0xF7 {
+			return fmt.Errorf("SysEx message % X is not terminated by 0xF7: %w", b, ErrInvalidMessage)
+		}
+		data = b[1 : len(b)-1]
+	} else if length := MessageLength(int(b[0])); length == 0 {
+		return fmt.Errorf("Message % X has an undefined status: %w", b, ErrInvalidMessage)
+	} else if len(b) != length {
+		return fmt.Errorf("Message % X is not %d bytes long: %w", b, length, ErrInvalidMessage)
+	}
+	for _, c := range data {
+		if c >= 0x80 {
+			return fmt.Errorf("Message % X has a status byte as data: %w", b, ErrInvalidMessage)
+		}
+	}
+	return nil
+}
+
+// Returns an error for the first of the values that is out of its range.
+func validate(m Message, values ...field) error {
+	for _, v := range values {
+		if v.value < 0 || v.value > v.max {
+			return fmt.Errorf("%T %v %d is not from 0 to %d: %w", m, v.name, v.value, v.max, ErrInvalidMessage)
+		}
+	}
+	return nil
+}
+
+// A field of a message, and the largest value it can hold.
+type field struct {
+	name       string
+	value, max int
+}
+
+func channelField(channel int) field       { return field{"channel", channel, 15} }
+func dataField(name string, v int) field   { return field{name, v, 127} }
+func data14Field(name string, v int) field { return field{name, v, 16383} }
+
+func (m message) Validate() error { return ValidateMessage(MessageBytes(m)) }
+
+func (n NoteOn) Validate() error {
+	return validate(n, channelField(n.Channel), dataField("key", n.Key), dataField("velocity", n.Velocity))
+}
+
+func (n NoteOff) Validate() error {
+	return validate(n, channelField(n.Channel), dataField("key", n.Key), dataField("velocity", n.Velocity))
+}
+
+func (p PolyAftertouch) Validate() error {
+	return validate(p, channelField(p.Channel), dataField("key", p.Key), dataField("pressure", p.Pressure))
+}
+
+func (c ControlChange) Validate() error {
+	return validate(c, channelField(c.Channel), dataField("ID", c.ID), dataField("value", c.Value))
+}
+
+func (p ProgramChange) Validate() error {
+	return validate(p, channelField(p.Channel), dataField("program", p.Program))
+}
+
+func (c ChannelPressure) Validate() error {
+	return validate(c, channelField(c.Channel), dataField("pressure", c.Pressure))
+}
+
+func (p PitchBend) Validate() error {
+	return validate(p, channelField(p.Channel), data14Field("value", p.Value))
+}
+
+func (s SysEx) Validate() error { return ValidateMessage(s.Data) }
+
+func (c Clock) Validate() error { return nil }
+
+func (s Start) Validate() error { return nil }
+
+func (c Continue) Validate() error { return nil }
+
+func (s Stop) Validate() error { return nil }
+
+func (s SongPosition) Validate() error {
+	return validate(s, data14Field("beats", s.Beats))
+}
+
+func (s SongSelect) Validate() error { return validate(s, dataField("song", s.Song)) }
+
+func (q QuarterFrame) Validate() error {
+	return validate(q, field{"piece", q.Piece, 7}, field{"value", q.Value, 15})
+}
+
+func (h HighResControlChange) Validate() error {
+	return validate(h, channelField(h.Channel), field{"ID", h.ID, 31}, data14Field("value", h.Value))
+}
+
+func (p ParameterChange) Validate() error {
+	return validate(p, channelField(p.Channel), data14Field("parameter", p.Parameter), data14Field("value", p.Value))
+}
+
+func (p PatchSelect) Validate() error {
+	return validate(p, channelField(p.Channel), data14Field("bank", p.Bank), dataField("program", p.Program))
+}
+
+func (a AllSoundOff) Validate() error { return validate(a, channelField(a.Channel)) }
+
+func (r ResetAllControllers) Validate() error { return validate(r, channelField(r.Channel)) }
+
+func (l LocalControl) Validate() error { return validate(l, channelField(l.Channel)) }
+
+func (a AllNotesOff) Validate() error { return validate(a, channelField(a.Channel)) }
+
+func (o OmniMode) Validate() error { return validate(o, channelField(o.Channel)) }
+
+func (m MonoMode) Validate() error {
+	return validate(m, channelField(m.Channel), field{"channels", m.Channels, 16})
+}
+
+func (p PolyMode) Validate() error { return validate(p, channelField(p.Channel)) }
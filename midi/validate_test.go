@@ -0,0 +1,56 @@
+package midi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []Message{
+		NoteOn{15, 127, 127},
+		NoteOff{0, 0, 0},
+		PitchBend{0, 16383},
+		SysEx{[]byte{0xF0, 0x7E, 0xF7}},
+		Clock{},
+		QuarterFrame{7, 15},
+		PatchSelect{0, 16383, 127},
+		MonoMode{0, 16},
+		TimedMessage{0, NoteOn{0, 60, 100}},
+	}
+	for _, m := range valid {
+		if err := m.Validate(); err != nil {
+			t.Errorf("Received %v from validating %v", err, m)
+		}
+	}
+	invalid := []Message{
+		NoteOn{16, 60, 100},
+		NoteOff{0, 128, 0},
+		ControlChange{0, 7, -1, ""},
+		PitchBend{0, 16384},
+		SysEx{[]byte{0xF0, 0x80, 0xF7}},
+		SysEx{[]byte{0xF0, 0x7E}},
+		SongPosition{1 << 14},
+		QuarterFrame{8, 0},
+		HighResControlChange{0, 32, 0},
+		AllNotesOff{-1},
+		TimedMessage{0, ProgramChange{0, 200}},
+	}
+	for _, m := range invalid {
+		if err := m.Validate(); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Received %v from validating %v instead of %v", err, m, ErrInvalidMessage)
+		}
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	for _, b := range [][]byte{{0x90, 60, 100}, {0xC0, 5}, {0xF8}, {0xF0, 0x01, 0xF7}} {
+		if err := ValidateMessage(b); err != nil {
+			t.Errorf("Received %v from validating % X", err, b)
+		}
+	}
+	for _, b := range [][]byte{nil, {60, 100}, {0x90, 60}, {0x90, 60, 0x80}, {0xF4}, {0xC0, 5, 6}, {0xF0, 0x01}} {
+		if err := ValidateMessage(b); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Received %v from validating % X instead of %v", err, b, ErrInvalidMessage)
+		}
+	}
+}
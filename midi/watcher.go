@@ -0,0 +1,138 @@
+package midi
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aoeu/audio/midi/portmidi"
+	"time"
+)
+
+// Sent by a DeviceWatcher when a device is plugged in or unplugged.
+type DeviceEvent interface {
+	Info() DeviceInfo
+}
+
+// Sent by a DeviceWatcher when a device is plugged in.
+type DeviceAdded struct {
+	DeviceInfo
+}
+
+func (d DeviceAdded) Info() DeviceInfo {
+	return d.DeviceInfo
+}
+
+// Sent by a DeviceWatcher when a device is unplugged.
+type DeviceRemoved struct {
+	DeviceInfo
+}
+
+func (d DeviceRemoved) Info() DeviceInfo {
+	return d.DeviceInfo
+}
+
+// The interval a DeviceWatcher lists devices at by default.
+const DefaultWatchInterval = time.Second
+
+// A DeviceWatcher lists the system's devices every Interval, sending a
+// DeviceAdded or DeviceRemoved from its Events channel for each device
+// plugged in or unplugged since, by name and direction, so that long running programs can
+// open the devices that are plugged in. The devices listed when it is started
+// are not sent.
+//
+// PortMidi can't list devices again while any of them are open, so built
+// with it, the watcher only sees devices plugged in or unplugged while none
+// are. The other backends list them again at any time.
+type DeviceWatcher struct {
+	Events     chan DeviceEvent
+	Interval   time.Duration
+	list       func() ([]DeviceInfo, error) // Replaces refreshDevices in tests.
+	disconnect chan bool
+}
+
+// Creates a DeviceWatcher listing devices every DefaultWatchInterval.
+// Configure it and then Start it.
+func NewDeviceWatcher() *DeviceWatcher {
+	return &DeviceWatcher{
+		Events:     make(chan DeviceEvent),
+		Interval:   DefaultWatchInterval,
+		list:       refreshDevices,
+		disconnect: make(chan bool),
+	}
+}
+
+// Lists the system's devices again before listing them, unless the backend
+// can't while devices are open.
+func refreshDevices() ([]DeviceInfo, error) {
+	if err := portmidi.Refresh(); err != nil && !errors.Is(err, portmidi.ErrStreamsOpen) {
+		return nil, err
+	}
+	return ListDevices()
+}
+
+// Identifies a listed device, as an input and an output may share a name.
+type watchedDevice struct {
+	Name    string
+	In, Out bool
+}
+
+func watched(d DeviceInfo) watchedDevice {
+	return watchedDevice{d.Name, d.In, d.Out}
+}
+
+// Begins watching for devices, unless the Interval isn't positive.
+func (w *DeviceWatcher) Start() error {
+	if w.Interval <= 0 {
+		return fmt.Errorf("Invalid watch interval %v", w.Interval)
+	}
+	known := make(map[watchedDevice]DeviceInfo)
+	if infos, err := w.list(); err == nil {
+		for _, d := range infos {
+			known[watched(d)] = d
+		}
+	}
+	go w.run(known)
+	return nil
+}
+
+func (w *DeviceWatcher) run(known map[watchedDevice]DeviceInfo) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.disconnect:
+			return
+		}
+		infos, err := w.list()
+		if err != nil {
+			continue // The devices are listed again at the next tick.
+		}
+		listed := make(map[watchedDevice]DeviceInfo, len(infos))
+		var events []DeviceEvent
+		for _, d := range infos {
+			listed[watched(d)] = d
+			if _, ok := known[watched(d)]; !ok {
+				events = append(events, DeviceAdded{d})
+			}
+		}
+		for key, d := range known {
+			if _, ok := listed[key]; !ok {
+				events = append(events, DeviceRemoved{d})
+			}
+		}
+		known = listed
+		for _, e := range events {
+			select {
+			case w.Events <- e:
+			case <-w.disconnect:
+				return
+			}
+		}
+	}
+}
+
+// Stops watching for devices.
+func (w *DeviceWatcher) Close() error {
+	close(w.disconnect)
+	return nil
+}
@@ -0,0 +1,84 @@
+package midi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceWatcher(t *testing.T) {
+	keys, synth := DeviceInfo{Name: "Keys", Out: true}, DeviceInfo{Name: "Synth", In: true}
+	listings := make(chan []DeviceInfo, 3)
+	listings <- []DeviceInfo{keys}
+	listings <- []DeviceInfo{keys, synth}
+	listings <- []DeviceInfo{synth}
+	w := NewDeviceWatcher()
+	w.Interval = time.Millisecond
+	w.list = func() ([]DeviceInfo, error) {
+		select {
+		case l := <-listings:
+			return l, nil
+		default:
+			return []DeviceInfo{synth}, nil
+		}
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	expected := []DeviceEvent{DeviceAdded{synth}, DeviceRemoved{keys}}
+	for _, e := range expected {
+		select {
+		case actual := <-w.Events:
+			if actual != e {
+				t.Errorf("Received %+v instead of %+v", actual, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Received nothing instead of %+v", e)
+		}
+	}
+	select {
+	case e := <-w.Events:
+		t.Errorf("Received %+v once the devices stopped changing", e)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestDeviceWatcherDirections(t *testing.T) {
+	in, out := DeviceInfo{Name: "Synth", In: true}, DeviceInfo{Name: "Synth", Out: true}
+	listings := make(chan []DeviceInfo, 2)
+	listings <- []DeviceInfo{in}
+	listings <- []DeviceInfo{in, out}
+	w := NewDeviceWatcher()
+	w.Interval = time.Millisecond
+	w.list = func() ([]DeviceInfo, error) {
+		select {
+		case l := <-listings:
+			return l, nil
+		default:
+			return []DeviceInfo{out}, nil
+		}
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	expected := []DeviceEvent{DeviceAdded{out}, DeviceRemoved{in}}
+	for _, e := range expected {
+		select {
+		case actual := <-w.Events:
+			if actual != e {
+				t.Errorf("Received %+v instead of %+v", actual, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Received nothing instead of %+v", e)
+		}
+	}
+}
+
+func TestDeviceWatcherInterval(t *testing.T) {
+	w := NewDeviceWatcher()
+	w.Interval = 0
+	if err := w.Start(); err == nil {
+		t.Error("Started watching devices with an interval of 0")
+	}
+}